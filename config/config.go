@@ -0,0 +1,183 @@
+// Package config gathers every startup setting of the forum into one typed
+// struct, read from a JSON file, then the environment, then the command line.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"strings"
+
+	"forum/database"
+	"forum/handlers"
+	"forum/passhash"
+)
+
+// Config holds the settings of one forum process
+type Config struct {
+	// Addr is the address the HTTP server listens on
+	Addr string
+	// SecretFile holds the key that signs emailed links; it is created if missing
+	SecretFile string
+	// PasswordBlocklist is a file of common or breached passwords to refuse; empty disables it
+	PasswordBlocklist string
+	// MakeAdmin names an account to promote to admin at startup
+	MakeAdmin string
+
+	Database database.Config
+	Mail     MailConfig
+	OIDC     OIDCConfig
+	Argon2   passhash.Argon2id
+	Handlers handlers.Config
+}
+
+// MailConfig controls outgoing email
+type MailConfig struct {
+	// OutboxDir is where outgoing emails are written as .eml files
+	OutboxDir string
+	// From is the sender address
+	From string
+}
+
+// OIDCConfig names an OpenID Connect provider offered as a sign-in option.
+// It is disabled while Issuer is empty.
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+}
+
+// maxPasswordLength bounds password-max-length so a login cannot make the server hash megabytes
+const maxPasswordLength = 1024
+
+// Default returns the settings used when nothing is configured
+func Default() *Config {
+	return &Config{
+		Addr:              ":4422",
+		SecretFile:        "./secret.key",
+		PasswordBlocklist: "./data/common-passwords.txt",
+		Database:          database.DefaultConfig(),
+		Mail: MailConfig{
+			OutboxDir: "./outbox",
+			From:      "Forum <no-reply@localhost>",
+		},
+		Argon2:   passhash.DefaultArgon2id,
+		Handlers: handlers.DefaultConfig(),
+	}
+}
+
+// Validate reports every invalid setting at once, each prefixed with the name
+// it is configured under
+func (c *Config) Validate() error {
+	var problems []error
+	check := func(ok bool, name, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Errorf("%s: %s", name, fmt.Sprintf(format, args...)))
+		}
+	}
+
+	_, _, err := net.SplitHostPort(c.Addr)
+	check(err == nil, "addr", "must look like host:port or :port, got %q", c.Addr)
+	baseURL, err := url.Parse(c.Handlers.BaseURL)
+	check(err == nil && (baseURL.Scheme == "http" || baseURL.Scheme == "https") && baseURL.Host != "",
+		"base-url", "must be an absolute http or https URL, got %q", c.Handlers.BaseURL)
+	check(isDir(c.Handlers.StaticDir), "static-dir", "%q is not a directory", c.Handlers.StaticDir)
+	check(isDir(c.Handlers.PagesDir), "pages-dir", "%q is not a directory", c.Handlers.PagesDir)
+	db := c.Database
+	check(db.Path != "" && !strings.Contains(db.Path, "?"), "db-path", "must be a file name without '?', got %q", db.Path)
+	check(oneOf(db.JournalMode, database.JournalModes), "db-journal-mode", "must be one of %s, got %q", strings.Join(database.JournalModes, ", "), db.JournalMode)
+	check(db.Path != database.MemoryPath || oneOf(db.JournalMode, []string{"MEMORY", "OFF"}),
+		"db-journal-mode", "must be MEMORY or OFF for an in-memory database, got %q", db.JournalMode)
+	check(oneOf(db.Synchronous, database.SynchronousModes), "db-synchronous", "must be one of %s, got %q", strings.Join(database.SynchronousModes, ", "), db.Synchronous)
+	check(db.BusyTimeout >= 0, "db-busy-timeout", "must not be negative, got %s", db.BusyTimeout)
+	check(db.MaxOpenConns >= 1, "db-max-open-conns", "must be at least 1, got %d", db.MaxOpenConns)
+	check(db.MaxIdleConns >= 0 && db.MaxIdleConns <= db.MaxOpenConns,
+		"db-max-idle-conns", "must be between 0 and db-max-open-conns (%d), got %d", db.MaxOpenConns, db.MaxIdleConns)
+	check(db.ConnMaxIdleTime >= 0, "db-conn-max-idle-time", "must not be negative, got %s", db.ConnMaxIdleTime)
+	check(c.SecretFile != "", "secret-file", "must not be empty")
+
+	check(c.Mail.OutboxDir != "", "outbox-dir", "must not be empty")
+	_, err = mail.ParseAddress(c.Mail.From)
+	check(err == nil, "mail-from", "must be an email address such as \"Forum <no-reply@example.com>\", got %q", c.Mail.From)
+	check(c.OIDC.Issuer == "" || c.OIDC.ClientID != "", "oidc-client-id", "is required when oidc-issuer is set")
+
+	if err := c.Argon2.Validate(); err != nil {
+		problems = append(problems, fmt.Errorf("argon2: %w", err))
+	}
+	passwords := c.Handlers.Passwords
+	check(passwords.MaxLength >= 1 && passwords.MaxLength <= maxPasswordLength,
+		"password-max-length", "must be between 1 and %d, got %d", maxPasswordLength, passwords.MaxLength)
+	check(passwords.MinLength >= 1 && passwords.MinLength <= passwords.MaxLength,
+		"password-min-length", "must be between 1 and %d, got %d", passwords.MaxLength, passwords.MinLength)
+	check(passwords.MinScore >= 0 && passwords.MinScore <= 4, "password-min-score", "must be between 0 and 4, got %d", passwords.MinScore)
+	check(passwords.HashConcurrency >= 1, "password-hash-concurrency", "must be at least 1, got %d", passwords.HashConcurrency)
+
+	sessions := c.Handlers.Sessions
+	check(sessions.AbsoluteTimeout > 0, "session-max-age", "must be positive, got %s", sessions.AbsoluteTimeout)
+	check(sessions.IdleTimeout > 0, "session-idle-timeout", "must be positive, got %s", sessions.IdleTimeout)
+	// Activity is written back at most once per interval, so a session in use must not time out in between
+	lifetime := min(sessions.IdleTimeout, sessions.AbsoluteTimeout)
+	check(sessions.RenewInterval > 0 && sessions.RenewInterval < lifetime,
+		"session-renew-interval", "must be positive and shorter than session-idle-timeout and session-max-age (%s), got %s", lifetime, sessions.RenewInterval)
+	check(sessions.SweepInterval > 0, "session-sweep-interval", "must be positive, got %s", sessions.SweepInterval)
+	throttle := c.Handlers.LoginThrottle
+	check(throttle.FreeAttempts >= 0, "login-free-attempts", "must not be negative, got %d", throttle.FreeAttempts)
+	check(throttle.IPFreeAttempts >= 0, "login-ip-free-attempts", "must not be negative, got %d", throttle.IPFreeAttempts)
+	check(throttle.BaseDelay > 0, "login-base-delay", "must be positive, got %s", throttle.BaseDelay)
+	check(throttle.MaxDelay >= throttle.BaseDelay, "login-max-delay", "must be at least login-base-delay (%s), got %s", throttle.BaseDelay, throttle.MaxDelay)
+	check(throttle.LockoutThreshold >= 1, "login-lockout-threshold", "must be at least 1, got %d", throttle.LockoutThreshold)
+	check(throttle.IPLockoutThreshold >= 1, "login-ip-lockout-threshold", "must be at least 1, got %d", throttle.IPLockoutThreshold)
+	check(throttle.LockoutDuration > 0, "login-lockout-duration", "must be positive, got %s", throttle.LockoutDuration)
+	check(throttle.ResetAfter > 0, "login-reset-after", "must be positive, got %s", throttle.ResetAfter)
+	check(c.Handlers.EmailVerificationTTL > 0, "email-verification-ttl", "must be positive, got %s", c.Handlers.EmailVerificationTTL)
+	check(c.Handlers.PasswordResetTTL > 0, "password-reset-ttl", "must be positive, got %s", c.Handlers.PasswordResetTTL)
+	check(c.Handlers.OIDCLoginTTL > 0, "oidc-login-ttl", "must be positive, got %s", c.Handlers.OIDCLoginTTL)
+
+	twoFactor := c.Handlers.TwoFactor
+	check(twoFactor.Issuer != "" && !strings.Contains(twoFactor.Issuer, ":"), "two-factor-issuer", "must be non-empty and without ':', got %q", twoFactor.Issuer)
+	check(twoFactor.ChallengeTTL > 0, "two-factor-challenge-ttl", "must be positive, got %s", twoFactor.ChallengeTTL)
+	check(twoFactor.MaxChallengeAttempts >= 1, "two-factor-max-attempts", "must be at least 1, got %d", twoFactor.MaxChallengeAttempts)
+	check(twoFactor.FreshWindow > 0, "two-factor-fresh-window", "must be positive, got %s", twoFactor.FreshWindow)
+	check(twoFactor.RecoveryCodes >= 1, "two-factor-recovery-codes", "must be at least 1, got %d", twoFactor.RecoveryCodes)
+
+	tokens := c.Handlers.APITokens
+	check(tokens.MaxPerUser >= 1, "api-token-max-per-user", "must be at least 1, got %d", tokens.MaxPerUser)
+	check(tokens.MaxNameLength >= 1, "api-token-max-name-length", "must be at least 1, got %d", tokens.MaxNameLength)
+	check(tokens.LastUsedInterval >= 0, "api-token-last-used-interval", "must not be negative, got %s", tokens.LastUsedInterval)
+
+	demographics := c.Handlers.Demographics
+	check(demographics.MaxCustomGender >= 1, "max-custom-gender", "must be at least 1, got %d", demographics.MaxCustomGender)
+	check(demographics.MinAge >= 0, "min-age", "must not be negative, got %d", demographics.MinAge)
+	check(demographics.MaxAge >= demographics.MinAge, "max-age", "must be at least min-age (%d), got %d", demographics.MinAge, demographics.MaxAge)
+
+	registration := c.Handlers.Registration
+	check(registration.MaxInviteTTL > 0, "invite-max-ttl", "must be positive, got %s", registration.MaxInviteTTL)
+	check(registration.DefaultInviteTTL > 0 && registration.DefaultInviteTTL <= registration.MaxInviteTTL,
+		"invite-default-ttl", "must be positive and at most invite-max-ttl (%s), got %s", registration.MaxInviteTTL, registration.DefaultInviteTTL)
+	check(registration.MaxInviteUses >= 1, "invite-max-uses", "must be at least 1, got %d", registration.MaxInviteUses)
+
+	posts := c.Handlers.Posts
+	check(posts.MaxTitleLength >= 1, "post-max-title", "must be at least 1, got %d", posts.MaxTitleLength)
+	check(posts.MaxContentLength >= 1, "post-max-content", "must be at least 1, got %d", posts.MaxContentLength)
+	check(posts.Cooldown >= 0, "post-cooldown", "must not be negative, got %s", posts.Cooldown)
+
+	return errors.Join(problems...)
+}
+
+// oneOf matches value against choices regardless of case
+func oneOf(value string, choices []string) bool {
+	for _, choice := range choices {
+		if strings.EqualFold(value, choice) {
+			return true
+		}
+	}
+	return false
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,188 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dirArgs point the template and static directories at the repository's, which
+// Validate requires to exist
+var dirArgs = []string{"-pages-dir", "../pages", "-static-dir", "../static"}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "forum.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, rest, err := Load(append(dirArgs, "invite", "3"), nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if strings.Join(rest, " ") != "invite 3" {
+		t.Errorf("remaining arguments = %q, want invite 3", rest)
+	}
+	want := Default().Handlers
+	if cfg.Handlers.Sessions != want.Sessions || cfg.Handlers.Passwords != want.Passwords || cfg.Handlers.LoginThrottle != want.LoginThrottle {
+		t.Errorf("settings changed without being configured: %+v", cfg.Handlers)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `{
+		"session-idle-timeout": "10m",
+		"post-max-title": 50,
+		"login-free-attempts": 5,
+		"session": {"renew-interval": "2m"},
+		"gender-options": ["A", "B"]
+	}`)
+	environ := []string{
+		"FORUM_SESSION_IDLE_TIMEOUT=20m",
+		"FORUM_POST_MAX_TITLE=60",
+		"FORUM_MAX_AGE=99",
+		"HOME=/root",
+	}
+	args := append([]string{"-config", path, "-session-idle-timeout", "30m"}, dirArgs...)
+
+	cfg, _, err := Load(args, environ)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	h := cfg.Handlers
+	for _, tt := range []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"flag over environment and file", h.Sessions.IdleTimeout, 30 * time.Minute},
+		{"environment over file", h.Posts.MaxTitleLength, 60},
+		{"environment alone", h.Demographics.MaxAge, 99},
+		{"file alone", h.LoginThrottle.FreeAttempts, 5},
+		{"nested file key", h.Sessions.RenewInterval, 2 * time.Minute},
+		{"file list", strings.Join(h.Demographics.GenderOptions, ","), "A,B"},
+		{"default", h.Posts.MaxContentLength, Default().Handlers.Posts.MaxContentLength},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+
+	// The environment can name the file too
+	cfg, _, err = Load(dirArgs, []string{"FORUM_CONFIG=" + path})
+	if err != nil {
+		t.Fatalf("Load with FORUM_CONFIG: %v", err)
+	}
+	if cfg.Handlers.Posts.MaxTitleLength != 50 {
+		t.Errorf("post-max-title = %d, want 50 from the file named by FORUM_CONFIG", cfg.Handlers.Posts.MaxTitleLength)
+	}
+}
+
+func TestEverySettingCanBeSet(t *testing.T) {
+	environ := []string{
+		"FORUM_PASSWORD_MAX_LENGTH=200",
+		"FORUM_SESSION_RENEW_INTERVAL=5m",
+		"FORUM_MIN_AGE=13",
+		"FORUM_MAX_AGE=120",
+		"FORUM_MAX_CUSTOM_GENDER=30",
+		"FORUM_LOGIN_FREE_ATTEMPTS=4",
+		"FORUM_LOGIN_IP_FREE_ATTEMPTS=40",
+		"FORUM_LOGIN_BASE_DELAY=2s",
+		"FORUM_LOGIN_MAX_DELAY=1m",
+		"FORUM_LOGIN_IP_LOCKOUT_THRESHOLD=80",
+		"FORUM_LOGIN_RESET_AFTER=12h",
+		"FORUM_INVITE_DEFAULT_TTL=48h",
+		"FORUM_INVITE_MAX_TTL=240h",
+		"FORUM_INVITE_MAX_USES=10",
+	}
+	cfg, _, err := Load(dirArgs, environ)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	h := cfg.Handlers
+	for _, tt := range []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"password-max-length", h.Passwords.MaxLength, 200},
+		{"session-renew-interval", h.Sessions.RenewInterval, 5 * time.Minute},
+		{"min-age", h.Demographics.MinAge, 13},
+		{"max-age", h.Demographics.MaxAge, 120},
+		{"max-custom-gender", h.Demographics.MaxCustomGender, 30},
+		{"login-free-attempts", h.LoginThrottle.FreeAttempts, 4},
+		{"login-ip-free-attempts", h.LoginThrottle.IPFreeAttempts, 40},
+		{"login-base-delay", h.LoginThrottle.BaseDelay, 2 * time.Second},
+		{"login-max-delay", h.LoginThrottle.MaxDelay, time.Minute},
+		{"login-ip-lockout-threshold", h.LoginThrottle.IPLockoutThreshold, 80},
+		{"login-reset-after", h.LoginThrottle.ResetAfter, 12 * time.Hour},
+		{"invite-default-ttl", h.Registration.DefaultInviteTTL, 48 * time.Hour},
+		{"invite-max-ttl", h.Registration.MaxInviteTTL, 240 * time.Hour},
+		{"invite-max-uses", h.Registration.MaxInviteUses, 10},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadRejectsInvalidValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		environ []string
+		file    string
+		want    string
+	}{
+		{"renew interval not positive", []string{"-session-renew-interval", "0s"}, nil, "", "session-renew-interval: must be positive"},
+		{"renew interval outlasting the idle timeout", []string{"-session-renew-interval", "2h"}, nil, "", "session-renew-interval: must be positive and shorter"},
+		{"renew interval outlasting the lifetime", []string{"-session-max-age", "30s"}, nil, "", "session-renew-interval"},
+		{"password max below min", []string{"-password-min-length", "20", "-password-max-length", "10"}, nil, "", "password-min-length: must be between 1 and 10"},
+		{"password max too long", []string{"-password-max-length", "5000"}, nil, "", "password-max-length"},
+		{"ages reversed", nil, []string{"FORUM_MIN_AGE=30", "FORUM_MAX_AGE=20"}, "", "max-age: must be at least min-age (30)"},
+		{"negative age", []string{"-min-age", "-1"}, nil, "", "min-age: must not be negative"},
+		{"custom gender length", nil, nil, `{"max-custom-gender": 0}`, "max-custom-gender: must be at least 1"},
+		{"max delay below base", []string{"-login-base-delay", "10s", "-login-max-delay", "1s"}, nil, "", "login-max-delay"},
+		{"invite ttl past max", []string{"-invite-default-ttl", "3000h"}, nil, "", "invite-default-ttl"},
+		{"in-memory database with WAL", []string{"-db-path", ":memory:"}, nil, "", "db-journal-mode: must be MEMORY or OFF"},
+		{"not a duration", nil, []string{"FORUM_POST_COOLDOWN=soon"}, "", `FORUM_POST_COOLDOWN: post-cooldown: invalid value "soon"`},
+		{"unknown environment setting", nil, []string{"FORUM_NO_SUCH_SETTING=1"}, "", `unknown setting "no-such-setting"`},
+		{"unknown file setting", nil, nil, `{"session": {"colour": "blue"}}`, `unknown setting "session-colour"`},
+		{"null in file", nil, nil, `{"post-cooldown": null}`, "null is not a value"},
+		{"unknown flag", []string{"-no-such-flag"}, nil, "", "flag provided but not defined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append(append([]string{}, dirArgs...), tt.args...)
+			if tt.file != "" {
+				args = append(args, "-config", writeConfigFile(t, tt.file))
+			}
+			_, _, err := Load(args, tt.environ)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Load error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	cfg := Default()
+	cfg.Handlers.PagesDir, cfg.Handlers.StaticDir = "../pages", "../static"
+	cfg.Handlers.Sessions.RenewInterval = 0
+	cfg.Handlers.Demographics.MaxCustomGender = 0
+	cfg.Handlers.Passwords.MaxLength = 0
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate accepted invalid settings")
+	}
+	for _, name := range []string{"session-renew-interval", "max-custom-gender", "password-max-length"} {
+		if !strings.Contains(err.Error(), name+":") {
+			t.Errorf("Validate error does not mention %s: %v", name, err)
+		}
+	}
+}
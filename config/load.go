@@ -0,0 +1,268 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"forum/database"
+	"forum/handlers"
+)
+
+// envPrefix starts the environment variables read as settings; FORUM_SESSION_IDLE_TIMEOUT
+// sets session-idle-timeout
+const envPrefix = "FORUM_"
+
+// envConfigFile names the config file when -config is not given
+const envConfigFile = envPrefix + "CONFIG"
+
+// Load builds the configuration from, in increasing precedence, the defaults, the
+// JSON file named by -config or FORUM_CONFIG, FORUM_* environment variables and
+// the command-line flags in args. It returns the arguments left after the flags.
+//
+// Every setting has one name, used as the flag, as the key in the file and, upper-cased
+// with dashes as underscores, in the environment. File keys may also be nested:
+// {"session": {"idle-timeout": "30m"}} is the same as {"session-idle-timeout": "30m"}.
+func Load(args, environ []string) (*Config, []string, error) {
+	cfg := Default()
+	fs := flag.NewFlagSet("forum", flag.ContinueOnError)
+	configFile := fs.String("config", "", "JSON file to read settings from (or set "+envConfigFile+")")
+	cfg.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	// Later sources override earlier ones, and flags given on the command line override both
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	apply := func(source, name, value string) error {
+		f := fs.Lookup(name)
+		if f == nil || name == "config" {
+			return fmt.Errorf("%s: unknown setting %q", source, name)
+		}
+		if explicit[name] {
+			return nil
+		}
+		if err := f.Value.Set(value); err != nil {
+			return fmt.Errorf("%s: %s: invalid value %q: %v", source, name, value, err)
+		}
+		return nil
+	}
+
+	env := make(map[string]string)
+	for _, entry := range environ {
+		if key, value, ok := strings.Cut(entry, "="); ok && strings.HasPrefix(key, envPrefix) {
+			env[key] = value
+		}
+	}
+
+	var problems []error
+	path := *configFile
+	if path == "" {
+		path = env[envConfigFile]
+	}
+	if path != "" {
+		settings, err := readFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, name := range sortedKeys(settings) {
+			if err := apply(path, name, settings[name]); err != nil {
+				problems = append(problems, err)
+			}
+		}
+	}
+
+	for _, key := range sortedKeys(env) {
+		if key == envConfigFile {
+			continue
+		}
+		name := strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(key, envPrefix), "_", "-"))
+		if err := apply("environment variable "+key, name, env[key]); err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	if err := errors.Join(problems...); err != nil {
+		return nil, nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// register binds every setting to a flag of the same name
+func (c *Config) register(fs *flag.FlagSet) {
+	h := &c.Handlers
+
+	fs.StringVar(&c.Addr, "addr", c.Addr, "address the HTTP server listens on")
+	fs.StringVar(&h.BaseURL, "base-url", h.BaseURL, "public URL of the forum, used in emailed links")
+	fs.StringVar(&h.StaticDir, "static-dir", h.StaticDir, "directory of the stylesheets and scripts served under /static/")
+	fs.StringVar(&h.PagesDir, "pages-dir", h.PagesDir, "directory of the HTML templates")
+	fs.StringVar(&c.Database.Path, "db-path", c.Database.Path, "SQLite database file, created if missing, or "+database.MemoryPath+" for a throwaway in-memory database")
+	fs.BoolVar(&c.Database.ForeignKeys, "db-foreign-keys", c.Database.ForeignKeys, "enforce foreign keys and their ON DELETE CASCADE")
+	fs.StringVar(&c.Database.JournalMode, "db-journal-mode", c.Database.JournalMode, "SQLite journal mode: "+strings.Join(database.JournalModes, ", "))
+	fs.StringVar(&c.Database.Synchronous, "db-synchronous", c.Database.Synchronous, "SQLite synchronous mode: "+strings.Join(database.SynchronousModes, ", "))
+	fs.DurationVar(&c.Database.BusyTimeout, "db-busy-timeout", c.Database.BusyTimeout, "how long to wait for another connection's write lock")
+	fs.IntVar(&c.Database.MaxOpenConns, "db-max-open-conns", c.Database.MaxOpenConns, "most database connections open at once")
+	fs.IntVar(&c.Database.MaxIdleConns, "db-max-idle-conns", c.Database.MaxIdleConns, "database connections kept open while idle")
+	fs.DurationVar(&c.Database.ConnMaxIdleTime, "db-conn-max-idle-time", c.Database.ConnMaxIdleTime, "close database connections idle for this long; 0 keeps them")
+	fs.StringVar(&c.SecretFile, "secret-file", c.SecretFile, "file holding the key that signs emailed links; created if missing")
+	fs.BoolVar(&h.SecureCookies, "secure-cookies", h.SecureCookies, "only send session cookies over HTTPS")
+
+	fs.DurationVar(&h.Sessions.AbsoluteTimeout, "session-max-age", h.Sessions.AbsoluteTimeout, "maximum lifetime of a login session")
+	fs.DurationVar(&h.Sessions.IdleTimeout, "session-idle-timeout", h.Sessions.IdleTimeout, "log a session out after this much inactivity")
+	fs.DurationVar(&h.Sessions.RenewInterval, "session-renew-interval", h.Sessions.RenewInterval, "how often a session's last activity is written back")
+	fs.DurationVar(&h.Sessions.SweepInterval, "session-sweep-interval", h.Sessions.SweepInterval, "how often expired sessions are purged")
+	fs.IntVar(&h.LoginThrottle.FreeAttempts, "login-free-attempts", h.LoginThrottle.FreeAttempts, "failed logins for an account before each further attempt is delayed")
+	fs.IntVar(&h.LoginThrottle.IPFreeAttempts, "login-ip-free-attempts", h.LoginThrottle.IPFreeAttempts, "failed logins from one IP before each further attempt is delayed")
+	fs.DurationVar(&h.LoginThrottle.BaseDelay, "login-base-delay", h.LoginThrottle.BaseDelay, "first delay after the free attempts; it doubles with every further failure")
+	fs.DurationVar(&h.LoginThrottle.MaxDelay, "login-max-delay", h.LoginThrottle.MaxDelay, "longest delay between failed logins short of a lockout")
+	fs.IntVar(&h.LoginThrottle.LockoutThreshold, "login-lockout-threshold", h.LoginThrottle.LockoutThreshold, "failed logins before an account is temporarily locked")
+	fs.IntVar(&h.LoginThrottle.IPLockoutThreshold, "login-ip-lockout-threshold", h.LoginThrottle.IPLockoutThreshold, "failed logins before an IP is temporarily locked")
+	fs.DurationVar(&h.LoginThrottle.LockoutDuration, "login-lockout-duration", h.LoginThrottle.LockoutDuration, "how long a locked account stays locked")
+	fs.DurationVar(&h.LoginThrottle.ResetAfter, "login-reset-after", h.LoginThrottle.ResetAfter, "forget failed logins once none has failed for this long")
+	fs.DurationVar(&h.EmailVerificationTTL, "email-verification-ttl", h.EmailVerificationTTL, "how long an email verification link stays valid")
+	fs.DurationVar(&h.PasswordResetTTL, "password-reset-ttl", h.PasswordResetTTL, "how long a password reset link stays valid")
+
+	fs.StringVar(&h.TwoFactor.Issuer, "two-factor-issuer", h.TwoFactor.Issuer, "account label shown in authenticator apps")
+	fs.DurationVar(&h.TwoFactor.ChallengeTTL, "two-factor-challenge-ttl", h.TwoFactor.ChallengeTTL, "how long a user has to enter their code after the password step")
+	fs.IntVar(&h.TwoFactor.MaxChallengeAttempts, "two-factor-max-attempts", h.TwoFactor.MaxChallengeAttempts, "wrong codes accepted before a login must start over")
+	fs.DurationVar(&h.TwoFactor.FreshWindow, "two-factor-fresh-window", h.TwoFactor.FreshWindow, "how recently the second factor must have been entered for sensitive actions")
+	fs.IntVar(&h.TwoFactor.RecoveryCodes, "two-factor-recovery-codes", h.TwoFactor.RecoveryCodes, "how many one-time recovery codes are issued")
+
+	fs.IntVar(&h.APITokens.MaxPerUser, "api-token-max-per-user", h.APITokens.MaxPerUser, "most personal access tokens one account may hold")
+	fs.IntVar(&h.APITokens.MaxNameLength, "api-token-max-name-length", h.APITokens.MaxNameLength, "longest personal access token name, in characters")
+	fs.DurationVar(&h.APITokens.LastUsedInterval, "api-token-last-used-interval", h.APITokens.LastUsedInterval, "how often a token's last-used time is written back")
+
+	fs.IntVar(&h.Posts.MaxTitleLength, "post-max-title", h.Posts.MaxTitleLength, "longest post title, in characters")
+	fs.IntVar(&h.Posts.MaxContentLength, "post-max-content", h.Posts.MaxContentLength, "longest post body, in characters")
+	fs.DurationVar(&h.Posts.Cooldown, "post-cooldown", h.Posts.Cooldown, "least time a user must wait between two posts")
+
+	fs.StringVar(&c.Mail.OutboxDir, "outbox-dir", c.Mail.OutboxDir, "directory where outgoing emails are written as .eml files")
+	fs.StringVar(&c.Mail.From, "mail-from", c.Mail.From, "sender address of outgoing emails")
+
+	fs.Func("gender-options", "comma-separated gender choices offered at registration", func(value string) error {
+		var options []string
+		for _, option := range strings.Split(value, ",") {
+			if option = strings.TrimSpace(option); option != "" {
+				options = append(options, option)
+			}
+		}
+		if len(options) == 0 {
+			return errors.New("at least one option is required")
+		}
+		h.Demographics.GenderOptions = options
+		return nil
+	})
+	fs.BoolVar(&h.Demographics.AllowCustomGender, "allow-custom-gender", h.Demographics.AllowCustomGender, "let users describe their gender in their own words")
+	fs.IntVar(&h.Demographics.MaxCustomGender, "max-custom-gender", h.Demographics.MaxCustomGender, "longest self-described gender, in characters")
+	fs.BoolVar(&h.Demographics.RequireAge, "require-age", h.Demographics.RequireAge, "make age mandatory at registration")
+	fs.IntVar(&h.Demographics.MinAge, "min-age", h.Demographics.MinAge, "youngest age accepted")
+	fs.IntVar(&h.Demographics.MaxAge, "max-age", h.Demographics.MaxAge, "oldest age accepted")
+	fs.Func("registration-mode", "who may create accounts: open, invite (needs an invite code; the invite command mints the first) or closed", func(value string) error {
+		mode, err := handlers.ParseRegistrationMode(value)
+		h.Registration.Mode = mode
+		return err
+	})
+	fs.DurationVar(&h.Registration.DefaultInviteTTL, "invite-default-ttl", h.Registration.DefaultInviteTTL, "how long an invite stays valid when its creator does not say")
+	fs.DurationVar(&h.Registration.MaxInviteTTL, "invite-max-ttl", h.Registration.MaxInviteTTL, "longest lifetime an invite's creator may choose")
+	fs.IntVar(&h.Registration.MaxInviteUses, "invite-max-uses", h.Registration.MaxInviteUses, "most accounts one invite may create")
+
+	fs.Var(uintValue[uint32]{&c.Argon2.Memory}, "argon2-memory", "argon2id memory cost of password hashes, in KiB")
+	fs.Var(uintValue[uint32]{&c.Argon2.Iterations}, "argon2-iterations", "argon2id passes over memory when hashing passwords")
+	fs.Var(uintValue[uint8]{&c.Argon2.Parallelism}, "argon2-parallelism", "argon2id lanes used when hashing passwords")
+	fs.IntVar(&h.Passwords.MinLength, "password-min-length", h.Passwords.MinLength, "shortest password accepted for new passwords")
+	fs.IntVar(&h.Passwords.MaxLength, "password-max-length", h.Passwords.MaxLength, "longest password accepted, in bytes")
+	fs.IntVar(&h.Passwords.MinScore, "password-min-score", h.Passwords.MinScore, "lowest strength score (0-4) accepted for new passwords")
+	fs.IntVar(&h.Passwords.HashConcurrency, "password-hash-concurrency", h.Passwords.HashConcurrency, "most passwords hashed or checked at once, bounding argon2 memory use")
+	fs.StringVar(&c.PasswordBlocklist, "password-blocklist", c.PasswordBlocklist, "file of common or breached passwords to refuse; empty to disable")
+
+	fs.StringVar(&c.OIDC.Issuer, "oidc-issuer", c.OIDC.Issuer, "issuer URL of an OpenID Connect provider to offer as a sign-in option")
+	fs.StringVar(&c.OIDC.ClientID, "oidc-client-id", c.OIDC.ClientID, "client ID registered with the OpenID Connect provider")
+	fs.StringVar(&c.OIDC.ClientSecret, "oidc-client-secret", c.OIDC.ClientSecret, "client secret registered with the OpenID Connect provider")
+	fs.StringVar(&h.OIDCProviderName, "oidc-name", h.OIDCProviderName, "provider name shown on the sign-in button")
+	fs.DurationVar(&h.OIDCLoginTTL, "oidc-login-ttl", h.OIDCLoginTTL, "how long a user has to finish signing in at the provider")
+
+	fs.StringVar(&c.MakeAdmin, "make-admin", c.MakeAdmin, "promote the account with this nickname or email to admin at startup")
+}
+
+// uintValue is a flag for the fixed-size unsigned fields of the hashing parameters
+type uintValue[T uint8 | uint32] struct{ p *T }
+
+func (v uintValue[T]) String() string {
+	if v.p == nil {
+		return "0"
+	}
+	return strconv.FormatUint(uint64(*v.p), 10)
+}
+
+func (v uintValue[T]) Set(s string) error {
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return errors.New("not a whole number")
+	}
+	if limit := ^T(0); n > uint64(limit) {
+		return fmt.Errorf("must be at most %d", limit)
+	}
+	*v.p = T(n)
+	return nil
+}
+
+// readFile flattens a JSON config file into setting names and their values as
+// they would be written on the command line
+func readFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.UseNumber()
+	var root map[string]interface{}
+	if err := decoder.Decode(&root); err != nil {
+		return nil, fmt.Errorf("config file %s is not a JSON object: %w", path, err)
+	}
+
+	settings := make(map[string]string)
+	var flatten func(prefix string, object map[string]interface{}) error
+	flatten = func(prefix string, object map[string]interface{}) error {
+		for key, value := range object {
+			name := prefix + strings.ReplaceAll(strings.ToLower(key), "_", "-")
+			switch value := value.(type) {
+			case map[string]interface{}:
+				if err := flatten(name+"-", value); err != nil {
+					return err
+				}
+			case []interface{}:
+				items := make([]string, len(value))
+				for i, item := range value {
+					items[i] = fmt.Sprint(item)
+				}
+				settings[name] = strings.Join(items, ",")
+			case nil:
+				return fmt.Errorf("config file %s: %s: null is not a value", path, name)
+			default:
+				settings[name] = fmt.Sprint(value)
+			}
+		}
+		return nil
+	}
+	return settings, flatten("", root)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -2,231 +2,222 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-var DB *sql.DB // Exported DB variable
+// Config says where the database lives and how its connections are set up
+type Config struct {
+	// Path is the SQLite database file, created if missing. MemoryPath instead
+	// keeps a private database in memory for as long as the *sql.DB is open.
+	Path string
+	// ForeignKeys enforces REFERENCES clauses, including their ON DELETE CASCADE
+	ForeignKeys bool
+	// JournalMode is one of DELETE, TRUNCATE, PERSIST, MEMORY, WAL or OFF. WAL lets
+	// readers carry on while a write is in progress.
+	JournalMode string
+	// Synchronous is one of OFF, NORMAL, FULL or EXTRA
+	Synchronous string
+	// BusyTimeout is how long a connection waits for another one's write lock
+	// before failing with "database is locked"
+	BusyTimeout time.Duration
+
+	// MaxOpenConns caps the connections in the pool; MaxIdleConns of them are kept
+	// open between requests, for at most ConnMaxIdleTime (zero keeps them forever)
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxIdleTime time.Duration
+}
 
-// InitDB initializes the database and creates tables
-func InitDB() error {
-	var err error
-	DB, err = sql.Open("sqlite3", "./forum.db")
-	if err != nil {
-		return err
+// DefaultConfig returns the database settings used unless configured otherwise
+func DefaultConfig() Config {
+	return Config{
+		Path:            "./forum.db",
+		ForeignKeys:     true,
+		JournalMode:     "WAL",
+		Synchronous:     "NORMAL",
+		BusyTimeout:     5 * time.Second,
+		MaxOpenConns:    8,
+		MaxIdleConns:    8,
+		ConnMaxIdleTime: 10 * time.Minute,
+	}
+}
+
+// MemoryPath is the Path of an in-memory database. Such a database lives on a
+// single connection that is never closed while the pool is open, so Open ignores
+// MaxOpenConns, MaxIdleConns and ConnMaxIdleTime for it. It has no file to
+// journal to, so JournalMode must be MEMORY or OFF.
+const MemoryPath = ":memory:"
+
+// MemoryConfig returns settings for a throwaway in-memory database, as used in tests
+func MemoryConfig() Config {
+	cfg := DefaultConfig()
+	cfg.Path = MemoryPath
+	cfg.JournalMode = "MEMORY"
+	cfg.Synchronous = "OFF"
+	return cfg
+}
+
+// memoryDatabases names each in-memory database opened by this process
+var memoryDatabases atomic.Int64
+
+// JournalModes and SynchronousModes list the values SQLite accepts for those settings
+var (
+	JournalModes     = []string{"DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF"}
+	SynchronousModes = []string{"OFF", "NORMAL", "FULL", "EXTRA"}
+)
+
+// dsn asks the driver to apply the settings on every connection it opens, since
+// SQLite pragmas only last as long as the connection they ran on
+func (c Config) dsn() string {
+	path := c.Path
+	if c.Path == MemoryPath {
+		// A named shared-cache database, unlike a plain ":memory:" one, is the same
+		// database on every connection to it rather than a new empty one
+		path = fmt.Sprintf("file:forum-memory-%d?mode=memory&cache=shared&", memoryDatabases.Add(1))
+	} else {
+		path += "?"
 	}
 
-	err = createTables()
+	params := url.Values{}
+	params.Set("_foreign_keys", strconv.FormatBool(c.ForeignKeys))
+	params.Set("_journal_mode", c.JournalMode)
+	params.Set("_synchronous", c.Synchronous)
+	params.Set("_busy_timeout", strconv.FormatInt(c.BusyTimeout.Milliseconds(), 10))
+	// A deferred transaction that reads and then writes cannot wait for the write
+	// lock, so it fails at once instead of honouring the busy timeout
+	params.Set("_txlock", "immediate")
+	return path + params.Encode()
+}
+
+// Settings are the values SQLite reports for a connection
+type Settings struct {
+	ForeignKeys bool
+	JournalMode string
+	Synchronous string
+	BusyTimeout time.Duration
+}
+
+func (s Settings) String() string {
+	return fmt.Sprintf("foreign_keys=%t journal_mode=%s synchronous=%s busy_timeout=%s",
+		s.ForeignKeys, s.JournalMode, s.Synchronous, s.BusyTimeout)
+}
+
+// readSettings queries the settings of one pooled connection
+func readSettings(db *sql.DB) (Settings, error) {
+	var s Settings
+	var synchronous int
+	var busyTimeout int64
+	err := db.QueryRow(`
+        SELECT foreign_keys, journal_mode, synchronous, timeout
+        FROM pragma_foreign_keys, pragma_journal_mode, pragma_synchronous, pragma_busy_timeout
+    `).Scan(&s.ForeignKeys, &s.JournalMode, &synchronous, &busyTimeout)
 	if err != nil {
-		return err
+		return s, fmt.Errorf("reading connection settings: %w", err)
+	}
+	s.JournalMode = strings.ToUpper(s.JournalMode)
+	s.Synchronous = strconv.Itoa(synchronous)
+	if synchronous >= 0 && synchronous < len(SynchronousModes) {
+		s.Synchronous = SynchronousModes[synchronous]
 	}
+	s.BusyTimeout = time.Duration(busyTimeout) * time.Millisecond
+	return s, nil
+}
 
+// verify reports every setting SQLite did not apply as configured, which happens
+// for example with WAL on a filesystem without shared memory
+func (s Settings) verify(cfg Config) error {
+	var mismatches []string
+	if s.ForeignKeys != cfg.ForeignKeys {
+		mismatches = append(mismatches, fmt.Sprintf("foreign_keys is %t instead of %t", s.ForeignKeys, cfg.ForeignKeys))
+	}
+	if !strings.EqualFold(s.JournalMode, cfg.JournalMode) {
+		mismatches = append(mismatches, fmt.Sprintf("journal_mode is %s instead of %s", s.JournalMode, cfg.JournalMode))
+	}
+	if !strings.EqualFold(s.Synchronous, cfg.Synchronous) {
+		mismatches = append(mismatches, fmt.Sprintf("synchronous is %s instead of %s", s.Synchronous, cfg.Synchronous))
+	}
+	if s.BusyTimeout != cfg.BusyTimeout.Truncate(time.Millisecond) {
+		mismatches = append(mismatches, fmt.Sprintf("busy_timeout is %s instead of %s", s.BusyTimeout, cfg.BusyTimeout))
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("SQLite did not apply the configured settings: %s", strings.Join(mismatches, ", "))
+	}
 	return nil
 }
 
-func createTables() error {
-	// Users table
-	_, err := DB.Exec(`
-        CREATE TABLE IF NOT EXISTS users (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            nickname TEXT UNIQUE NOT NULL,
-            email TEXT UNIQUE NOT NULL,
-            password TEXT NOT NULL,
-            first_name TEXT NOT NULL,
-            last_name TEXT NOT NULL,
-            age INTEGER NOT NULL,
-            gender TEXT NOT NULL CHECK (gender IN ('Male', 'Female')),
-            session_token TEXT DEFAULT '',
-            created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-        )
-    `)
+// Open connects to the database without changing its schema, and checks that
+// its connections use the configured settings
+func Open(cfg Config) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", cfg.dsn())
 	if err != nil {
-		log.Printf("Error creating 'users' table: %v", err)
-		return err
-	} else {
-		log.Println("'users' table created or already exists")
-	}
-
-	// Rest of the tables remain the same
-	// Posts table
-	_, err = DB.Exec(`
-        CREATE TABLE IF NOT EXISTS posts (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            user_id INTEGER NOT NULL,
-            title TEXT NOT NULL,
-            content TEXT NOT NULL,
-            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-            FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
-        );
-    `)
-	if err != nil {
-		log.Printf("Error creating 'posts' table: %v", err)
-		return err
-	} else {
-		log.Println("'posts' table created or already exists")
+		return nil, err
 	}
+	if cfg.Path == MemoryPath {
+		// The database disappears with the last connection to it
+		cfg.MaxOpenConns, cfg.MaxIdleConns, cfg.ConnMaxIdleTime = 1, 1, 0
+	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
 
-	// Categories table
-	_, err = DB.Exec(`
-        CREATE TABLE IF NOT EXISTS categories (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            name TEXT UNIQUE NOT NULL
-        );
-    `)
-	if err != nil {
-		log.Printf("Error creating 'categories' table: %v", err)
-		return err
-	} else {
-		log.Println("'categories' table created or already exists")
-	}
-
-	// Post categories relationship table
-	_, err = DB.Exec(`
-        CREATE TABLE IF NOT EXISTS post_categories (
-            post_id INTEGER NOT NULL,
-            category_id INTEGER NOT NULL,
-            PRIMARY KEY (post_id, category_id),
-            FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE,
-            FOREIGN KEY (category_id) REFERENCES categories (id) ON DELETE CASCADE
-        );
-    `)
-	if err != nil {
-		log.Printf("Error creating 'post_categories' table: %v", err)
-		return err
-	} else {
-		log.Println("'post_categories' table created or already exists")
-	}
-
-	// Comments table
-	_, err = DB.Exec(`
-        CREATE TABLE IF NOT EXISTS comments (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            post_id INTEGER NOT NULL,
-            user_id INTEGER NOT NULL,
-            content TEXT NOT NULL,
-            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-            FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE,
-            FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
-        );
-    `)
-	if err != nil {
-		log.Printf("Error creating 'comments' table: %v", err)
-		return err
-	} else {
-		log.Println("'comments' table created or already exists")
-	}
-
-	// post_likes table
-	_, err = DB.Exec(`
-        CREATE TABLE IF NOT EXISTS post_likes (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            user_id INTEGER NOT NULL,
-            post_id INTEGER NOT NULL,
-            is_like BOOLEAN NOT NULL,
-            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-            FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
-            FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE,
-            UNIQUE (user_id, post_id)
-        );
-    `)
-	if err != nil {
-		log.Printf("Error creating 'post_likes' table: %v", err)
-		return err
-	} else {
-		log.Println("'post_likes' table created or already exists")
-	}
-
-	// comment_likes table
-	_, err = DB.Exec(`
-        CREATE TABLE IF NOT EXISTS comment_likes (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            user_id INTEGER NOT NULL,
-            comment_id INTEGER NOT NULL,
-            is_like BOOLEAN NOT NULL,
-            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-            UNIQUE (comment_id, user_id),
-            FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
-            FOREIGN KEY (comment_id) REFERENCES comments (id) ON DELETE CASCADE
-        );
-    `)
-	if err != nil {
-		log.Printf("Error creating 'comment_likes' table: %v", err)
-		return err
-	} else {
-		log.Println("'comment_likes' table created or already exists")
-	}
-
-	// Insert default categories
-	_, err = DB.Exec(`
-        INSERT OR IGNORE INTO categories (name) VALUES
-        ('Technology'),
-        ('Lifestyle'),
-        ('Travel'),
-        ('Food'),
-        ('Sport'),
-        ('Other')
-    `)
-	if err != nil {
-		log.Printf("Error inserting default categories: %v", err)
-		return err
-	} else {
-		log.Println("Default categories inserted or already exist")
-	}
-
-	// table using for the chat between users
-	_, err = DB.Exec(`
-    	CREATE TABLE IF NOT EXISTS chats (
-    	    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    	    sender_id INTEGER NOT NULL,
-    	    receiver_id INTEGER NOT NULL,
-    	    message TEXT NOT NULL,
-    	    sent_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			meta_data TEXT DEFAULT NULL,
-    	    FOREIGN KEY (sender_id) REFERENCES users (id) ON DELETE CASCADE,
-    	    FOREIGN KEY (receiver_id) REFERENCES users (id) ON DELETE CASCADE
-    	);
-	`)
+	settings, err := readSettings(db)
+	if err == nil {
+		err = settings.verify(cfg)
+	}
 	if err != nil {
-		log.Printf("Error creating 'chats' table: %v", err)
-		return err
-	} else {
-		log.Println("'chats' table created or already exists")
+		db.Close()
+		return nil, fmt.Errorf("opening %s: %w", cfg.Path, err)
 	}
 
-	_, err = DB.Exec(`
-    	CREATE TABLE IF NOT EXISTS user_status (
-			user_id INTEGER PRIMARY KEY,
-			is_online BOOLEAN NOT NULL DEFAULT FALSE,
-			last_seen DATETIME,
-			FOREIGN KEY (user_id) REFERENCES users(id)
-		);
-	`)
+	log.Printf("Database %s: %s max_open_conns=%d max_idle_conns=%d", cfg.Path, settings, cfg.MaxOpenConns, cfg.MaxIdleConns)
+	return db, nil
+}
+
+// InitDB opens the database and migrates its schema to the latest version
+func InitDB(cfg Config) (*sql.DB, error) {
+	db, err := Open(cfg)
 	if err != nil {
-		log.Printf("Error creating 'user_status' table: %v", err)
-		return err
-	} else {
-		log.Println("'user_status' table created or already exists")
+		return nil, err
 	}
 
-	_, err = DB.Exec(`
-    	CREATE TABLE IF NOT EXISTS notifications (
-    		id INTEGER PRIMARY KEY AUTOINCREMENT,
-    		user_id INTEGER NOT NULL,          -- Who receives the notification
-    		sender_id INTEGER NOT NULL,        -- Who triggered it
-    		is_read BOOLEAN DEFAULT FALSE,     -- Simple read/unread status
-    		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-
-    		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
-    		FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE
-		);
-	`)
+	latest, err := LatestVersion()
+	if err == nil {
+		err = MigrateTo(db, latest)
+	}
+	if err == nil && cfg.ForeignKeys {
+		err = warnForeignKeyViolations(db)
+	}
 	if err != nil {
-		log.Printf("Error creating 'user_status' table: %v", err)
-		return err
-	} else {
-		log.Println("'user_status' table created or already exists")
+		db.Close()
+		return nil, err
 	}
+	return db, nil
+}
 
-	return nil
+// warnForeignKeyViolations logs rows left pointing at missing rows while foreign
+// keys were not enforced. They are kept, but enforcement now applies to them.
+func warnForeignKeyViolations(db *sql.DB) error {
+	rows, err := db.Query(`SELECT "table", parent, COUNT(*) FROM pragma_foreign_key_check GROUP BY "table", parent`)
+	if err != nil {
+		return fmt.Errorf("checking foreign keys: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var table, parent string
+		var count int
+		if err := rows.Scan(&table, &parent, &count); err != nil {
+			return err
+		}
+		log.Printf("Warning: %d rows of '%s' reference missing rows of '%s'", count, table, parent)
+	}
+	return rows.Err()
 }
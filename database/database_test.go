@@ -0,0 +1,109 @@
+package database
+
+import (
+	"database/sql"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func TestMemoryDatabaseKeepsItsSchema(t *testing.T) {
+	db, err := InitDB(MemoryConfig())
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	defer db.Close()
+
+	// Every query must see the migrated schema, not a fresh empty database
+	for i := 0; i < 3; i++ {
+		if exists, err := tableExists(db, "users"); err != nil || !exists {
+			t.Fatalf("query %d: users table exists = %t, %v", i, exists, err)
+		}
+	}
+}
+
+func TestMemoryDatabaseNeedsMemoryJournal(t *testing.T) {
+	cfg := MemoryConfig()
+	cfg.JournalMode = "WAL"
+	if db, err := Open(cfg); err == nil {
+		db.Close()
+		t.Fatal("opened an in-memory database in WAL mode")
+	}
+}
+
+func TestMigrateToRefusesIrreversibleMigrations(t *testing.T) {
+	db, err := InitDB(MemoryConfig())
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	defer db.Close()
+
+	latest, err := LatestVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = MigrateTo(db, 2)
+	if err == nil || !strings.Contains(err.Error(), "cannot be reverted") {
+		t.Fatalf("MigrateTo(2) = %v, want a refusal to revert 0003", err)
+	}
+
+	// Nothing was reverted before the refusal
+	statuses, err := Status(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			t.Errorf("migration %s was reverted", status.Migration)
+		}
+	}
+	if err := MigrateTo(db, latest); err != nil {
+		t.Errorf("MigrateTo(latest) after the refusal: %v", err)
+	}
+}
+
+func TestInvitesOutliveTheirCreator(t *testing.T) {
+	db, err := InitDB(MemoryConfig())
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	defer db.Close()
+
+	for _, statement := range []string{
+		"INSERT INTO users (id, nickname, email, password, first_name, last_name, gender) VALUES (1, 'alice', 'alice@example.com', 'x', 'Alice', 'A', 'Female'), (2, 'bob', 'bob@example.com', 'x', 'Bob', 'B', 'Male')",
+		"INSERT INTO invites (id, code_hash, created_by, max_uses, uses, expires_at) VALUES (1, 'code', 1, 1, 1, '2100-01-01')",
+		"INSERT INTO invite_uses (invite_id, user_id) VALUES (1, 2)",
+		"DELETE FROM users WHERE id = 1",
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+
+	var createdBy sql.NullInt64
+	if err := db.QueryRow("SELECT created_by FROM invites WHERE id = 1").Scan(&createdBy); err != nil {
+		t.Fatalf("invite deleted with its creator: %v", err)
+	}
+	if createdBy.Valid {
+		t.Errorf("created_by = %d, want NULL once the creator is deleted", createdBy.Int64)
+	}
+	var uses int
+	if err := db.QueryRow("SELECT COUNT(*) FROM invite_uses WHERE invite_id = 1 AND user_id = 2").Scan(&uses); err != nil || uses != 1 {
+		t.Errorf("bob's invite use: count %d, %v; want it kept", uses, err)
+	}
+
+	// Going back to cascading deletes keeps the invite, which has no creator left to follow
+	if err := Rollback(db, 1); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM invite_uses").Scan(&uses); err != nil || uses != 1 {
+		t.Errorf("invite uses after rollback: count %d, %v; want 1", uses, err)
+	}
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+)
+
+// adoptLegacySchema prepares a database created before versioned migrations, when
+// tables were created at startup and columns added as features arrived. It adds
+// whatever columns the database is missing so that migration 1, which only creates
+// absent tables, leaves it at the baseline schema. Databases that already track
+// migrations, and empty ones, are left alone.
+func adoptLegacySchema(db *sql.DB) error {
+	tracked, err := tableExists(db, "schema_migrations")
+	if err != nil || tracked {
+		return err
+	}
+	if exists, err := tableExists(db, "users"); err != nil || !exists {
+		return err
+	}
+	log.Println("Upgrading a database created before versioned migrations")
+
+	columns := []struct{ table, column, definition string }{
+		// Accounts created before email verification existed are treated as verified
+		{"users", "email_verified", "BOOLEAN NOT NULL DEFAULT TRUE"},
+		{"users", "totp_secret", "TEXT NOT NULL DEFAULT ''"},
+		{"users", "totp_enabled", "BOOLEAN NOT NULL DEFAULT FALSE"},
+		{"users", "totp_last_step", "INTEGER NOT NULL DEFAULT 0"},
+		{"users", "role", "TEXT NOT NULL DEFAULT 'member'"},
+		{"sessions", "last_seen_at", "DATETIME"},
+		{"sessions", "csrf_token", "TEXT DEFAULT ''"},
+		{"sessions", "mfa_verified_at", "DATETIME"},
+	}
+	for _, c := range columns {
+		if err := addColumnIfMissing(db, c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+	if err := rebuildLegacyUsersTable(db); err != nil {
+		return err
+	}
+	return unescapeLegacyText(db)
+}
+
+// addColumnIfMissing adds a column to a table created by an older version of the
+// schema. Missing tables are skipped; the baseline migration creates them whole.
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	found := false
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+		found = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if !found {
+		return nil
+	}
+
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	if err != nil {
+		log.Printf("Error adding column '%s' to '%s': %v", column, table, err)
+		return err
+	}
+	log.Printf("Column '%s' added to '%s'", column, table)
+	return nil
+}
@@ -0,0 +1,180 @@
+package database
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openLegacyFixture writes testdata/legacy.sql to a database file and returns the
+// settings InitDB would open it with
+func openLegacyFixture(t *testing.T) Config {
+	t.Helper()
+	script, err := os.ReadFile("testdata/legacy.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.Path = filepath.Join(t.TempDir(), "forum.db")
+	// The fixture holds the welcome chats the old schema let through
+	loader := cfg
+	loader.ForeignKeys = false
+	db, err := Open(loader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(string(script)); err != nil {
+		t.Fatalf("loading the legacy fixture: %v", err)
+	}
+	return cfg
+}
+
+// queryString returns the single text value the query selects
+func queryString(t *testing.T, db *sql.DB, query string, args ...interface{}) string {
+	t.Helper()
+	var value sql.NullString
+	if err := db.QueryRow(query, args...).Scan(&value); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return value.String
+}
+
+func TestLegacyDatabaseIsMigrated(t *testing.T) {
+	db, err := InitDB(openLegacyFixture(t))
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	defer db.Close()
+
+	statuses, err := Status(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			t.Errorf("migration %s is still pending", status.Migration)
+		}
+	}
+
+	// Escaped text is restored to what was typed, everywhere it was stored
+	for _, tc := range []struct{ query, want string }{
+		{"SELECT nickname FROM users WHERE id = 2", "b<o>b"},
+		{"SELECT first_name FROM users WHERE id = 1", "Zo'e"},
+		{"SELECT last_name FROM users WHERE id = 1", "O'Brien"},
+		{"SELECT last_name FROM users WHERE id = 2", "Smith & Sons"},
+		{"SELECT title FROM posts WHERE id = 1", "Fish & Chips"},
+		{"SELECT content FROM posts WHERE id = 1", `1 < 2 && "quoted"`},
+		{"SELECT content FROM posts WHERE id = 2", "No entities here"},
+		{"SELECT content FROM comments WHERE id = 1", "Tom & Jerry <3"},
+		{"SELECT meta_data FROM chats WHERE receiver_id = 2", `{"status":"sent"}`},
+	} {
+		if got := queryString(t, db, tc.query); got != tc.want {
+			t.Errorf("%s = %q, want %q", tc.query, got, tc.want)
+		}
+	}
+
+	// The columns added since are filled in for existing accounts
+	for _, tc := range []struct{ query, want string }{
+		{"SELECT email FROM users WHERE id = 1", "alice@example.com"},
+		{"SELECT email_verified FROM users WHERE id = 1", "true"},
+		{"SELECT role FROM users WHERE id = 2", "member"},
+		{"SELECT totp_enabled FROM users WHERE id = 1", "false"},
+		{"SELECT age FROM users WHERE id = 2", "41"},
+		{"SELECT gender FROM users WHERE id = 1", "Female"},
+	} {
+		if got := queryString(t, db, tc.query); got != tc.want {
+			t.Errorf("%s = %q, want %q", tc.query, got, tc.want)
+		}
+	}
+
+	// The rebuilt users table takes any gender and no age, and dropped the session token
+	schema := queryString(t, db, "SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'users'")
+	if strings.Contains(schema, "CHECK") || strings.Contains(schema, "session_token") {
+		t.Errorf("users table keeps the legacy schema: %s", schema)
+	}
+	_, err = db.Exec("INSERT INTO users (nickname, email, password, first_name, last_name, gender) VALUES ('carol', 'carol@example.com', 'x', 'Carol', 'King', 'Non-binary')")
+	if err != nil {
+		t.Errorf("adding a user without an age and with a configured gender: %v", err)
+	}
+
+	// Rows that belonged to the old users table still point at the same accounts
+	for _, tc := range []struct {
+		query string
+		want  int
+	}{
+		{"SELECT COUNT(*) FROM chats", 1},
+		{"SELECT COUNT(*) FROM posts", 2},
+		{"SELECT COUNT(*) FROM post_categories", 2},
+		{"SELECT COUNT(*) FROM post_likes WHERE user_id = 2 AND post_id = 1", 1},
+		{"SELECT COUNT(*) FROM comment_likes WHERE user_id = 1 AND comment_id = 1", 1},
+		{"SELECT COUNT(*) FROM notifications WHERE user_id = 2 AND sender_id = 1", 1},
+		{"SELECT COUNT(*) FROM categories", 6},
+		{"SELECT COUNT(*) FROM pragma_foreign_key_check", 0},
+	} {
+		var got int
+		if err := db.QueryRow(tc.query).Scan(&got); err != nil {
+			t.Fatalf("%s: %v", tc.query, err)
+		}
+		if got != tc.want {
+			t.Errorf("%s = %d, want %d", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestLegacyTextIsUnescapedOnce(t *testing.T) {
+	cfg := openLegacyFixture(t)
+	db, err := InitDB(cfg)
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+
+	// Text typed after the upgrade is stored raw, entities included
+	if _, err := db.Exec("UPDATE posts SET content = ? WHERE id = 2", "Write &amp; for an ampersand"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = InitDB(cfg)
+	if err != nil {
+		t.Fatalf("InitDB on the upgraded database: %v", err)
+	}
+	defer db.Close()
+	if got := queryString(t, db, "SELECT content FROM posts WHERE id = 2"); got != "Write &amp; for an ampersand" {
+		t.Errorf("content after restarting = %q, want it left alone", got)
+	}
+	if got := queryString(t, db, "SELECT COUNT(*) FROM data_migrations WHERE name = 'unescape_legacy_text'"); got != "1" {
+		t.Errorf("unescape_legacy_text recorded %s times, want once", got)
+	}
+}
+
+func TestAddColumnIfMissing(t *testing.T) {
+	db, err := Open(MemoryConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE sessions (token TEXT PRIMARY KEY, user_id INTEGER NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Adding twice is a no-op, and a missing table is left to the baseline migration
+	for i := 0; i < 2; i++ {
+		if err := addColumnIfMissing(db, "sessions", "csrf_token", "TEXT DEFAULT ''"); err != nil {
+			t.Fatalf("attempt %d: %v", i+1, err)
+		}
+	}
+	if err := addColumnIfMissing(db, "api_tokens", "name", "TEXT"); err != nil {
+		t.Fatalf("missing table: %v", err)
+	}
+
+	if got := queryString(t, db, "SELECT COUNT(*) FROM pragma_table_info('sessions') WHERE name = 'csrf_token'"); got != "1" {
+		t.Errorf("sessions has %s csrf_token columns, want 1", got)
+	}
+	if exists, err := tableExists(db, "api_tokens"); err != nil || exists {
+		t.Errorf("api_tokens exists = %t, %v; want it left for the baseline migration", exists, err)
+	}
+}
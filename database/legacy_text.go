@@ -0,0 +1,99 @@
+package database
+
+import (
+	"database/sql"
+	"html"
+	"log"
+)
+
+// legacyTextColumns lists the columns that older versions filled with
+// HTML-escaped user input instead of the raw text
+var legacyTextColumns = map[string][]string{
+	"users":    {"nickname", "email", "first_name", "last_name", "gender"},
+	"posts":    {"title", "content"},
+	"comments": {"content"},
+	"chats":    {"meta_data"},
+}
+
+// unescapeLegacyText converts escaped rows back to raw text, once per database
+func unescapeLegacyText(db *sql.DB) error {
+	_, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS data_migrations (
+            name TEXT PRIMARY KEY,
+            applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        );
+    `)
+	if err != nil {
+		log.Printf("Error creating 'data_migrations' table: %v", err)
+		return err
+	}
+
+	const name = "unescape_legacy_text"
+	var applied string
+	err = db.QueryRow("SELECT name FROM data_migrations WHERE name = ?", name).Scan(&applied)
+	if err == nil {
+		return nil
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for table, columns := range legacyTextColumns {
+		for _, column := range columns {
+			updated, err := unescapeColumn(tx, table, column)
+			if err != nil {
+				log.Printf("Error unescaping '%s.%s': %v", table, column, err)
+				return err
+			}
+			if updated > 0 {
+				log.Printf("Unescaped %d rows of '%s.%s'", updated, table, column)
+			}
+		}
+	}
+
+	if _, err := tx.Exec("INSERT INTO data_migrations (name) VALUES (?)", name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// unescapeColumn rewrites every value of the column that contains an HTML entity
+func unescapeColumn(tx *sql.Tx, table, column string) (int, error) {
+	rows, err := tx.Query("SELECT rowid, " + column + " FROM " + table + " WHERE " + column + " LIKE '%&%;%'")
+	if err != nil {
+		return 0, err
+	}
+
+	values := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var value string
+		if err := rows.Scan(&id, &value); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		values[id] = value
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for id, value := range values {
+		raw := html.UnescapeString(value)
+		if raw == value {
+			continue
+		}
+		if _, err := tx.Exec("UPDATE "+table+" SET "+column+" = ? WHERE rowid = ?", raw, id); err != nil {
+			return 0, err
+		}
+		updated++
+	}
+	return updated, nil
+}
@@ -0,0 +1,323 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFiles holds the schema history as pairs of files named
+// <version>_<name>.up.sql and <version>_<name>.down.sql. A down file that
+// starts with the line "-- irreversible" marks a migration that cannot be
+// reverted; the rest of the file explains why.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+const irreversibleMarker = "-- irreversible"
+
+// Migration is one numbered schema change and the SQL that applies and reverts it
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+	// Irreversible migrations destroy data their Down cannot bring back, so
+	// MigrateTo refuses to revert them
+	Irreversible bool
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// MigrationStatus pairs a migration with the time it was applied, nil while pending.
+// Known is false for versions recorded in the database but missing from this build.
+type MigrationStatus struct {
+	Migration
+	Known     bool
+	AppliedAt *time.Time
+}
+
+// loadMigrations reads the embedded migrations, ordered by version
+func loadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration file %s is not named <version>_<name>.up.sql or <version>_<name>.down.sql", entry.Name())
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("migration file %s needs a version of 1 or more", entry.Name())
+		}
+		content, err := migrationFiles.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migrations %s and %04d_%s share a version", m, version, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+			m.Irreversible = strings.HasPrefix(m.Down, irreversibleMarker+"\n")
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %s needs both an up and a down file", m)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// LatestVersion is the version the embedded migrations bring the schema to
+func LatestVersion() (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil || len(migrations) == 0 {
+		return 0, err
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+func tableExists(db *sql.DB, name string) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&count)
+	return count > 0, err
+}
+
+// appliedMigrations returns the name and time of every version recorded in schema_migrations
+func appliedMigrations(db *sql.DB) (map[int]MigrationStatus, error) {
+	applied := make(map[int]MigrationStatus)
+	if exists, err := tableExists(db, "schema_migrations"); err != nil || !exists {
+		return applied, err
+	}
+
+	rows, err := db.Query("SELECT version, name, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var status MigrationStatus
+		var appliedAt time.Time
+		if err := rows.Scan(&status.Version, &status.Name, &appliedAt); err != nil {
+			return nil, err
+		}
+		status.AppliedAt = &appliedAt
+		applied[status.Version] = status
+	}
+	return applied, rows.Err()
+}
+
+// Status lists the embedded migrations and any unknown versions the database
+// records, ordered by version
+func Status(db *sql.DB) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Migration: m, Known: true}
+		if record, ok := applied[m.Version]; ok {
+			status.AppliedAt = record.AppliedAt
+			delete(applied, m.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, record := range applied {
+		statuses = append(statuses, record)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// MigrateTo applies pending migrations up to and including target and reverts
+// applied ones above it, newest first. Each migration runs in its own transaction
+// together with its schema_migrations record, so a failure leaves the schema at
+// the last version that succeeded. A target of 0 reverts everything.
+func MigrateTo(db *sql.DB, target int) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	if target < 0 || (target > 0 && !containsVersion(migrations, target)) {
+		return fmt.Errorf("there is no migration with version %d", target)
+	}
+
+	if err := adoptLegacySchema(db); err != nil {
+		return fmt.Errorf("upgrading database from before versioned migrations: %w", err)
+	}
+	_, err = db.Exec(`
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version INTEGER PRIMARY KEY,
+            name TEXT NOT NULL,
+            applied_at DATETIME NOT NULL
+        );
+    `)
+	if err != nil {
+		return err
+	}
+
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+	for version, record := range applied {
+		if !containsVersion(migrations, version) {
+			return fmt.Errorf("database has migration %s applied, which this build does not include; run a newer build or roll it back with one", record.Migration)
+		}
+	}
+	for _, m := range migrations {
+		if _, done := applied[m.Version]; m.Version > target && done && m.Irreversible {
+			return fmt.Errorf("migration %s cannot be reverted, so the schema cannot go below version %d", m, m.Version)
+		}
+	}
+
+	// Rebuilding a table means dropping one that others reference, which SQLite
+	// only allows with foreign keys off; the pragma is per connection and has no
+	// effect inside a transaction, so every migration runs on this one connection
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var foreignKeys bool
+	if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeys); err != nil {
+		return err
+	}
+	if foreignKeys {
+		if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+	}
+
+	for _, m := range migrations {
+		if _, done := applied[m.Version]; m.Version <= target && !done {
+			if err := runMigration(ctx, conn, m, true); err != nil {
+				return err
+			}
+		}
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if _, done := applied[m.Version]; m.Version > target && done {
+			if err := runMigration(ctx, conn, m, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Rollback reverts the given number of most recently applied migrations
+func Rollback(db *sql.DB, steps int) error {
+	if steps < 1 {
+		return fmt.Errorf("rollback needs at least one step, got %d", steps)
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	versions := make([]int, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+	if steps > len(versions) {
+		return fmt.Errorf("cannot roll back %d migrations, only %d are applied", steps, len(versions))
+	}
+
+	target := 0
+	if steps < len(versions) {
+		target = versions[steps]
+	}
+	return MigrateTo(db, target)
+}
+
+func containsVersion(migrations []Migration, version int) bool {
+	for _, m := range migrations {
+		if m.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// runMigration applies or reverts one migration and records the outcome in the same transaction
+func runMigration(ctx context.Context, conn *sql.Conn, m Migration, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	script, verb := m.Down, "reverting"
+	if up {
+		script, verb = m.Up, "applying"
+		if check, ok := migrationChecks[m.Version]; ok {
+			if err := check(ctx, tx); err != nil {
+				return fmt.Errorf("%s migration %s: %w", verb, m, err)
+			}
+		}
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("%s migration %s: %w", verb, m, err)
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", m.Version, m.Name, time.Now().UTC())
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", m.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("recording migration %s: %w", m, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s migration %s: %w", verb, m, err)
+	}
+
+	if up {
+		log.Printf("Applied migration %s", m)
+	} else {
+		log.Printf("Reverted migration %s", m)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// migrationChecks look at the data before the migration of the same version is
+// applied, so that rows it would fail on are reported in terms an operator can
+// act on instead of as a bare constraint error
+var migrationChecks = map[int]func(ctx context.Context, tx *sql.Tx) error{
+	5: checkEmailsDifferBeyondCase,
+}
+
+// checkEmailsDifferBeyondCase refuses to lowercase emails while two accounts would
+// end up with the same one, naming the accounts so all but one can be changed
+func checkEmailsDifferBeyondCase(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `
+        SELECT lower(trim(email)), group_concat(nickname, ', ')
+        FROM (SELECT email, nickname FROM users ORDER BY id)
+        GROUP BY lower(trim(email))
+        HAVING COUNT(*) > 1
+        ORDER BY 1
+    `)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var duplicates []string
+	for rows.Next() {
+		var email, nicknames string
+		if err := rows.Scan(&email, &nicknames); err != nil {
+			return err
+		}
+		duplicates = append(duplicates, fmt.Sprintf("%s is used by %s", email, nicknames))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(duplicates) > 0 {
+		return fmt.Errorf("emails must be unique regardless of case, but %s; change the email of all but one of these accounts and migrate again", strings.Join(duplicates, "; "))
+	}
+	return nil
+}
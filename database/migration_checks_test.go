@@ -0,0 +1,56 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEmailsDifferingOnlyByCaseAreReported(t *testing.T) {
+	cfg := openLegacyFixture(t)
+	db, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`INSERT INTO users (nickname, email, password, first_name, last_name, age, gender)
+        VALUES ('alice2', ' ALICE@example.com', 'x', 'Alice', 'Again', 25, 'Female')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	latest, err := LatestVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = MigrateTo(db, latest)
+	if err == nil {
+		t.Fatal("migrated with two accounts sharing an email")
+	}
+	for _, want := range []string{"0005_lowercase_emails", "alice@example.com is used by alice, alice2"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+
+	// The migrations before it stay applied and the emails are untouched
+	statuses, err := Status(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if applied := status.AppliedAt != nil; applied != (status.Version < 5) {
+			t.Errorf("migration %s applied = %t", status.Migration, applied)
+		}
+	}
+	if got := queryString(t, db, "SELECT email FROM users WHERE nickname = 'alice2'"); got != " ALICE@example.com" {
+		t.Errorf("alice2's email = %q, want it as stored", got)
+	}
+
+	// Once one of them is changed the upgrade finishes
+	if _, err := db.Exec("UPDATE users SET email = 'alice2@example.com' WHERE nickname = 'alice2'"); err != nil {
+		t.Fatal(err)
+	}
+	if err := MigrateTo(db, latest); err != nil {
+		t.Fatalf("MigrateTo after resolving the duplicate: %v", err)
+	}
+}
@@ -0,0 +1,21 @@
+-- Drops every table of the baseline schema, dependents first
+DROP TABLE IF EXISTS notifications;
+DROP TABLE IF EXISTS user_status;
+DROP TABLE IF EXISTS chats;
+DROP TABLE IF EXISTS comment_likes;
+DROP TABLE IF EXISTS post_likes;
+DROP TABLE IF EXISTS comments;
+DROP TABLE IF EXISTS post_categories;
+DROP TABLE IF EXISTS categories;
+DROP TABLE IF EXISTS posts;
+DROP TABLE IF EXISTS password_resets;
+DROP TABLE IF EXISTS login_attempts;
+DROP TABLE IF EXISTS invite_uses;
+DROP TABLE IF EXISTS invites;
+DROP TABLE IF EXISTS api_tokens;
+DROP TABLE IF EXISTS oidc_logins;
+DROP TABLE IF EXISTS user_identities;
+DROP TABLE IF EXISTS mfa_challenges;
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,213 @@
+-- Baseline schema: every table the forum had when versioned migrations were introduced.
+-- Tables are created only if missing so that databases from before that point can adopt it.
+
+-- Allowed genders are configurable, so they are checked by the application rather than by the schema
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	nickname TEXT UNIQUE NOT NULL,
+	email TEXT UNIQUE NOT NULL,
+	password TEXT NOT NULL,
+	first_name TEXT NOT NULL,
+	last_name TEXT NOT NULL,
+	age INTEGER,
+	gender TEXT NOT NULL,
+	email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+	totp_secret TEXT NOT NULL DEFAULT '',
+	totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+	totp_last_step INTEGER NOT NULL DEFAULT 0,
+	role TEXT NOT NULL DEFAULT 'member',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- One row per logged-in device
+CREATE TABLE IF NOT EXISTS sessions (
+	token TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	expires_at DATETIME NOT NULL,
+	last_seen_at DATETIME,
+	user_agent TEXT DEFAULT '',
+	ip_address TEXT DEFAULT '',
+	csrf_token TEXT DEFAULT '',
+	mfa_verified_at DATETIME,
+	FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- One-time recovery codes for two-factor authentication, stored hashed
+CREATE TABLE IF NOT EXISTS recovery_codes (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	code_hash TEXT NOT NULL,
+	used_at DATETIME,
+	FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- Logins that passed the password check and wait for the second factor
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+	token_hash TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	expires_at DATETIME NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- Accounts at external OpenID providers linked to local users
+CREATE TABLE IF NOT EXISTS user_identities (
+	issuer TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (issuer, subject),
+	FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- Provider logins in progress, keyed by the hashed state parameter
+CREATE TABLE IF NOT EXISTS oidc_logins (
+	state_hash TEXT PRIMARY KEY,
+	nonce TEXT NOT NULL,
+	code_verifier TEXT NOT NULL,
+	expires_at DATETIME NOT NULL
+);
+
+-- Personal access tokens for scripts and bots, stored hashed
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	scopes TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	last_used_at DATETIME,
+	FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- Invite codes for invite-only registration, stored hashed
+CREATE TABLE IF NOT EXISTS invites (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	code_hash TEXT NOT NULL UNIQUE,
+	created_by INTEGER NOT NULL,
+	max_uses INTEGER NOT NULL,
+	uses INTEGER NOT NULL DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	expires_at DATETIME NOT NULL,
+	revoked_at DATETIME,
+	FOREIGN KEY (created_by) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- Who joined with which invite, and so who invited whom
+CREATE TABLE IF NOT EXISTS invite_uses (
+	invite_id INTEGER NOT NULL,
+	user_id INTEGER PRIMARY KEY,
+	used_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (invite_id) REFERENCES invites (id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- Failed login attempts, keyed by identifier or client IP
+CREATE TABLE IF NOT EXISTS login_attempts (
+	key TEXT PRIMARY KEY,
+	failures INTEGER NOT NULL DEFAULT 0,
+	last_failure_at DATETIME,
+	locked_until DATETIME
+);
+
+-- Password reset tokens, stored hashed
+CREATE TABLE IF NOT EXISTS password_resets (
+	token_hash TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	expires_at DATETIME NOT NULL,
+	used_at DATETIME,
+	FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS posts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	title TEXT NOT NULL,
+	content TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS categories (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS post_categories (
+	post_id INTEGER NOT NULL,
+	category_id INTEGER NOT NULL,
+	PRIMARY KEY (post_id, category_id),
+	FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE,
+	FOREIGN KEY (category_id) REFERENCES categories (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS comments (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	post_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	content TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS post_likes (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	post_id INTEGER NOT NULL,
+	is_like BOOLEAN NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+	FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE,
+	UNIQUE (user_id, post_id)
+);
+
+CREATE TABLE IF NOT EXISTS comment_likes (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	comment_id INTEGER NOT NULL,
+	is_like BOOLEAN NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (comment_id, user_id),
+	FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+	FOREIGN KEY (comment_id) REFERENCES comments (id) ON DELETE CASCADE
+);
+
+INSERT OR IGNORE INTO categories (name) VALUES
+	('Technology'),
+	('Lifestyle'),
+	('Travel'),
+	('Food'),
+	('Sport'),
+	('Other');
+
+-- Private messages between users
+CREATE TABLE IF NOT EXISTS chats (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	sender_id INTEGER NOT NULL,
+	receiver_id INTEGER NOT NULL,
+	message TEXT NOT NULL,
+	sent_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	meta_data TEXT DEFAULT NULL,
+	FOREIGN KEY (sender_id) REFERENCES users (id) ON DELETE CASCADE,
+	FOREIGN KEY (receiver_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS user_status (
+	user_id INTEGER PRIMARY KEY,
+	is_online BOOLEAN NOT NULL DEFAULT FALSE,
+	last_seen DATETIME,
+	FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
+CREATE TABLE IF NOT EXISTS notifications (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,   -- Who receives the notification
+	sender_id INTEGER NOT NULL, -- Who triggered it
+	is_read BOOLEAN DEFAULT FALSE,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+	FOREIGN KEY (sender_id) REFERENCES users (id) ON DELETE CASCADE
+);
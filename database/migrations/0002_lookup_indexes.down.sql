@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_notifications_user_id;
+DROP INDEX IF EXISTS idx_chats_conversation;
+DROP INDEX IF EXISTS idx_post_likes_post_id;
+DROP INDEX IF EXISTS idx_comments_post_id;
+DROP INDEX IF EXISTS idx_posts_user_id;
+DROP INDEX IF EXISTS idx_sessions_user_id;
@@ -0,0 +1,7 @@
+-- Indexes for the lookups the handlers make by user, post and conversation
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
+CREATE INDEX IF NOT EXISTS idx_posts_user_id ON posts (user_id);
+CREATE INDEX IF NOT EXISTS idx_comments_post_id ON comments (post_id);
+CREATE INDEX IF NOT EXISTS idx_post_likes_post_id ON post_likes (post_id);
+CREATE INDEX IF NOT EXISTS idx_chats_conversation ON chats (sender_id, receiver_id, sent_at);
+CREATE INDEX IF NOT EXISTS idx_notifications_user_id ON notifications (user_id, is_read);
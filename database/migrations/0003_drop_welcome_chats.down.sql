@@ -0,0 +1,3 @@
+-- irreversible
+-- The deleted welcome rows pointed at a user who never existed and cannot be
+-- stored again now that foreign keys are enforced
@@ -0,0 +1,3 @@
+-- Registration used to store a welcome message addressed to user 0, which does
+-- not exist. Nothing reads those rows and foreign keys now reject them.
+DELETE FROM chats WHERE receiver_id NOT IN (SELECT id FROM users);
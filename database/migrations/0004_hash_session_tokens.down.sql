@@ -0,0 +1,3 @@
+-- The stored digests are useless as tokens, so the sessions cannot be kept
+DELETE FROM sessions;
+ALTER TABLE sessions RENAME COLUMN token_hash TO token;
@@ -0,0 +1,5 @@
+-- Sessions are now stored under a digest of their token, like every other
+-- credential. Existing rows hold raw tokens that cannot be hashed in SQL, so
+-- they are dropped and everyone signs in once more.
+DELETE FROM sessions;
+ALTER TABLE sessions RENAME COLUMN token TO token_hash;
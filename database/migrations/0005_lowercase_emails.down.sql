@@ -0,0 +1,2 @@
+-- The original casing of the emails is not restored
+DROP INDEX IF EXISTS idx_users_email_lower;
@@ -0,0 +1,6 @@
+-- Registration used to store emails as typed while every lookup lowercases them,
+-- so mixed-case addresses could not be used to log in. Accounts whose emails
+-- differ only by case would break the index below, so migrationChecks reports
+-- them before this runs and one of each must be changed first.
+UPDATE users SET email = lower(trim(email));
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_lower ON users (lower(email));
@@ -0,0 +1,19 @@
+-- Invites minted with the invite command have no creator to keep them, so they
+-- are dropped along with the record of who joined with them
+DELETE FROM invite_uses WHERE invite_id IN (SELECT id FROM invites WHERE created_by IS NULL);
+CREATE TABLE invites_old (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	code_hash TEXT NOT NULL UNIQUE,
+	created_by INTEGER NOT NULL,
+	max_uses INTEGER NOT NULL,
+	uses INTEGER NOT NULL DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	expires_at DATETIME NOT NULL,
+	revoked_at DATETIME,
+	FOREIGN KEY (created_by) REFERENCES users (id) ON DELETE CASCADE
+);
+INSERT INTO invites_old (id, code_hash, created_by, max_uses, uses, created_at, expires_at, revoked_at)
+	SELECT id, code_hash, created_by, max_uses, uses, created_at, expires_at, revoked_at FROM invites
+	WHERE created_by IS NOT NULL;
+DROP TABLE invites;
+ALTER TABLE invites_old RENAME TO invites;
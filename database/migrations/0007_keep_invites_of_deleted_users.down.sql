@@ -0,0 +1,15 @@
+CREATE TABLE invites_old (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	code_hash TEXT NOT NULL UNIQUE,
+	created_by INTEGER,
+	max_uses INTEGER NOT NULL,
+	uses INTEGER NOT NULL DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	expires_at DATETIME NOT NULL,
+	revoked_at DATETIME,
+	FOREIGN KEY (created_by) REFERENCES users (id) ON DELETE CASCADE
+);
+INSERT INTO invites_old (id, code_hash, created_by, max_uses, uses, created_at, expires_at, revoked_at)
+	SELECT id, code_hash, created_by, max_uses, uses, created_at, expires_at, revoked_at FROM invites;
+DROP TABLE invites;
+ALTER TABLE invites_old RENAME TO invites;
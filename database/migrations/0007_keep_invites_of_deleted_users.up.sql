@@ -0,0 +1,19 @@
+-- Deleting an account used to delete its invites and, through them, the record
+-- of who joined with each. The invites now outlive their creator, who is
+-- forgotten, so the audit trail survives. SQLite cannot change a foreign key in
+-- place, so the table is rebuilt.
+CREATE TABLE invites_new (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	code_hash TEXT NOT NULL UNIQUE,
+	created_by INTEGER,
+	max_uses INTEGER NOT NULL,
+	uses INTEGER NOT NULL DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	expires_at DATETIME NOT NULL,
+	revoked_at DATETIME,
+	FOREIGN KEY (created_by) REFERENCES users (id) ON DELETE SET NULL
+);
+INSERT INTO invites_new (id, code_hash, created_by, max_uses, uses, created_at, expires_at, revoked_at)
+	SELECT id, code_hash, created_by, max_uses, uses, created_at, expires_at, revoked_at FROM invites;
+DROP TABLE invites;
+ALTER TABLE invites_new RENAME TO invites;
@@ -0,0 +1,119 @@
+-- A database as the forum created it before versioned migrations: tables made at
+-- startup, user input stored HTML-escaped, the session token kept on the user and
+-- a welcome chat addressed to user 0 for every registration.
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    nickname TEXT UNIQUE NOT NULL,
+    email TEXT UNIQUE NOT NULL,
+    password TEXT NOT NULL,
+    first_name TEXT NOT NULL,
+    last_name TEXT NOT NULL,
+    age INTEGER NOT NULL,
+    gender TEXT NOT NULL CHECK (gender IN ('Male', 'Female')),
+    session_token TEXT DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE posts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    title TEXT NOT NULL,
+    content TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE categories (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT UNIQUE NOT NULL
+);
+
+CREATE TABLE post_categories (
+    post_id INTEGER NOT NULL,
+    category_id INTEGER NOT NULL,
+    PRIMARY KEY (post_id, category_id),
+    FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE,
+    FOREIGN KEY (category_id) REFERENCES categories (id) ON DELETE CASCADE
+);
+
+CREATE TABLE comments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    post_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE post_likes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    post_id INTEGER NOT NULL,
+    is_like BOOLEAN NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE,
+    UNIQUE (user_id, post_id)
+);
+
+CREATE TABLE comment_likes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    comment_id INTEGER NOT NULL,
+    is_like BOOLEAN NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (comment_id, user_id),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (comment_id) REFERENCES comments (id) ON DELETE CASCADE
+);
+
+CREATE TABLE chats (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    sender_id INTEGER NOT NULL,
+    receiver_id INTEGER NOT NULL,
+    message TEXT NOT NULL,
+    sent_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    meta_data TEXT DEFAULT NULL,
+    FOREIGN KEY (sender_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (receiver_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE user_status (
+    user_id INTEGER PRIMARY KEY,
+    is_online BOOLEAN NOT NULL DEFAULT FALSE,
+    last_seen DATETIME,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE notifications (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    sender_id INTEGER NOT NULL,
+    is_read BOOLEAN DEFAULT FALSE,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT INTO categories (name) VALUES ('Technology'), ('Lifestyle'), ('Travel'), ('Food'), ('Sport'), ('Other');
+
+INSERT INTO users (id, nickname, email, password, first_name, last_name, age, gender, session_token) VALUES
+    (1, 'alice', 'Alice@Example.com', '$2a$10$legacyhashlegacyhashlegacyhashlegacyhashlegacyhashleg', 'Zo&#39;e', 'O&#39;Brien', 30, 'Female', 'raw-session-token'),
+    (2, 'b&lt;o&gt;b', 'bob@example.com', '$2a$10$legacyhashlegacyhashlegacyhashlegacyhashlegacyhashleg', 'Bob', 'Smith &amp; Sons', 41, 'Male', '');
+
+INSERT INTO posts (id, user_id, title, content) VALUES
+    (1, 1, 'Fish &amp; Chips', '1 &lt; 2 &amp;&amp; &quot;quoted&quot;'),
+    (2, 2, 'Plain title', 'No entities here');
+INSERT INTO post_categories (post_id, category_id) VALUES (1, 4), (2, 1);
+INSERT INTO post_likes (user_id, post_id, is_like) VALUES (2, 1, TRUE);
+
+INSERT INTO comments (id, post_id, user_id, content) VALUES (1, 1, 2, 'Tom &amp; Jerry &lt;3');
+INSERT INTO comment_likes (user_id, comment_id, is_like) VALUES (1, 1, FALSE);
+
+INSERT INTO chats (sender_id, receiver_id, message, meta_data) VALUES
+    (1, 0, 'Welcome to the forum!', NULL),
+    (2, 0, 'Welcome to the forum!', NULL),
+    (1, 2, 'Hi bob', '{&quot;status&quot;:&quot;sent&quot;}');
+INSERT INTO notifications (user_id, sender_id) VALUES (2, 1);
+INSERT INTO user_status (user_id, is_online) VALUES (1, FALSE), (2, FALSE);
@@ -0,0 +1,88 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+)
+
+// usersTableColumns is the users table of the baseline migration, which legacy tables are rebuilt into
+const usersTableColumns = `(
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            nickname TEXT UNIQUE NOT NULL,
+            email TEXT UNIQUE NOT NULL,
+            password TEXT NOT NULL,
+            first_name TEXT NOT NULL,
+            last_name TEXT NOT NULL,
+            age INTEGER,
+            gender TEXT NOT NULL,
+            email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+            totp_secret TEXT NOT NULL DEFAULT '',
+            totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+            totp_last_step INTEGER NOT NULL DEFAULT 0,
+            role TEXT NOT NULL DEFAULT 'member',
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        )`
+
+// usersTableCopyColumns lists every column carried over when the users table is rebuilt
+const usersTableCopyColumns = "id, nickname, email, password, first_name, last_name, age, gender, email_verified, totp_secret, totp_enabled, totp_last_step, role, created_at"
+
+// rebuildLegacyUsersTable migrates a users table created with the old schema, which
+// required an age and only allowed 'Male' or 'Female'. SQLite cannot drop those
+// constraints in place, so the table is copied into a new one and swapped in.
+func rebuildLegacyUsersTable(db *sql.DB) error {
+	var schema string
+	err := db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'users'").Scan(&schema)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(schema, "CHECK (gender IN") && !strings.Contains(schema, "age INTEGER NOT NULL") {
+		return nil
+	}
+
+	// Foreign keys must be off while the referenced table is replaced, and the
+	// pragma only applies to the connection it runs on
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var foreignKeys bool
+	if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeys); err != nil {
+		return err
+	}
+	if foreignKeys {
+		if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		"CREATE TABLE users_new " + usersTableColumns,
+		"INSERT INTO users_new (" + usersTableCopyColumns + ") SELECT " + usersTableCopyColumns + " FROM users",
+		"DROP TABLE users",
+		"ALTER TABLE users_new RENAME TO users",
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			log.Printf("Error rebuilding 'users' table: %v", err)
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Println("'users' table rebuilt with configurable gender and optional age")
+	return nil
+}
@@ -8,4 +8,6 @@ require (
 	golang.org/x/crypto v0.29.0
 )
 
-require github.com/gorilla/websocket v1.5.3 // indirect
+require github.com/gorilla/websocket v1.5.3
+
+require golang.org/x/sys v0.27.0 // indirect
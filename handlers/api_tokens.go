@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// APITokenConfig limits personal access tokens
+type APITokenConfig struct {
+	// MaxPerUser caps how many tokens one account may hold
+	MaxPerUser int
+	// MaxNameLength is the longest token name, in characters
+	MaxNameLength int
+	// LastUsedInterval limits how often a token's last-used time is written back
+	LastUsedInterval time.Duration
+}
+
+// defaultAPITokens are the token limits used unless configured otherwise
+var defaultAPITokens = APITokenConfig{
+	MaxPerUser:       20,
+	MaxNameLength:    50,
+	LastUsedInterval: 1 * time.Minute,
+}
+
+// apiTokenPrefix marks personal access tokens so they are easy to recognise in
+// scripts and secret scanners
+const apiTokenPrefix = "fpat_"
+
+// TokenScope is a permission granted to a personal access token
+type TokenScope string
+
+const (
+	ScopeReadPosts  TokenScope = "posts:read"
+	ScopeWritePosts TokenScope = "posts:write"
+	ScopeChat       TokenScope = "chat"
+)
+
+var tokenScopes = []TokenScope{ScopeReadPosts, ScopeWritePosts, ScopeChat}
+
+// endpointScopes lists the endpoints a token may call and the scope each needs.
+// Every other endpoint, including account and token management, refuses tokens.
+var endpointScopes = map[string]TokenScope{
+	"/show_posts":        ScopeReadPosts,
+	"/get_categories":    ScopeReadPosts,
+	"/post_submit":       ScopeWritePosts,
+	"/comment_submit":    ScopeWritePosts,
+	"/interact":          ScopeWritePosts,
+	"/delete_post":       ScopeWritePosts,
+	"/delete_comment":    ScopeWritePosts,
+	"/get_all_users":     ScopeChat,
+	"/fetch_messages":    ScopeChat,
+	"/ws":                ScopeChat,
+	"/mark-read":         ScopeChat,
+	"/get-notifications": ScopeChat,
+}
+
+func (s TokenScope) valid() bool {
+	for _, scope := range tokenScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIToken describes a personal access token to its owner; the secret itself is never stored
+type APIToken struct {
+	ID         int64        `json:"id"`
+	Name       string       `json:"name"`
+	Scopes     []TokenScope `json:"scopes"`
+	CreatedAt  time.Time    `json:"created_at"`
+	LastUsedAt *time.Time   `json:"last_used_at"`
+}
+
+type apiTokenContextKey struct{}
+
+// apiTokenSession returns the session of a request authenticated by APITokenMiddleware
+func apiTokenSession(r *http.Request) *Session {
+	session, _ := r.Context().Value(apiTokenContextKey{}).(*Session)
+	return session
+}
+
+// apiTokenSessionHash is the stand-in session digest of requests made with the
+// given API token; it lets chat connections opened with the token be found again
+func apiTokenSessionHash(tokenID int64) string {
+	return "api_token:" + strconv.FormatInt(tokenID, 10)
+}
+
+// bearerToken extracts the credential of an Authorization: Bearer header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// APITokenMiddleware authenticates requests carrying a personal access token in an
+// Authorization: Bearer header. A token only reaches the endpoints its scopes cover.
+// Such requests skip the CSRF check, as browsers never attach the header on their own.
+func (s *Server) APITokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, ok := bearerToken(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		session, err := s.lookupAPIToken(raw)
+		if err == sql.ErrNoRows {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			jsonError(w, http.StatusUnauthorized, "Invalid or revoked API token")
+			return
+		} else if err != nil {
+			log.Printf("Error resolving API token: %v", err)
+			jsonError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		scope, ok := endpointScopes[r.URL.Path]
+		if !ok {
+			jsonError(w, http.StatusForbidden, "API tokens cannot be used for this endpoint")
+			return
+		}
+		if !session.hasScope(scope) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+string(scope)+`"`)
+			jsonError(w, http.StatusForbidden, "This API token lacks the "+string(scope)+" scope")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiTokenContextKey{}, session)))
+	})
+}
+
+// hasScope reports whether the session may use the scope; browser sessions may use all of them
+func (s *Session) hasScope(scope TokenScope) bool {
+	if s.APITokenID == 0 {
+		return true
+	}
+	for _, granted := range s.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupAPIToken resolves a raw token to a session acting for its owner and records
+// its use. Unknown or revoked tokens are reported as sql.ErrNoRows.
+func (s *Server) lookupAPIToken(raw string) (*Session, error) {
+	if !strings.HasPrefix(raw, apiTokenPrefix) {
+		return nil, sql.ErrNoRows
+	}
+
+	token, err := s.stores.APITokens.ByHash(hashToken(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	session := &Session{
+		TokenHash:  apiTokenSessionHash(token.ID),
+		UserID:     token.UserID,
+		Nickname:   token.Nickname,
+		Role:       Role(token.Role),
+		CreatedAt:  token.CreatedAt,
+		APITokenID: token.ID,
+		Scopes:     tokenScopesOf(token.Scopes),
+	}
+
+	now := time.Now().UTC()
+	session.LastSeenAt = now
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= s.cfg.APITokens.LastUsedInterval {
+		if err := s.stores.APITokens.Touch(token.ID, now); err != nil {
+			log.Printf("Error recording API token use: %v", err)
+		}
+	}
+	return session, nil
+}
+
+func (s *Server) listAPITokens(userID int) ([]APIToken, error) {
+	records, err := s.stores.APITokens.ListByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := []APIToken{}
+	for _, record := range records {
+		tokens = append(tokens, APIToken{
+			ID:         record.ID,
+			Name:       record.Name,
+			Scopes:     tokenScopesOf(record.Scopes),
+			CreatedAt:  record.CreatedAt,
+			LastUsedAt: record.LastUsedAt,
+		})
+	}
+	return tokens, nil
+}
+
+// tokenScopesOf converts stored scope names
+func tokenScopesOf(names []string) []TokenScope {
+	scopes := []TokenScope{}
+	for _, name := range names {
+		scopes = append(scopes, TokenScope(name))
+	}
+	return scopes
+}
+
+// APITokensHandler lists the logged-in user's personal access tokens and the scopes on offer
+func (s *Server) APITokensHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := s.requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	tokens, err := s.listAPITokens(session.UserID)
+	if err != nil {
+		log.Printf("Error listing API tokens: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	jsonResponse(w, map[string]interface{}{
+		"tokens": tokens,
+		"scopes": tokenScopes,
+	})
+}
+
+// CreateAPITokenHandler issues a named personal access token with the requested scopes.
+// The token is shown once in the response; only its hash is kept.
+func (s *Server) CreateAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := s.requireSession(w, r)
+	if !ok {
+		return
+	}
+	if !s.requireFreshSecondFactor(w, session) {
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		jsonError(w, http.StatusBadRequest, "Token name cannot be empty")
+		return
+	}
+	if utf8.RuneCountInString(name) > s.cfg.APITokens.MaxNameLength {
+		jsonError(w, http.StatusBadRequest, "Token name cannot be longer than "+strconv.Itoa(s.cfg.APITokens.MaxNameLength)+" characters")
+		return
+	}
+
+	var scopes []string
+	seen := make(map[TokenScope]bool)
+	for _, value := range r.Form["scope"] {
+		scope := TokenScope(value)
+		if !scope.valid() {
+			jsonError(w, http.StatusBadRequest, "Unknown scope "+value)
+			return
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, value)
+		}
+	}
+	if len(scopes) == 0 {
+		jsonError(w, http.StatusBadRequest, "Choose at least one scope")
+		return
+	}
+
+	count, err := s.stores.APITokens.CountByUser(session.UserID)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if count >= s.cfg.APITokens.MaxPerUser {
+		jsonError(w, http.StatusConflict, "You already have "+strconv.Itoa(count)+" tokens; revoke one before creating another")
+		return
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		log.Printf("Error generating API token: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	token := apiTokenPrefix + secret
+
+	id, err := s.stores.APITokens.Create(session.UserID, name, hashToken(token), scopes, time.Now().UTC())
+	if err != nil {
+		log.Printf("Error storing API token: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Failed to create token")
+		return
+	}
+
+	jsonResponse(w, map[string]interface{}{
+		"message": "Token created. Copy it now; it will not be shown again.",
+		"id":      id,
+		"token":   token,
+	})
+}
+
+// RevokeAPITokenHandler deletes one of the logged-in user's tokens and closes chat
+// connections opened with it
+func (s *Server) RevokeAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := s.requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	tokenID, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, "Invalid token ID")
+		return
+	}
+
+	revoked, err := s.stores.APITokens.Delete(tokenID, session.UserID)
+	if err != nil {
+		log.Printf("Error revoking API token: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Failed to revoke token")
+		return
+	}
+	if !revoked {
+		jsonError(w, http.StatusNotFound, "Token not found")
+		return
+	}
+
+	s.disconnectSession(apiTokenSessionHash(tokenID), "API token revoked")
+
+	jsonResponse(w, map[string]string{"message": "Token revoked"})
+}
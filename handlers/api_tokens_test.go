@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// createAPIToken issues a token with the scopes and returns its ID and value
+func createAPIToken(t *testing.T, c *testClient, scopes ...string) (int64, string) {
+	t.Helper()
+	resp := c.post("/tokens/create", url.Values{"name": {"test"}, "scope": scopes})
+	expectStatus(t, resp, http.StatusOK)
+	body := decodeJSON(t, resp)
+	token, _ := body["token"].(string)
+	id, _ := body["id"].(float64)
+	if !strings.HasPrefix(token, apiTokenPrefix) || id == 0 {
+		t.Fatalf("create token = %v", body)
+	}
+	return int64(id), token
+}
+
+func TestAPITokenScopes(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, nil)
+	c := ts.signUp(t, "alice")
+	_, token := createAPIToken(t, c, string(ScopeReadPosts))
+
+	api := ts.client(t)
+	api.bearer = token
+	expectStatus(t, api.get("/show_posts"), http.StatusOK)
+	expectStatus(t, api.post("/post_submit", url.Values{"title": {"Hi"}, "content": {"There"}, "category": {"Technology"}}), http.StatusForbidden)
+	// Account management never accepts tokens
+	expectStatus(t, api.get("/sessions"), http.StatusForbidden)
+
+	api.bearer = token + "x"
+	expectStatus(t, api.get("/show_posts"), http.StatusUnauthorized)
+}
+
+func TestRevokedAPITokenStopsWorking(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, nil)
+	c := ts.signUp(t, "alice")
+	id, token := createAPIToken(t, c, string(ScopeReadPosts))
+
+	api := ts.client(t)
+	api.bearer = token
+	expectStatus(t, api.get("/show_posts"), http.StatusOK)
+
+	expectStatus(t, ts.signUp(t, "bob").post("/tokens/revoke", url.Values{"id": {strconv.FormatInt(id, 10)}}), http.StatusNotFound)
+	expectStatus(t, c.post("/tokens/revoke", url.Values{"id": {strconv.FormatInt(id, 10)}}), http.StatusOK)
+	expectStatus(t, api.get("/show_posts"), http.StatusUnauthorized)
+}
+
+func TestAPITokenLimit(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, func(cfg *Config) { cfg.APITokens.MaxPerUser = 1 })
+	c := ts.signUp(t, "alice")
+
+	createAPIToken(t, c, string(ScopeChat))
+	expectStatus(t, c.post("/tokens/create", url.Values{"name": {"second"}, "scope": {string(ScopeChat)}}), http.StatusConflict)
+}
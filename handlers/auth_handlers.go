@@ -6,18 +6,19 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
-	"github.com/gofrs/uuid/v5"
 	"golang.org/x/crypto/bcrypt"
 
-	"forum/database"
+	"forum/models"
+	"forum/passhash"
+	"forum/store"
 	"forum/utils"
 )
 
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodPost {
@@ -27,14 +28,14 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	identifier := utils.EscapeString(r.FormValue("email"))
-	password := utils.EscapeString(r.FormValue("password"))
-	lowerIdentifier := strings.ToLower(identifier)
+	identifier := r.FormValue("email")
+	password := r.FormValue("password")
 
 	const maxIdentifier = 100
-	const maxPassword = 100
+	// Any password registration accepts must be able to log in
+	maxPassword := s.cfg.Passwords.MaxLength
 
-	if len(identifier) > maxIdentifier {
+	if utf8.RuneCountInString(identifier) > maxIdentifier {
 		response := map[string]string{"error": fmt.Sprintf("Nickname/Email cannot be longer than %d characters", maxIdentifier)}
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(response)
@@ -48,65 +49,185 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var userID int
-	var storedPassword, sessionToken, nickname string
-	err := database.DB.QueryRow(
-		"SELECT id, password, session_token, nickname FROM users WHERE email = ? OR nickname = ?",
-		lowerIdentifier,
-		identifier,
-	).Scan(&userID, &storedPassword, &sessionToken, &nickname)
+	identifierKey := identifierThrottleKey(identifier)
+	ipKey := ipThrottleKey(clientIP(r))
+
+	wait, err := s.loginRetryAfter(identifierKey, ipKey)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			response := map[string]string{"error": "Invalid nickname/email or password"}
-			w.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(w).Encode(response)
-		} else {
-			log.Printf("Database error: %v", err)
-			response := map[string]string{"error": "Internal server error"}
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(response)
-		}
+		log.Printf("Error checking login throttle: %v", err)
+		response := map[string]string{"error": "Internal server error"}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	if wait > 0 {
+		writeRetryAfter(w, wait)
+		return
+	}
+
+	user, err := s.stores.Users.ByLogin(identifier)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Database error: %v", err)
+		response := map[string]string{"error": "Internal server error"}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(response)
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(password)); err != nil {
-		response := map[string]string{"error": "Invalid username/email or password"}
+	// Unknown accounts and wrong passwords are refused alike, after the same
+	// amount of hashing, so that the response does not reveal which accounts exist
+	valid := false
+	if err == sql.ErrNoRows {
+		s.verifyDummyPassword(password)
+	} else if valid, err = s.passwordMatches(user.ID, user.PasswordHash, password); err != nil {
+		log.Printf("Error checking password: %v", err)
+		response := map[string]string{"error": "Internal server error"}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	if !valid {
+		s.recordFailedLogin(identifierKey, ipKey)
+		response := map[string]string{"error": "Invalid nickname/email or password"}
 		w.WriteHeader(http.StatusUnauthorized)
 		json.NewEncoder(w).Encode(response)
 		return
 	}
 
-	newSessionToken, _ := uuid.NewV4()
-	sessionToken = newSessionToken.String()
+	userID, nickname := user.ID, user.Nickname
+	if user.TwoFactorEnabled {
+		challenge, err := s.createMFAChallenge(userID)
+		if err != nil {
+			log.Printf("Error creating two-factor challenge: %v", err)
+			response := map[string]string{"error": "Internal server error"}
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"twoFactorRequired": true,
+			"challenge":         challenge,
+			"message":           "Enter the code from your authenticator app or a recovery code",
+		})
+		return
+	}
 
-	_, err = database.DB.Exec(
-		"UPDATE users SET session_token = ? WHERE id = ?",
-		sessionToken,
-		userID,
-	)
+	s.completeLogin(w, r, userID, nickname, false, identifierKey)
+}
+
+// completeLogin starts a session for a user who has passed every login step
+// and forgets the failed attempts recorded against them
+func (s *Server) completeLogin(w http.ResponseWriter, r *http.Request, userID int, nickname string, secondFactor bool, identifierKeys ...string) {
+	for _, key := range append(identifierKeys, identifierThrottleKey(nickname)) {
+		if err := s.clearLoginFailures(key); err != nil {
+			log.Printf("Error clearing login failures: %v", err)
+		}
+	}
+
+	session, err := s.startSession(w, r, userID, secondFactor)
 	if err != nil {
-		log.Printf("Error updating session token: %v", err)
+		log.Printf("Error creating session: %v", err)
 		response := map[string]string{"error": "Internal server error"}
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(response)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:    "session_token",
-		Value:   sessionToken,
-		Expires: time.Now().Add(1 * time.Hour),
-	})
-
 	response := map[string]string{
-		"message":  "Login successful!",
-		"nickname": nickname,
+		"message":   "Login successful!",
+		"nickname":  nickname,
+		"csrfToken": session.CSRFToken,
 	}
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 }
 
-func RegisterHandler(w http.ResponseWriter, r *http.Request) {
+// startSession creates a session for the user and hands its cookie to the browser
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, userID int, secondFactor bool) (*Session, error) {
+	session, err := s.createSession(userID, r)
+	if err != nil {
+		return nil, err
+	}
+	if secondFactor {
+		if err := s.markSecondFactor(session); err != nil {
+			return nil, err
+		}
+	}
+	s.setSessionCookie(w, session)
+	return session, nil
+}
+
+// checkPassword verifies a user's current password, for actions that require re-authentication
+func (s *Server) checkPassword(userID int, password string) (bool, error) {
+	user, err := s.stores.Users.ByID(userID)
+	if err != nil {
+		return false, err
+	}
+	return s.passwordMatches(userID, user.PasswordHash, password)
+}
+
+// verifyDummyPassword checks the password against a hash made with the current
+// settings, to spend the time a real check would when no account matches a login
+func (s *Server) verifyDummyPassword(password string) {
+	s.dummyHashOnce.Do(func() {
+		hash, err := s.hashPassword("no account has this password")
+		if err != nil {
+			log.Printf("Error creating dummy password hash: %v", err)
+			return
+		}
+		s.dummyHash = hash
+	})
+	if s.dummyHash != "" {
+		s.verifyPassword(password, s.dummyHash)
+	}
+}
+
+// PasswordPolicy hashes new passwords with current and still accepts the bcrypt
+// hashes stored before argon2id was adopted
+func PasswordPolicy(current passhash.Hasher) *passhash.Policy {
+	return &passhash.Policy{
+		Current:  current,
+		Accepted: []passhash.Hasher{passhash.Bcrypt{Cost: bcrypt.DefaultCost}},
+	}
+}
+
+// passwordMatches compares a password with the stored hash. Hashes made with another
+// scheme or weaker settings than the policy's Current are replaced after a successful match.
+// Passwords set before raw input was stored were HTML-escaped before hashing; when only
+// the escaped form matches, the hash is replaced too so the fallback is needed just once.
+// Those hashes all predate argon2id, so the fallback is only tried on bcrypt hashes.
+func (s *Server) passwordMatches(userID int, storedPassword, password string) (bool, error) {
+	match, rehash, err := s.verifyPassword(password, storedPassword)
+	if err != nil {
+		return false, err
+	}
+
+	if !match {
+		legacy := utils.EscapeString(password)
+		if legacy == password || !(passhash.Bcrypt{}).Recognizes(storedPassword) {
+			return false, nil
+		}
+		if match, _, err = s.verifyPassword(legacy, storedPassword); err != nil || !match {
+			return false, err
+		}
+		rehash = true
+	}
+
+	if rehash {
+		// The login itself succeeded; a failed upgrade is retried on the next one
+		hashedPassword, err := s.hashPassword(password)
+		if err != nil {
+			log.Printf("Error upgrading password hash of user %d: %v", userID, err)
+		} else if err := s.stores.Users.SetPassword(userID, hashedPassword); err != nil {
+			log.Printf("Error upgrading password hash of user %d: %v", userID, err)
+		}
+	}
+	return true, nil
+}
+
+func (s *Server) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	response := make(map[string]string)
 
 	if r.Method != http.MethodPost {
@@ -116,21 +237,37 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if s.cfg.Registration.Mode == RegistrationClosed {
+		response = map[string]string{"error": "Registration is closed"}
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
 	err := r.ParseForm()
 	if err != nil {
 		http.Error(w, "Failed to parse form data", http.StatusBadRequest)
 		return
 	}
 
-	nickname := utils.EscapeString(r.FormValue("nickname"))
-	email := utils.EscapeString(r.FormValue("email"))
-	password := utils.EscapeString(r.FormValue("password"))
-	firstName := utils.EscapeString(r.FormValue("first_name"))
-	lastName := utils.EscapeString(r.FormValue("last_name"))
-	ageStr := r.FormValue("age")
-	gender := utils.EscapeString(r.FormValue("gender"))
+	inviteCode := strings.TrimSpace(r.FormValue("invite_code"))
+	if s.cfg.Registration.Mode == RegistrationInvite && inviteCode == "" {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  "Registration requires an invite code",
+			"fields": map[string]string{"invite_code": "Enter the invite code you were given"},
+		})
+		return
+	}
+
+	nickname := r.FormValue("nickname")
+	email := strings.ToLower(strings.TrimSpace(r.FormValue("email")))
+	password := r.FormValue("password")
+	firstName := r.FormValue("first_name")
+	lastName := r.FormValue("last_name")
+	gender := genderFromForm(r)
 
-	age, err := strconv.Atoi(ageStr)
+	age, err := parseAge(r.FormValue("age"))
 	if err != nil {
 		response = map[string]string{"error": "Invalid age value"}
 		w.WriteHeader(http.StatusBadRequest)
@@ -138,7 +275,7 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	errors, valid := ValidateInput(nickname, email, password, firstName, lastName, age, gender)
+	errors, valid := s.ValidateInput(nickname, email, password, firstName, lastName, age, gender)
 	if !valid {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -148,19 +285,17 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var existingNickname, existingEmail string
-	err = database.DB.QueryRow(
-		"SELECT nickname, email FROM users WHERE email = ? OR nickname = ?",
-		email,
-		nickname,
-	).Scan(&existingNickname, &existingEmail)
+	existing, err := s.stores.Users.ByNickname(nickname)
+	if err == sql.ErrNoRows {
+		existing, err = s.stores.Users.ByEmail(email)
+	}
 
 	if err == nil {
 		var conflictField, conflictMessage string
-		if existingNickname == nickname {
+		if existing.Nickname == nickname {
 			conflictField = "nickname"
 			conflictMessage = "Nickname already exists"
-		} else if existingEmail == email {
+		} else if existing.Email == email {
 			conflictField = "email"
 			conflictMessage = "Email already exists"
 		}
@@ -180,28 +315,24 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := s.hashPassword(password)
 	if err != nil {
+		log.Printf("Error hashing password: %v", err)
 		response = map[string]string{"error": "Error hashing password"}
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(response)
 		return
 	}
 
-	sessionToken, _ := uuid.NewV4()
-
-	result, err := database.DB.Exec(
-		"INSERT INTO users (nickname, email, password, first_name, last_name, age, gender, session_token) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
-		nickname,
-		email,
-		hashedPassword,
-		firstName,
-		lastName,
-		age,
-		gender,
-		sessionToken,
-	)
-	if err != nil {
+	userID, err := s.createUser(inviteCode, nickname, email, hashedPassword, firstName, lastName, age, gender)
+	if err == store.ErrInvalidInvite {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  "Invalid invite code",
+			"fields": map[string]string{"invite_code": "This invite code is invalid, expired or used up"},
+		})
+		return
+	} else if err != nil {
 		log.Printf("Error inserting user: %v", err)
 		response = map[string]string{"error": "Registration failed"}
 		w.WriteHeader(http.StatusInternalServerError)
@@ -209,47 +340,114 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID, err := result.LastInsertId()
-	if err != nil {
-		log.Printf("Error retrieving last insert ID: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	// Add the user to the chat with a welcome message to chats
-	_, err = database.DB.Exec(`
-        INSERT INTO chats (sender_id, receiver_id, message, sent_at, meta_data)
-        VALUES (?, ?, ?, CURRENT_TIMESTAMP, ?)`,
-		userID, 0, "Welcome to the chat!", nickname)
-	if err != nil {
-		log.Printf("Error inserting user into chat table: %v", err)
-		response = map[string]string{"error": "Chat registration failed"}
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	if err := s.sendVerificationEmail(userID, nickname, email); err != nil {
+		log.Printf("Error sending verification email: %v", err)
 	}
 
-	BroadcastNewUser(nickname, firstName, lastName)
+	s.BroadcastNewUser(nickname, firstName, lastName)
 
-	response = map[string]string{"message": "Registration successful! Please log in."}
+	response = map[string]string{"message": "Registration successful! Please confirm your email address, then log in."}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 }
 
-func LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("session_token")
+// createUser inserts a registered account. In invite-only mode the invite is used
+// up in the same transaction, so a code can never admit more accounts than allowed.
+func (s *Server) createUser(inviteCode, nickname, email, hashedPassword, firstName, lastName string, age *int, gender string) (int, error) {
+	user := &models.User{
+		Nickname:     nickname,
+		Email:        email,
+		PasswordHash: hashedPassword,
+		FirstName:    firstName,
+		LastName:     lastName,
+		Age:          age,
+		Gender:       gender,
+	}
+	if s.cfg.Registration.Mode == RegistrationInvite {
+		return s.stores.Invites.Register(hashToken(normalizeInviteCode(inviteCode)), user, time.Now().UTC())
+	}
+	return s.stores.Users.Create(user)
+}
+
+// LogoutHandler ends the current session. It only accepts POST, which
+// CSRFMiddleware guards, so another site cannot log the user out with a link.
+func (s *Server) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cookie, err := r.Cookie(sessionCookieName)
 	if err != nil {
 		http.Error(w, "You are not logged in", http.StatusBadRequest)
 		return
 	}
 
-	delete(sessionStore, cookie.Value)
+	session, err := s.lookupSession(cookie.Value)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error looking up session: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:    "session_token",
-		Value:   "guest",
-		Expires: time.Now().Add(-1 * time.Hour),
-	})
+	if session != nil {
+		if err := s.deleteSession(session.TokenHash); err != nil {
+			log.Printf("Error deleting session: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		s.disconnectSession(session.TokenHash, "User logged out")
+	}
+
+	s.clearSessionCookie(w)
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 	fmt.Fprintln(w, "You have been logged out.")
 }
+
+// LogoutAllHandler revokes every session of the current user, logging out all of their devices
+func (s *Server) LogoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Method not allowed"})
+		return
+	}
+
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "You are not logged in"})
+		return
+	}
+
+	session, err := s.lookupSession(cookie.Value)
+	if err == sql.ErrNoRows {
+		s.clearSessionCookie(w)
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "You are not logged in"})
+		return
+	} else if err != nil {
+		log.Printf("Error looking up session: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	revoked, err := s.deleteUserSessions(session.UserID)
+	if err != nil {
+		log.Printf("Error revoking sessions: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+	s.disconnectUser(session.Nickname, "Logged out on all devices")
+
+	s.clearSessionCookie(w)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Logged out on all devices",
+		"revoked": revoked,
+	})
+}
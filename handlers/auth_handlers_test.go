@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"forum/passhash"
+	"forum/utils"
+)
+
+func TestLoginByNicknameOrEmail(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, nil)
+
+	c := ts.client(t)
+	form := registrationForm("alice")
+	form.Set("email", " Alice@Example.COM ")
+	expectStatus(t, c.post("/register", form), http.StatusOK)
+	user, err := ts.stores.Users.ByNickname("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "alice@example.com" {
+		t.Errorf("stored email = %q, want it trimmed and lower-cased", user.Email)
+	}
+
+	for _, identifier := range []string{"alice", "alice@example.com", "ALICE@example.com"} {
+		c := ts.client(t)
+		if body := c.login(identifier, testPassword); body["nickname"] != "alice" {
+			t.Errorf("login as %q = %v, want alice", identifier, body)
+		}
+		if !c.loggedIn() {
+			t.Errorf("login as %q did not start a session", identifier)
+		}
+	}
+}
+
+func TestRegisterRefusesEmailDifferingInCase(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, nil)
+	c := ts.client(t)
+
+	expectStatus(t, c.post("/register", registrationForm("alice")), http.StatusOK)
+	form := registrationForm("alice2")
+	form.Set("email", "ALICE@example.com")
+	expectStatus(t, c.post("/register", form), http.StatusConflict)
+}
+
+func TestLoginAcceptsConfiguredPasswordLength(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, func(cfg *Config) { cfg.Passwords.MaxLength = 200 })
+	long := testPassword + strings.Repeat("x", 150-len(testPassword))
+
+	c := ts.client(t)
+	form := registrationForm("alice")
+	form.Set("password", long)
+	expectStatus(t, c.post("/register", form), http.StatusOK)
+	expectStatus(t, c.get(ts.mailer.lastLink(t, "alice@example.com", "/verify_email")), http.StatusSeeOther)
+	c.login("alice", long)
+
+	tooLong := url.Values{"email": {"alice"}, "password": {strings.Repeat("x", 201)}}
+	expectStatus(t, c.post("/login", tooLong), http.StatusBadRequest)
+}
+
+func TestFailedLoginsAreThrottled(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, nil)
+	ts.signUp(t, "alice")
+	c := ts.client(t)
+
+	wrong := url.Values{"email": {"alice"}, "password": {"wrong password"}}
+	for i := 0; i < ts.cfg.LoginThrottle.FreeAttempts; i++ {
+		expectStatus(t, c.post("/login", wrong), http.StatusUnauthorized)
+	}
+	resp := c.post("/login", url.Values{"email": {"alice"}, "password": {testPassword}})
+	expectStatus(t, resp, http.StatusTooManyRequests)
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("throttled login has no Retry-After header")
+	}
+}
+
+func TestSessionsAreStoredByDigest(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, nil)
+	c := ts.signUp(t, "alice")
+
+	u, _ := url.Parse(ts.URL)
+	var token string
+	for _, cookie := range c.http.Jar.Cookies(u) {
+		if cookie.Name == sessionCookieName {
+			token = cookie.Value
+		}
+	}
+	if token == "" {
+		t.Fatal("no session cookie")
+	}
+
+	if _, err := ts.stores.Sessions.ByTokenHash(token); err != sql.ErrNoRows {
+		t.Errorf("session found by its raw token: err = %v", err)
+	}
+	if _, err := ts.stores.Sessions.ByTokenHash(hashToken(token)); err != nil {
+		t.Errorf("session not found by its digest: %v", err)
+	}
+}
+
+func TestLogoutEndsOnlyThisDevice(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, nil)
+	laptop := ts.signUp(t, "alice")
+	phone := ts.client(t)
+	phone.login("alice", testPassword)
+
+	expectStatus(t, laptop.post("/logout", nil), http.StatusSeeOther)
+	if laptop.loggedIn() {
+		t.Error("laptop still logged in after logout")
+	}
+	if !phone.loggedIn() {
+		t.Error("logging out the laptop ended the phone's session")
+	}
+}
+
+func TestEscapedPasswordFallback(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, nil)
+	password := `Tom & "Jerry" <3 cheese`
+	escaped := utils.EscapeString(password)
+
+	bcryptHash, err := passhash.Bcrypt{}.Hash(escaped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	argonHash, err := ts.hashPassword(escaped)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Bcrypt hashes predate raw passwords, so the escaped form is accepted and
+	// the hash replaced with one of the raw password
+	id := ts.signUpUserID(t, "alice")
+	if ok, err := ts.passwordMatches(id, bcryptHash, password); err != nil || !ok {
+		t.Fatalf("escaped bcrypt password: match = %t, %v; want true", ok, err)
+	}
+	user, err := ts.stores.Users.ByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(user.PasswordHash, "$argon2id$") {
+		t.Errorf("hash was not upgraded: %q", user.PasswordHash)
+	}
+	if ok, _ := ts.passwordMatches(id, user.PasswordHash, password); !ok {
+		t.Error("upgraded hash does not match the raw password")
+	}
+
+	if ok, err := ts.passwordMatches(id, argonHash, password); err != nil || ok {
+		t.Errorf("escaped argon2id password: match = %t, %v; want false", ok, err)
+	}
+}
+
+func TestLoginUpgradesWeakerHashes(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, nil)
+	id := ts.signUpUserID(t, "alice")
+
+	weaker, err := passhash.Argon2id{Memory: 32, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}.Hash(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := passhash.Bcrypt{Cost: 4}.Hash(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, stored := range map[string]string{"weaker argon2id": weaker, "bcrypt": legacy} {
+		if err := ts.stores.Users.SetPassword(id, stored); err != nil {
+			t.Fatal(err)
+		}
+
+		// A wrong password leaves the hash alone
+		expectStatus(t, ts.client(t).post("/login", url.Values{"email": {"alice"}, "password": {"not the password"}}), http.StatusUnauthorized)
+		if user, _ := ts.stores.Users.ByID(id); user.PasswordHash != stored {
+			t.Errorf("%s: a failed login replaced the hash", name)
+		}
+
+		ts.client(t).login("alice", testPassword)
+		user, err := ts.stores.Users.ByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.PasswordHash == stored || !strings.HasPrefix(user.PasswordHash, "$argon2id$v=19$m=64,t=1,p=1$") {
+			t.Errorf("%s: hash after login = %q, want it upgraded to the current settings", name, user.PasswordHash)
+		}
+		ts.client(t).login("alice", testPassword)
+	}
+}
+
+// countingHasher counts the password checks it makes
+type countingHasher struct {
+	passhash.Hasher
+	verified atomic.Int64
+}
+
+func (h *countingHasher) Verify(password, encoded string) (bool, error) {
+	h.verified.Add(1)
+	return h.Hasher.Verify(password, encoded)
+}
+
+func TestFailedLoginsLookTheSame(t *testing.T) {
+	t.Parallel()
+	hasher := &countingHasher{Hasher: passhash.Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}}
+	ts := newTestServerWith(t, nil, func(_ Config, deps *Deps) { deps.Passwords = PasswordPolicy(hasher) })
+	ts.signUp(t, "alice")
+
+	// An unknown account costs a password check too, so it takes as long to refuse
+	var messages []interface{}
+	for _, identifier := range []string{"alice", "nobody", "nobody@example.com"} {
+		before := hasher.verified.Load()
+		resp := ts.client(t).post("/login", url.Values{"email": {identifier}, "password": {"not the password"}})
+		expectStatus(t, resp, http.StatusUnauthorized)
+		messages = append(messages, decodeJSON(t, resp)["error"])
+		if checks := hasher.verified.Load() - before; checks != 1 {
+			t.Errorf("login as %q made %d password checks, want 1", identifier, checks)
+		}
+	}
+	for _, message := range messages[1:] {
+		if message != messages[0] {
+			t.Errorf("failed logins answer %q and %q; want one message", messages[0], message)
+		}
+	}
+}
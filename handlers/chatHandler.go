@@ -6,9 +6,13 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
-	"forum/database"
+	"forum/models"
 
 	"github.com/gorilla/websocket"
 )
@@ -20,37 +24,52 @@ type TypingEvent struct {
 	IsTyping bool   `json:"isTyping"`
 }
 
-type Message struct {
-	Sender          string `json:"sender"`
-	Receiver        string `json:"receiver"`
-	Content         string `json:"content"`
-	Timestamp       string `json:"timestamp"`
-	SenderFirstName string `json:"firstName"`
-	SenderLastName  string `json:"lastName"`
+type Client struct {
+	conn          *websocket.Conn
+	userID        int
+	firstName     string
+	lastName      string
+	nickname      string
+	sessionHash   string
+	emailVerified bool
 }
 
-type Client struct {
-	conn      *websocket.Conn
-	firstName string
-	lastName  string
-	nickname  string
+// hub tracks the chat connections of one server
+type hub struct {
+	upgrader websocket.Upgrader
+	clients  map[*websocket.Conn]*Client
+	messages chan models.Message
+	// mu guards clients and serialises writes to the connections in it, since a
+	// websocket connection allows only one writer at a time
+	mu sync.Mutex
 }
 
-type User struct {
-	ID        int    `json:"id"`
-	Nickname  string `json:"nickname"`
-	FirstName string `json:"firstName"`
-	LastName  string `json:"lastName"`
-	IsOnline  bool   `json:"isOnline"`
-	LastSeen  string `json:"lastSeen,omitempty"`
+func newHub(checkOrigin func(r *http.Request) bool) *hub {
+	return &hub{
+		upgrader: websocket.Upgrader{CheckOrigin: checkOrigin},
+		clients:  make(map[*websocket.Conn]*Client),
+		messages: make(chan models.Message),
+	}
 }
 
-var (
-	upgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
-	clients  = make(map[*websocket.Conn]*Client)
-	messages = make(chan Message)
-	mu       sync.Mutex
-)
+// checkOrigin only lets the forum's own pages open a chat connection, so another
+// site cannot ride on the session cookie. Browsers always send an Origin; it may
+// only be missing for API token clients, whose credential no browser adds by itself.
+func (s *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return apiTokenSession(r) != nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	base, err := url.Parse(s.cfg.BaseURL)
+	return err == nil && strings.EqualFold(u.Scheme, base.Scheme) && strings.EqualFold(u.Host, base.Host)
+}
 
 // this method to safely access the connection
 func (c *Client) Conn() *websocket.Conn {
@@ -62,9 +81,9 @@ func (c *Client) SendJSON(v interface{}) error {
 	return c.conn.WriteJSON(v)
 }
 
-func BroadcastNewUser(nickname, firstName, lastName string) {
-	mu.Lock()
-	defer mu.Unlock()
+func (s *Server) BroadcastNewUser(nickname, firstName, lastName string) {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
 
 	msg := map[string]interface{}{
 		"type": "userRegistered",
@@ -75,42 +94,38 @@ func BroadcastNewUser(nickname, firstName, lastName string) {
 		},
 	}
 
-	for conn, client := range clients {
+	for conn, client := range s.hub.clients {
 		if err := client.SendJSON(msg); err != nil {
 			log.Printf("Broadcast error: %v", err)
 			client.Conn().Close()
-			delete(clients, conn)
+			delete(s.hub.clients, conn)
 		}
 	}
 }
 
-func HandleConnections(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Println("WebSocket upgrade error:", err)
+func (s *Server) HandleConnections(w http.ResponseWriter, r *http.Request) {
+	// Resolve the user from the session cookie before upgrading; the
+	// connection is bound to that identity for its whole lifetime
+	session, ok := s.requireSession(w, r)
+	if !ok {
 		return
 	}
-	defer conn.Close()
+	nickname, userID := session.Nickname, session.UserID
 
-	nickname := r.URL.Query().Get("nickname")
-	if nickname == "" {
+	conn, err := s.hub.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("WebSocket upgrade error:", err)
 		return
 	}
+	defer conn.Close()
 
-	if err := updateUserStatus(nickname, true); err != nil {
+	if err := s.stores.Chats.SetOnline(userID, true); err != nil {
 		log.Println("Error updating user status:", err)
 		return
 	}
 
-	// Get the user's ID based on nickname (you'll need to implement this)
-	userID, err := getUserIDByNickname(nickname)
-	if err != nil {
-		log.Println("Error getting user ID:", err)
-		return
-	}
-
 	// Fetch and send pending notifications on connection
-	notifications, err := fetchUnreadNotifications(nickname)
+	notifications, err := s.fetchUnreadNotifications(userID)
 	if err != nil {
 		log.Println("Error fetching notifications:", err)
 	} else {
@@ -124,7 +139,7 @@ func HandleConnections(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get users who have conversations with this user
-	usersWithConversations, err := getUsersWithConversations(userID)
+	usersWithConversations, err := s.stores.Chats.Partners(userID)
 	if err != nil {
 		log.Println("Error getting conversation users:", err)
 	} else {
@@ -132,25 +147,27 @@ func HandleConnections(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get users who don't have conversations with this user
-	usersWithoutConversations, err := getUsersWithoutConversations(userID)
+	usersWithoutConversations, err := s.stores.Chats.Strangers(userID)
 	if err != nil {
 		log.Println("Error getting non-conversation users:", err)
 	} else {
 		log.Printf("User %s has no conversations with: %v\n", nickname, usersWithoutConversations)
 	}
 
-	client, err := createClient(conn, nickname)
+	client, err := s.createClient(conn, nickname, session.TokenHash)
 	if err != nil {
 		log.Println("Error creating client:", err)
 		return
 	}
 
-	mu.Lock()
-	clients[conn] = client
-	broadcastOnlineUsers()
-	mu.Unlock()
+	s.hub.mu.Lock()
+	s.hub.clients[conn] = client
+	s.hub.mu.Unlock()
+	defer s.cleanupClient(conn, userID)
+	s.broadcastOnlineUsers()
 
 	// Send conversation data to the client
+	s.hub.mu.Lock()
 	conn.WriteJSON(map[string]interface{}{
 		"type": "conversation_data",
 		"data": map[string]interface{}{
@@ -158,99 +175,97 @@ func HandleConnections(w http.ResponseWriter, r *http.Request) {
 			"without_conversations": usersWithoutConversations,
 		},
 	})
-
-	defer cleanupClient(conn, nickname)
+	s.hub.mu.Unlock()
 
 	for {
 		// First read the message as raw JSON to check type
 		_, msgBytes, err := conn.ReadMessage()
 		if err != nil {
 			log.Println("Read error:", err)
-			mu.Lock()
-			delete(clients, conn)
-			broadcastOnlineUsers()
-			mu.Unlock()
 			break
 		}
 
+		// Unverified accounts can watch but not chat
+		if !s.canChat(client) {
+			s.hub.mu.Lock()
+			conn.WriteJSON(map[string]string{
+				"type":  "error",
+				"error": "Please verify your email address before chatting.",
+			})
+			s.hub.mu.Unlock()
+			continue
+		}
+
 		// Check if it's a typing event
 		if bytes.Contains(msgBytes, []byte(`"type":"typing"`)) {
 			var typingEvent TypingEvent
 			if err := json.Unmarshal(msgBytes, &typingEvent); err == nil {
-				handleTypingEvent(typingEvent)
+				typingEvent.Sender = nickname
+				s.handleTypingEvent(typingEvent)
 				continue // Skip normal message processing
 			}
 		}
 
 		// Otherwise process as normal message
-		var msg Message
+		var msg models.Message
 		if err := json.Unmarshal(msgBytes, &msg); err != nil {
 			log.Println("Error parsing message:", err)
 			continue
 		}
+		// Never trust the sender claimed by the client
+		msg.Sender = nickname
 
-		saveMessage(msg.Sender, msg.Receiver, msg.Content)
+		s.saveMessage(userID, msg.Receiver, msg.Content)
 		if msg.Receiver != "" {
-			sendPrivateMessage(msg)
+			s.sendPrivateMessage(msg)
 		} else {
-			messages <- msg
+			select {
+			case s.hub.messages <- msg:
+			case <-s.done:
+			}
 		}
 	}
 }
 
-func handleTypingEvent(event TypingEvent) {
-	mu.Lock()
-	defer mu.Unlock()
+func (s *Server) handleTypingEvent(event TypingEvent) {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
 
-	for clientConn, client := range clients {
+	for clientConn, client := range s.hub.clients {
 		if client.nickname == event.Receiver {
 			clientConn.WriteJSON(event)
 		}
 	}
 }
 
-// Get user ID from nickname
-func getUserIDByNickname(nickname string) (int, error) {
-	var id int
-	err := database.DB.QueryRow("SELECT id FROM users WHERE nickname = ?", nickname).Scan(&id)
-	if err != nil {
-		return 0, err
-	}
-	return id, nil
-}
-
-func fetchUnreadNotifications(nickname string) ([]map[string]interface{}, error) {
-	rows, err := database.DB.Query(`
-        SELECT 
-            n.id,
-            u.nickname as sender
-        FROM notifications n
-        JOIN users u ON n.sender_id = u.id
-        WHERE n.user_id = (SELECT id FROM users WHERE nickname = ?)
-        ORDER BY n.created_at DESC`,
-		nickname)
+func (s *Server) fetchUnreadNotifications(userID int) ([]map[string]interface{}, error) {
+	pending, err := s.stores.Notifications.Pending(userID)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
 	var notifications []map[string]interface{}
-	for rows.Next() {
-		var id int
-		var sender string
-		if err := rows.Scan(&id, &sender); err != nil {
-			return nil, err
-		}
+	for _, n := range pending {
 		notifications = append(notifications, map[string]interface{}{
 			"type":   "notification",
-			"sender": sender,
-			"db_id":  id, // For marking as read later
+			"sender": n.Sender,
+			"db_id":  n.ID, // For marking as read later
 		})
 	}
 	return notifications, nil
 }
 
-func MarkNotificationsRead(w http.ResponseWriter, r *http.Request) {
+func (s *Server) MarkNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, ok := s.requireSession(w, r)
+	if !ok {
+		return
+	}
+
 	var request struct {
 		Receiver string `json:"receiver"`
 		Sender   string `json:"sender"`
@@ -261,145 +276,139 @@ func MarkNotificationsRead(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// DELETE instead of UPDATE
-	_, err := database.DB.Exec(`
-        DELETE FROM notifications 
-        WHERE user_id = (SELECT id FROM users WHERE nickname = ?)
-        AND sender_id = (SELECT id FROM users WHERE nickname = ?)`,
-		request.Receiver, request.Sender)
-	if err != nil {
-		http.Error(w, "Deletion failed", http.StatusInternalServerError)
+	if actingAsOtherUser(request.Receiver, session.Nickname) {
+		http.Error(w, "Forbidden: cannot modify another user's notifications", http.StatusForbidden)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	// An unknown sender has no notifications to clear
+	senderID, err := s.stores.Users.IDByNickname(request.Sender)
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, "Deletion failed", http.StatusInternalServerError)
+		return
+	}
+	if err == nil {
+		// DELETE instead of UPDATE
+		if err := s.stores.Notifications.Dismiss(session.UserID, senderID); err != nil {
+			http.Error(w, "Deletion failed", http.StatusInternalServerError)
+			return
+		}
+	}
 
-	w.WriteHeader(http.StatusOK)
+	jsonResponse(w, map[string]string{"status": "success"})
 }
 
-func GetNotifications(w http.ResponseWriter, r *http.Request) {
-	nickname := r.URL.Query().Get("nickname")
-
-	// ONLY return truly unread notifications
-	rows, err := database.DB.Query(`
-		SELECT n.id, u.nickname as sender
-		FROM notifications n
-		JOIN users u ON n.sender_id = u.id
-		WHERE n.user_id = (SELECT id FROM users WHERE nickname = ?)
-		AND n.is_read = FALSE  // Critical: Only unread!
-		ORDER BY n.created_at DESC`,
-		nickname)
-	if err != nil {
-		log.Println("Error getting unread notifications users:", err)
+func (s *Server) GetNotifications(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.requireSession(w, r)
+	if !ok {
 		return
 	}
-	defer rows.Close()
 
-	notifications, err := fetchUnreadNotifications(nickname) // Use your existing function
+	if actingAsOtherUser(r.URL.Query().Get("nickname"), session.Nickname) {
+		http.Error(w, "Forbidden: cannot read another user's notifications", http.StatusForbidden)
+		return
+	}
+
+	notifications, err := s.fetchUnreadNotifications(session.UserID)
 	if err != nil {
+		log.Println("Error getting unread notifications:", err)
 		http.Error(w, "Failed to fetch notifications", http.StatusInternalServerError)
 		return
 	}
+	if notifications == nil {
+		notifications = []map[string]interface{}{}
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(notifications)
+	jsonResponse(w, notifications)
 }
 
-// Get users who have conversations with the given user
-func getUsersWithConversations(userID int) ([]string, error) {
-	query := `
-        SELECT DISTINCT u.nickname 
-        FROM users u
-        JOIN chats c ON u.id = c.sender_id OR u.id = c.receiver_id
-        WHERE (c.sender_id = ? OR c.receiver_id = ?) AND u.id != ?
-    `
-	rows, err := database.DB.Query(query, userID, userID, userID)
+func (s *Server) createClient(conn *websocket.Conn, nickname, sessionHash string) (*Client, error) {
+	user, err := s.stores.Users.ByNickname(nickname)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var nicknames []string
-	for rows.Next() {
-		var nickname string
-		if err := rows.Scan(&nickname); err != nil {
-			return nil, err
-		}
-		nicknames = append(nicknames, nickname)
-	}
-	return nicknames, nil
+	return &Client{conn, user.ID, user.FirstName, user.LastName, nickname, sessionHash, user.EmailVerified}, nil
 }
 
-// Get users who don't have conversations with the given user
-func getUsersWithoutConversations(userID int) ([]string, error) {
-	query := `
-        SELECT u.nickname 
-        FROM users u
-        WHERE u.id != ? AND u.id NOT IN (
-            SELECT DISTINCT CASE 
-                WHEN c.sender_id = ? THEN c.receiver_id 
-                ELSE c.sender_id 
-            END
-            FROM chats c
-            WHERE c.sender_id = ? OR c.receiver_id = ?
-        )
-    `
-	rows, err := database.DB.Query(query, userID, userID, userID, userID)
+// canChat reports whether the client's account may send messages. Unverified
+// clients are re-checked so confirming the email takes effect without reconnecting.
+func (s *Server) canChat(c *Client) bool {
+	if c.emailVerified {
+		return true
+	}
+	verified, err := s.isEmailVerified(c.nickname)
 	if err != nil {
-		return nil, err
+		log.Println("Error checking email verification:", err)
+		return false
 	}
-	defer rows.Close()
+	c.emailVerified = verified
+	return verified
+}
 
-	var nicknames []string
-	for rows.Next() {
-		var nickname string
-		if err := rows.Scan(&nickname); err != nil {
-			return nil, err
-		}
-		nicknames = append(nicknames, nickname)
+// cleanupClient forgets a closed connection and tells everyone who is still online
+func (s *Server) cleanupClient(conn *websocket.Conn, userID int) {
+	s.hub.mu.Lock()
+	delete(s.hub.clients, conn)
+	s.hub.mu.Unlock()
+
+	if err := s.stores.Chats.SetOnline(userID, false); err != nil {
+		log.Println("Error updating user status to offline:", err)
 	}
-	return nicknames, nil
+	s.broadcastOnlineUsers()
 }
 
-func updateUserStatus(nickname string, online bool) error {
-	_, err := database.DB.Exec(`
-		INSERT OR REPLACE INTO user_status (user_id, is_online, last_seen)
-		SELECT id, ?, CURRENT_TIMESTAMP FROM users WHERE nickname = ?`,
-		online, nickname)
-	return err
+// disconnectUser closes every WebSocket connection the user has open,
+// e.g. after their sessions have been revoked
+func (s *Server) disconnectUser(nickname, reason string) {
+	s.disconnectClients(reason, func(client *Client) bool {
+		return client.nickname == nickname
+	})
 }
 
-func createClient(conn *websocket.Conn, nickname string) (*Client, error) {
-	var firstName, lastName string
-	err := database.DB.QueryRow(
-		"SELECT first_name, last_name FROM users WHERE nickname = ?", nickname,
-	).Scan(&firstName, &lastName)
-	if err != nil {
-		return nil, err
-	}
-	return &Client{conn, firstName, lastName, nickname}, nil
+// disconnectOtherSessions closes a user's connections except those opened by the session keepHash
+func (s *Server) disconnectOtherSessions(nickname, keepHash, reason string) {
+	s.disconnectClients(reason, func(client *Client) bool {
+		return client.nickname == nickname && client.sessionHash != keepHash
+	})
 }
 
-func cleanupClient(conn *websocket.Conn, nickname string) {
-	mu.Lock()
-	delete(clients, conn)
-	mu.Unlock()
+// disconnectSession closes the connections opened by the session stored under tokenHash
+func (s *Server) disconnectSession(tokenHash, reason string) {
+	s.disconnectClients(reason, func(client *Client) bool {
+		return client.sessionHash == tokenHash
+	})
+}
 
-	if err := updateUserStatus(nickname, false); err != nil {
-		log.Println("Error updating user status to offline:", err)
+// disconnectClients closes every connection whose client matches, telling it why
+func (s *Server) disconnectClients(reason string, match func(*Client) bool) {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	for conn, client := range s.hub.clients {
+		if !match(client) {
+			continue
+		}
+		closeMsg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+		if err := conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second)); err != nil {
+			log.Printf("Error sending close message to %s: %v", client.nickname, err)
+		}
+		conn.Close()
+		delete(s.hub.clients, conn)
 	}
-	broadcastOnlineUsers()
 }
 
-func GetAllUsersHandler(w http.ResponseWriter, r *http.Request) {
-	currentUser := r.URL.Query().Get("nickname")
-	if currentUser == "" {
-		http.Error(w, "Missing nickname", http.StatusBadRequest)
+func (s *Server) GetAllUsersHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	if actingAsOtherUser(r.URL.Query().Get("nickname"), session.Nickname) {
+		http.Error(w, "Forbidden: nickname does not match the logged-in user", http.StatusForbidden)
 		return
 	}
 
-	users, err := queryUsers(currentUser)
+	users, err := s.stores.Chats.Contacts(session.UserID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -408,51 +417,25 @@ func GetAllUsersHandler(w http.ResponseWriter, r *http.Request) {
 	jsonResponse(w, users)
 }
 
-func queryUsers(currentUser string) ([]User, error) {
-	rows, err := database.DB.Query(`
-		SELECT u.id, u.nickname, u.first_name, u.last_name, 
-			CASE WHEN s.is_online THEN 1 ELSE 0 END as is_online, s.last_seen
-		FROM users u LEFT JOIN user_status s ON u.id = s.user_id
-		WHERE u.nickname != ? ORDER BY CASE WHEN s.is_online THEN 0 ELSE 1 END, u.first_name, u.last_name`,
-		currentUser)
+func (s *Server) saveMessage(senderID int, receiver, content string) {
+	receiverID, err := s.stores.Users.IDByNickname(receiver)
 	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var users []User
-	for rows.Next() {
-		var user User
-		var lastSeen sql.NullString
-		if err := rows.Scan(&user.ID, &user.Nickname, &user.FirstName, &user.LastName, &user.IsOnline, &lastSeen); err != nil {
-			return nil, err
-		}
-		if lastSeen.Valid {
-			user.LastSeen = lastSeen.String
-		}
-		users = append(users, user)
-	}
-	return users, nil
-}
-
-func saveMessage(sender, receiver, content string) {
-	var senderID, receiverID int
-	if err := database.DB.QueryRow("SELECT id FROM users WHERE nickname = ?", sender).Scan(&senderID); err != nil {
-		log.Println("Error getting sender ID:", err)
-		return
-	}
-	if err := database.DB.QueryRow("SELECT id FROM users WHERE nickname = ?", receiver).Scan(&receiverID); err != nil {
 		log.Println("Error getting receiver ID:", err)
 		return
 	}
-	if _, err := database.DB.Exec("INSERT INTO chats (sender_id, receiver_id, message) VALUES (?, ?, ?)", senderID, receiverID, content); err != nil {
+	if err := s.stores.Chats.Save(senderID, receiverID, content); err != nil {
 		log.Println("Error saving message:", err)
 	}
 }
 
-func broadcastOnlineUsers() {
-	userList := make([]map[string]string, 0, len(clients))
-	for _, client := range clients {
+// broadcastOnlineUsers sends every client the list of connected users. It takes
+// hub.mu itself, so callers must not hold it.
+func (s *Server) broadcastOnlineUsers() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	userList := make([]map[string]string, 0, len(s.hub.clients))
+	for _, client := range s.hub.clients {
 		userList = append(userList, map[string]string{
 			"nickname":  client.nickname,
 			"firstName": client.firstName,
@@ -465,146 +448,137 @@ func broadcastOnlineUsers() {
 		"users": userList,
 	}
 
-	for _, client := range clients {
+	var failed []*websocket.Conn
+	for conn, client := range s.hub.clients {
 		if err := client.conn.WriteJSON(message); err != nil {
 			log.Println("Error sending user list:", err)
-			client.conn.Close()
-			mu.Lock()
-			delete(clients, client.conn)
-			mu.Unlock()
+			failed = append(failed, conn)
 		}
 	}
+	for _, conn := range failed {
+		conn.Close()
+		delete(s.hub.clients, conn)
+	}
 }
 
-func sendPrivateMessage(msg Message) {
-	mu.Lock()
-	defer mu.Unlock()
+func (s *Server) sendPrivateMessage(msg models.Message) {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
 
-	for _, client := range clients {
+	var senderID int
+	for _, client := range s.hub.clients {
 		if client.nickname == msg.Sender {
+			senderID = client.userID
 			msg.SenderFirstName = client.firstName
 			msg.SenderLastName = client.lastName
 			break
 		}
 	}
 
-	_, err := database.DB.Exec(`
-		INSERT INTO notifications (user_id, sender_id) 
-		VALUES (
-			(SELECT id FROM users WHERE nickname = ?), 
-			(SELECT id FROM users WHERE nickname = ?)
-		)`,
-		msg.Receiver, msg.Sender)
+	receiverID, err := s.stores.Users.IDByNickname(msg.Receiver)
+	if err == nil {
+		err = s.stores.Notifications.Add(receiverID, senderID)
+	}
 	if err != nil {
 		log.Println("failed to store notification: ", err)
 		return
 	}
 
-	for _, client := range clients {
+	for _, client := range s.hub.clients {
 		if client.nickname == msg.Receiver {
 			if err := client.conn.WriteJSON(msg); err != nil {
 				log.Println("Error sending private message:", err)
 			} else {
-				sendNotification(msg.Receiver, msg.Sender)
+				s.sendNotification(client, msg.Sender, senderID)
 			}
 			break
 		}
 	}
 }
 
-func sendNotification(receiver, sender string) {
-	for _, client := range clients {
-		if client.nickname == receiver {
-			if err := client.conn.WriteJSON(map[string]string{
-				"type":   "notification",
-				"sender": sender,
-			}); err != nil {
-				log.Println("Error sending notification:", err)
-			} else {
-				// Mark as read if successfully delivered
-				database.DB.Exec(`
-                    UPDATE notifications 
-                    SET is_read = true 
-                    WHERE user_id = (SELECT id FROM users WHERE nickname = ?)
-                    AND sender_id = (SELECT id FROM users WHERE nickname = ?)`,
-					receiver, sender)
-			}
-			break
-		}
+func (s *Server) sendNotification(receiver *Client, sender string, senderID int) {
+	if err := receiver.conn.WriteJSON(map[string]string{
+		"type":   "notification",
+		"sender": sender,
+	}); err != nil {
+		log.Println("Error sending notification:", err)
+		return
+	}
+	// Mark as read if successfully delivered
+	if err := s.stores.Notifications.MarkRead(receiver.userID, senderID); err != nil {
+		log.Println("Error marking notification read:", err)
 	}
 }
 
-func HandleMessages() {
-	for msg := range messages {
-		mu.Lock()
-		for _, client := range clients {
+// handleMessages delivers broadcast messages until the server is closed
+func (s *Server) handleMessages() {
+	for {
+		var msg models.Message
+		select {
+		case <-s.done:
+			return
+		case msg = <-s.hub.messages:
+		}
+
+		s.hub.mu.Lock()
+		for _, client := range s.hub.clients {
 			if msg.Receiver == "" || client.nickname == msg.Receiver {
 				if err := client.conn.WriteJSON(msg); err != nil {
 					log.Println("Error sending message:", err)
 					client.conn.Close()
-					delete(clients, client.conn)
+					delete(s.hub.clients, client.conn)
 				}
 			}
 		}
-		mu.Unlock()
+		s.hub.mu.Unlock()
 	}
 }
 
-func FetchMessagesHandler(w http.ResponseWriter, r *http.Request) {
-	currentUser := r.URL.Query().Get("nickname")
-	otherUser := r.URL.Query().Get("otherUser")
-	offset := r.URL.Query().Get("offset")
-	limit := r.URL.Query().Get("limit")
+func (s *Server) FetchMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser, ok := s.requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Only conversations the caller takes part in can be read
+	if actingAsOtherUser(r.URL.Query().Get("nickname"), currentUser) {
+		http.Error(w, "Forbidden: cannot read another user's conversations", http.StatusForbidden)
+		return
+	}
 
-	if currentUser == "" || otherUser == "" {
-		http.Error(w, "Missing nickname or otherUser", http.StatusBadRequest)
+	otherUser := r.URL.Query().Get("otherUser")
+	if otherUser == "" {
+		http.Error(w, "Missing otherUser", http.StatusBadRequest)
 		return
 	}
 
-	// Set default values if not provided
-	if offset == "" {
-		offset = "0"
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		http.Error(w, "Invalid offset", http.StatusBadRequest)
+		return
 	}
-	if limit == "" {
-		limit = "10"
+	limit, err := queryInt(r, "limit", 10)
+	if err != nil || limit < 1 || limit > 100 {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
 	}
 
-	messages, err := queryMessages(currentUser, otherUser, offset, limit)
+	conversation, err := s.stores.Chats.Conversation(currentUser, otherUser, offset, limit)
 	if err != nil {
 		http.Error(w, "Failed to fetch messages: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	jsonResponse(w, messages)
+	jsonResponse(w, conversation)
 }
 
-func queryMessages(currentUser, otherUser, offset, limit string) ([]Message, error) {
-	rows, err := database.DB.Query(`
-		SELECT u_sender.nickname, u_receiver.nickname, chats.message, chats.sent_at, 
-			u_sender.first_name, u_sender.last_name
-		FROM chats
-		JOIN users u_sender ON chats.sender_id = u_sender.id
-		JOIN users u_receiver ON chats.receiver_id = u_receiver.id
-		WHERE (u_sender.nickname = ? AND u_receiver.nickname = ?) OR 
-			(u_sender.nickname = ? AND u_receiver.nickname = ?)
-		ORDER BY chats.sent_at DESC
-		LIMIT ? OFFSET ?`,
-		currentUser, otherUser, otherUser, currentUser, limit, offset)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var msgs []Message
-	for rows.Next() {
-		var msg Message
-		if err := rows.Scan(&msg.Sender, &msg.Receiver, &msg.Content, &msg.Timestamp, &msg.SenderFirstName, &msg.SenderLastName); err != nil {
-			return nil, err
-		}
-		msgs = append(msgs, msg)
+// queryInt reads an integer query parameter, falling back to def when it is absent
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
 	}
-
-	return msgs, nil
+	return strconv.Atoi(value)
 }
 
 func jsonResponse(w http.ResponseWriter, data interface{}) {
@@ -613,3 +587,12 @@ func jsonResponse(w http.ResponseWriter, data interface{}) {
 		log.Println("Error encoding JSON response:", err)
 	}
 }
+
+// jsonError writes {"error": message} with the given status code
+func jsonError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(map[string]string{"error": message}); err != nil {
+		log.Println("Error encoding JSON response:", err)
+	}
+}
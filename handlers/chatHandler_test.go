@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	t.Parallel()
+	s := &Server{cfg: Config{BaseURL: "https://forum.example"}}
+
+	for _, tc := range []struct {
+		name   string
+		host   string
+		origin string
+		bearer bool
+		want   bool
+	}{
+		{"same host", "127.0.0.1:4422", "http://127.0.0.1:4422", false, true},
+		{"base URL behind a proxy", "127.0.0.1:4422", "https://forum.example", false, true},
+		{"base URL with another scheme", "127.0.0.1:4422", "http://forum.example", false, false},
+		{"other site", "127.0.0.1:4422", "https://evil.example", false, false},
+		{"other port", "127.0.0.1:4422", "http://127.0.0.1:9999", false, false},
+		{"no origin from a browser session", "127.0.0.1:4422", "", false, false},
+		{"no origin from an API token", "127.0.0.1:4422", "", true, true},
+		{"malformed origin", "127.0.0.1:4422", "://", false, false},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		r.Host = tc.host
+		if tc.origin != "" {
+			r.Header.Set("Origin", tc.origin)
+		}
+		if tc.bearer {
+			r = r.WithContext(context.WithValue(r.Context(), apiTokenContextKey{}, &Session{APITokenID: 1}))
+		}
+		if got := s.checkOrigin(r); got != tc.want {
+			t.Errorf("%s: checkOrigin = %t, want %t", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestWebSocketRefusesOtherOrigins(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, nil)
+	c := ts.signUp(t, "alice")
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+	u, _ := url.Parse(ts.URL)
+	dial := func(origin string) int {
+		header := http.Header{}
+		for _, cookie := range c.http.Jar.Cookies(u) {
+			header.Add("Cookie", cookie.String())
+		}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(wsURL, header)
+		if err == nil {
+			conn.Close()
+		}
+		if resp == nil {
+			t.Fatalf("dialling with origin %q: %v", origin, err)
+		}
+		return resp.StatusCode
+	}
+
+	if status := dial(ts.URL); status != http.StatusSwitchingProtocols {
+		t.Errorf("same origin: status %d, want %d", status, http.StatusSwitchingProtocols)
+	}
+	for _, origin := range []string{"https://evil.example", ""} {
+		if status := dial(origin); status != http.StatusForbidden {
+			t.Errorf("origin %q: status %d, want %d", origin, status, http.StatusForbidden)
+		}
+	}
+}
+
+// dialChat opens the client's chat connection from the forum's own origin
+func dialChat(c *testClient) (*websocket.Conn, error) {
+	u, _ := url.Parse(c.server.URL)
+	header := http.Header{"Origin": {c.server.URL}}
+	for _, cookie := range c.http.Jar.Cookies(u) {
+		header.Add("Cookie", cookie.String())
+	}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(c.server.URL, "http")+"/ws", header)
+	return conn, err
+}
+
+// onlineCount returns how many connections the hub is tracking
+func (ts *testServer) onlineCount() int {
+	ts.hub.mu.Lock()
+	defer ts.hub.mu.Unlock()
+	return len(ts.hub.clients)
+}
+
+func TestChatHubUnderConcurrentConnections(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, nil)
+	observer, err := dialChat(ts.signUp(t, "observer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer observer.Close()
+
+	users := make([]*testClient, 6)
+	for i := range users {
+		users[i] = ts.signUp(t, fmt.Sprintf("user%d", i))
+	}
+
+	// Everyone connects, chats and hangs up a few times at once, so joins, leaves,
+	// roster broadcasts and messages all overlap
+	var wg sync.WaitGroup
+	for _, user := range users {
+		wg.Add(1)
+		go func(user *testClient) {
+			defer wg.Done()
+			for round := 0; round < 3; round++ {
+				conn, err := dialChat(user)
+				if err != nil {
+					t.Errorf("dialling: %v", err)
+					return
+				}
+				conn.WriteJSON(map[string]string{"type": "typing", "receiver": "observer"})
+				conn.WriteJSON(map[string]string{"receiver": "observer", "content": "hello"})
+				conn.SetReadDeadline(time.Now().Add(time.Second))
+				conn.ReadMessage()
+				conn.Close()
+			}
+		}(user)
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for ts.onlineCount() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("hub still tracks %d connections, want only the observer", ts.onlineCount())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The observer kept its connection through all of it and hears the final roster
+	observer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var msg struct {
+			Type  string              `json:"type"`
+			Users []map[string]string `json:"users"`
+		}
+		if err := observer.ReadJSON(&msg); err != nil {
+			t.Fatalf("observer lost its connection: %v", err)
+		}
+		if msg.Type == "onlineUsers" && len(msg.Users) == 1 && msg.Users[0]["nickname"] == "observer" {
+			break
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import "time"
+
+// Config gathers the handler settings that can be changed at startup
+type Config struct {
+	// BaseURL is the public URL of the forum, used in emailed links
+	BaseURL string
+	// PagesDir is the directory holding the HTML templates
+	PagesDir string
+	// StaticDir is the directory served under /static/
+	StaticDir string
+	// SecureCookies only sends session cookies over HTTPS
+	SecureCookies bool
+	// OIDCProviderName is shown on the single sign-on button
+	OIDCProviderName string
+	// OIDCLoginTTL is how long a user has to finish logging in at the provider
+	OIDCLoginTTL time.Duration
+	// EmailVerificationTTL is how long an email verification link stays valid
+	EmailVerificationTTL time.Duration
+	// PasswordResetTTL is how long a password reset link stays valid
+	PasswordResetTTL time.Duration
+
+	Posts         PostConfig
+	Sessions      SessionConfig
+	LoginThrottle LoginThrottleConfig
+	Passwords     PasswordConfig
+	Demographics  DemographicsConfig
+	Registration  RegistrationConfig
+	TwoFactor     TwoFactorConfig
+	APITokens     APITokenConfig
+}
+
+// DefaultConfig returns the settings the handlers use unless configured otherwise
+func DefaultConfig() Config {
+	return Config{
+		BaseURL:              "http://localhost:4422",
+		PagesDir:             "./pages",
+		StaticDir:            "./static",
+		SecureCookies:        false,
+		OIDCProviderName:     "SSO",
+		OIDCLoginTTL:         defaultOIDCLoginTTL,
+		EmailVerificationTTL: defaultEmailVerificationTTL,
+		PasswordResetTTL:     defaultPasswordResetTTL,
+		Posts:                defaultPosts,
+		Sessions:             defaultSessions,
+		LoginThrottle:        defaultLoginThrottle,
+		Passwords:            defaultPasswordRules,
+		Demographics:         defaultDemographics,
+		Registration:         defaultRegistration,
+		TwoFactor:            defaultTwoFactor,
+		APITokens:            defaultAPITokens,
+	}
+}
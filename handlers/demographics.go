@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DemographicsConfig controls the personal details asked for at registration
+type DemographicsConfig struct {
+	// GenderOptions are the choices offered for gender, in display order
+	GenderOptions []string
+	// AllowCustomGender lets users describe their gender in their own words
+	AllowCustomGender bool
+	// MaxCustomGender caps the length of a self-described gender
+	MaxCustomGender int
+	// RequireAge makes the age field mandatory
+	RequireAge bool
+	// MinAge and MaxAge bound the accepted age
+	MinAge, MaxAge int
+}
+
+// defaultDemographics asks for an optional age and offers a few gender options
+var defaultDemographics = DemographicsConfig{
+	GenderOptions:     []string{"Female", "Male", "Non-binary", "Prefer not to say"},
+	AllowCustomGender: true,
+	MaxCustomGender:   50,
+	RequireAge:        false,
+	MinAge:            0,
+	MaxAge:            150,
+}
+
+// customGenderOption is the form value of the "describe it yourself" choice;
+// the text itself is sent in the gender_custom field
+const customGenderOption = "custom"
+
+// genderFromForm returns the gender the user picked or typed
+func genderFromForm(r *http.Request) string {
+	gender := r.FormValue("gender")
+	if gender == customGenderOption {
+		return strings.TrimSpace(r.FormValue("gender_custom"))
+	}
+	return gender
+}
+
+// parseAge reads the optional age field; a blank value means no age was given
+func parseAge(value string) (*int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	age, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, err
+	}
+	return &age, nil
+}
+
+func (s *Server) isGenderOption(gender string) bool {
+	for _, option := range s.cfg.Demographics.GenderOptions {
+		if gender == option {
+			return true
+		}
+	}
+	return false
+}
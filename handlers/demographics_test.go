@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestValidateProfileDemographics(t *testing.T) {
+	t.Parallel()
+	age := func(n int) *int { return &n }
+	strict := defaultDemographics
+	strict.RequireAge = true
+	strict.AllowCustomGender = false
+	strict.MinAge, strict.MaxAge = 13, 120
+
+	for _, tc := range []struct {
+		name    string
+		config  DemographicsConfig
+		age     *int
+		gender  string
+		wantErr map[string]string
+	}{
+		{"no age when optional", defaultDemographics, nil, "Female", nil},
+		{"no age when required", strict, nil, "Female", map[string]string{"age": "Age cannot be empty"}},
+		{"lowest age", strict, age(13), "Male", nil},
+		{"below the lowest age", strict, age(12), "Male", map[string]string{"age": "Age must be between 13 and 120"}},
+		{"highest age", strict, age(120), "Male", nil},
+		{"above the highest age", strict, age(121), "Male", map[string]string{"age": "Age must be between 13 and 120"}},
+		{"negative age", defaultDemographics, age(-1), "Male", map[string]string{"age": "Age must be between 0 and 150"}},
+		{"listed gender", strict, age(30), "Non-binary", nil},
+		{"unlisted gender when custom is off", strict, age(30), "Agender", map[string]string{"gender": "Please choose one of the listed options"}},
+		{"custom gender", defaultDemographics, nil, "Agender", nil},
+		{"no gender", defaultDemographics, nil, "", map[string]string{"gender": "Please choose an option or describe your gender"}},
+		{"custom gender at the limit", defaultDemographics, nil, strings.Repeat("a", 50), nil},
+		{"custom gender over the limit", defaultDemographics, nil, strings.Repeat("a", 51), map[string]string{"gender": "Gender cannot be longer than 50 characters"}},
+		// The limit counts characters, not bytes
+		{"multibyte custom gender at the limit", defaultDemographics, nil, strings.Repeat("é", 50), nil},
+		{"listed gender longer than the limit", DemographicsConfig{GenderOptions: []string{"Prefer not to say"}, MaxCustomGender: 5, MaxAge: 150}, nil, "Prefer not to say", nil},
+	} {
+		s := &Server{cfg: Config{Demographics: tc.config}}
+		errors := s.validateProfile("Test", "User", tc.age, tc.gender)
+		if len(errors) != len(tc.wantErr) {
+			t.Errorf("%s: errors = %v, want %v", tc.name, errors, tc.wantErr)
+			continue
+		}
+		for field, want := range tc.wantErr {
+			if errors[field] != want {
+				t.Errorf("%s: %s error = %q, want %q", tc.name, field, errors[field], want)
+			}
+		}
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		value   string
+		want    int
+		none    bool
+		invalid bool
+	}{
+		{value: "", none: true},
+		{value: "   ", none: true},
+		{value: "42", want: 42},
+		{value: " 7 ", want: 7},
+		{value: "-3", want: -3},
+		{value: "forty", invalid: true},
+		{value: "4.5", invalid: true},
+	} {
+		age, err := parseAge(tc.value)
+		switch {
+		case tc.invalid:
+			if err == nil {
+				t.Errorf("parseAge(%q) = %v, want an error", tc.value, age)
+			}
+		case err != nil:
+			t.Errorf("parseAge(%q): %v", tc.value, err)
+		case tc.none:
+			if age != nil {
+				t.Errorf("parseAge(%q) = %d, want no age", tc.value, *age)
+			}
+		case age == nil || *age != tc.want:
+			t.Errorf("parseAge(%q) = %v, want %d", tc.value, age, tc.want)
+		}
+	}
+}
+
+func TestGenderFromForm(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		form url.Values
+		want string
+	}{
+		{url.Values{"gender": {"Female"}}, "Female"},
+		{url.Values{"gender": {"Female"}, "gender_custom": {"ignored"}}, "Female"},
+		{url.Values{"gender": {customGenderOption}, "gender_custom": {"  Genderfluid "}}, "Genderfluid"},
+		{url.Values{"gender": {customGenderOption}}, ""},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/profile", strings.NewReader(tc.form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if got := genderFromForm(r); got != tc.want {
+			t.Errorf("genderFromForm(%v) = %q, want %q", tc.form, got, tc.want)
+		}
+	}
+}
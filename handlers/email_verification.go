@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultEmailVerificationTTL is how long an email verification link stays valid unless configured otherwise
+const defaultEmailVerificationTTL = 24 * time.Hour
+
+var errInvalidVerificationToken = errors.New("invalid or expired verification link")
+
+// signEmailVerification builds a token binding the user to the address being verified.
+// Changing the email therefore invalidates links sent to the previous address.
+func (s *Server) signEmailVerification(userID int, email string, expires time.Time) string {
+	payload := fmt.Sprintf("%d|%d|%s", userID, expires.Unix(), email)
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.emailVerificationMAC(encoded))
+}
+
+func (s *Server) emailVerificationMAC(encodedPayload string) []byte {
+	mac := hmac.New(sha256.New, s.signingKey)
+	mac.Write([]byte("email-verification|" + encodedPayload))
+	return mac.Sum(nil)
+}
+
+// parseEmailVerification checks the signature and expiry of a token and returns what it vouches for
+func (s *Server) parseEmailVerification(token string) (int, string, error) {
+	encoded, sig, found := strings.Cut(token, ".")
+	if !found {
+		return 0, "", errInvalidVerificationToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, s.emailVerificationMAC(encoded)) {
+		return 0, "", errInvalidVerificationToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, "", errInvalidVerificationToken
+	}
+	parts := strings.SplitN(string(payload), "|", 3)
+	if len(parts) != 3 {
+		return 0, "", errInvalidVerificationToken
+	}
+	userID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, "", errInvalidVerificationToken
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() >= expires {
+		return 0, "", errInvalidVerificationToken
+	}
+	return userID, parts[2], nil
+}
+
+// sendVerificationEmail emails a signed confirmation link to the address
+func (s *Server) sendVerificationEmail(userID int, nickname, email string) error {
+	token := s.signEmailVerification(userID, email, time.Now().Add(s.cfg.EmailVerificationTTL))
+	link := s.absoluteURL("/verify_email?token=" + url.QueryEscape(token))
+	body := fmt.Sprintf(
+		"Hi %s,\n\nPlease confirm your email address by opening the link below within %d hours:\n\n%s\n\n"+
+			"Until then you can read the forum, but not post or chat.\n",
+		nickname, int(s.cfg.EmailVerificationTTL.Hours()), link,
+	)
+	return s.sendMail(email, "Confirm your forum email address", body)
+}
+
+// isEmailVerified reports whether the user has confirmed their email address
+func (s *Server) isEmailVerified(nickname string) (bool, error) {
+	user, err := s.stores.Users.ByNickname(nickname)
+	if err != nil {
+		return false, err
+	}
+	return user.EmailVerified, nil
+}
+
+// requireVerifiedEmail writes a 403 response and returns false if the user has not
+// confirmed their email address yet
+func (s *Server) requireVerifiedEmail(w http.ResponseWriter, nickname string) bool {
+	verified, err := s.isEmailVerified(nickname)
+	if err != nil {
+		log.Printf("Error checking email verification: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return false
+	}
+	if !verified {
+		jsonError(w, http.StatusForbidden, "Please verify your email address first. Check your inbox for the confirmation link.")
+		return false
+	}
+	return true
+}
+
+// VerifyEmailHandler confirms the address named in a signed link
+func (s *Server) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, email, err := s.parseEmailVerification(r.URL.Query().Get("token"))
+	if err != nil {
+		http.Error(w, "This verification link is invalid or has expired.", http.StatusBadRequest)
+		return
+	}
+
+	verified, err := s.stores.Users.VerifyEmail(userID, email)
+	if err != nil {
+		log.Printf("Error verifying email: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !verified {
+		http.Error(w, "This verification link is no longer valid for your account.", http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "/?email_verified=1", http.StatusSeeOther)
+}
+
+// ResendVerificationHandler sends a fresh confirmation link to the logged-in user
+func (s *Server) ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := s.requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := s.stores.Users.ByID(session.UserID)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	email := user.Email
+	if user.EmailVerified {
+		jsonError(w, http.StatusBadRequest, "Your email address is already verified")
+		return
+	}
+
+	if err := s.sendVerificationEmail(session.UserID, session.Nickname, email); err != nil {
+		log.Printf("Error sending verification email: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Failed to send verification email")
+		return
+	}
+
+	jsonResponse(w, map[string]string{"message": "A new confirmation link has been sent to " + email})
+}
+
+// ChangeEmailHandler replaces the logged-in user's address and asks them to confirm the new one.
+// The current password must be supplied as current_password.
+func (s *Server) ChangeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := s.requireSession(w, r)
+	if !ok || !s.requireFreshSecondFactor(w, session) || !s.requireCurrentPassword(w, r, session) {
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.FormValue("email")))
+	if msg := validateEmail(email); msg != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  "Validation error",
+			"fields": map[string]string{"email": msg},
+		})
+		return
+	}
+
+	existing, err := s.stores.Users.ByEmail(email)
+	if err == nil {
+		if existing.ID == session.UserID {
+			jsonError(w, http.StatusBadRequest, "This is already your email address")
+		} else {
+			jsonError(w, http.StatusConflict, "Email already exists")
+		}
+		return
+	} else if err != sql.ErrNoRows {
+		log.Printf("Database error: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if err := s.stores.Users.SetEmail(session.UserID, email); err != nil {
+		log.Printf("Error updating email: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Failed to update email")
+		return
+	}
+
+	if err := s.sendVerificationEmail(session.UserID, session.Nickname, email); err != nil {
+		log.Printf("Error sending verification email: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Email updated, but the confirmation link could not be sent")
+		return
+	}
+
+	jsonResponse(w, map[string]string{"message": "Email updated. Please confirm it using the link sent to " + email})
+}
@@ -2,17 +2,14 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
 	"strconv"
-
-	"forum/database"
 )
 
-func HandleInteract(w http.ResponseWriter, r *http.Request) {
-	_, sessionToken, loggedIn, _ := RequireLogin(w, r)
-	if !loggedIn {
+func (s *Server) HandleInteract(w http.ResponseWriter, r *http.Request) {
+	session, _ := s.currentSession(w, r)
+	if session == nil {
 		http.Error(w, "Unauthorized: User is not logged in", http.StatusUnauthorized)
 		return
 	}
@@ -36,19 +33,11 @@ func HandleInteract(w http.ResponseWriter, r *http.Request) {
 		isLike = &parsedIsLike
 	}
 
-	var userID int
-	err := database.DB.QueryRow("SELECT id FROM users WHERE session_token = ?", sessionToken).Scan(&userID)
-	if err != nil {
-		log.Printf("Error fetching user ID: %v", err)
-		http.Error(w, "Unauthorized: Invalid session", http.StatusUnauthorized)
-		return
-	}
-
 	var response map[string]interface{}
 	if postIDStr != "" {
-		response = handlePostLike(userID, postIDStr, isLike)
+		response = s.handlePostLike(session.UserID, postIDStr, isLike)
 	} else if commentIDStr != "" {
-		response = handleCommentLike(userID, commentIDStr, isLike)
+		response = s.handleCommentLike(session.UserID, commentIDStr, isLike)
 	} else {
 		http.Error(w, "Either post_id or comment_id must be specified", http.StatusBadRequest)
 		return
@@ -57,7 +46,7 @@ func HandleInteract(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func handlePostLike(userID int, postIDStr string, isLike *bool) map[string]interface{} {
+func (s *Server) handlePostLike(userID int, postIDStr string, isLike *bool) map[string]interface{} {
 	postID, err := strconv.Atoi(postIDStr)
 	if err != nil {
 		log.Printf("Invalid post ID: %v", err)
@@ -66,8 +55,7 @@ func handlePostLike(userID int, postIDStr string, isLike *bool) map[string]inter
 		}
 	}
 	// Check if the post id exists in the comments table
-	var exists bool
-	err = database.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM posts WHERE id = ?)", postID).Scan(&exists)
+	exists, err := s.stores.Posts.Exists(postID)
 	if err != nil {
 		log.Printf("Error checking post existence: %v", err)
 		return map[string]interface{}{
@@ -76,49 +64,33 @@ func handlePostLike(userID int, postIDStr string, isLike *bool) map[string]inter
 	}
 
 	if !exists {
-		fmt.Println("post ID does not exist")
+		log.Printf("Post %d does not exist", postID)
 		return map[string]interface{}{
 			"error": "post ID does not exist",
 		}
 	}
 
-	if isLike == nil {
-		_, err = database.DB.Exec("DELETE FROM post_likes WHERE user_id = ? AND post_id = ?", userID, postID)
-		if err != nil {
-			log.Printf("Error deleting post like: %v", err)
-			return map[string]interface{}{
-				"error": "Invalid comment ID",
-			}
+	if err := s.stores.Posts.React(userID, postID, isLike); err != nil {
+		log.Printf("Error updating post like: %v", err)
+		return map[string]interface{}{
+			"error": "Failed to submit interaction",
 		}
+	}
+	if isLike == nil {
 		return map[string]interface{}{
 			"message":       "Like removed",
 			"updatedIsLike": nil,
 		}
-	} else {
-		query := `
-		            INSERT INTO post_likes (user_id, post_id, is_like, created_at)
-					VALUES (?, ?, ?, CURRENT_TIMESTAMP)
-					ON CONFLICT(user_id, post_id)
-					DO UPDATE SET 
-		    		is_like = excluded.is_like, 
-		    		created_at = CURRENT_TIMESTAMP
-					`
-		_, err = database.DB.Exec(query, userID, postID, isLike)
-		if err != nil {
-			return map[string]interface{}{
-				"error": "Failed to submit interaction",
-			}
-		}
-		log.Println("Interaction added/updated successfully")
+	}
+	log.Println("Interaction added/updated successfully")
 
-		return map[string]interface{}{
-			"message":       "Interaction updated successfully",
-			"updatedIsLike": *isLike,
-		}
+	return map[string]interface{}{
+		"message":       "Interaction updated successfully",
+		"updatedIsLike": *isLike,
 	}
 }
 
-func handleCommentLike(userID int, commentIDStr string, isLike *bool) map[string]interface{} {
+func (s *Server) handleCommentLike(userID int, commentIDStr string, isLike *bool) map[string]interface{} {
 	commentID, err := strconv.Atoi(commentIDStr)
 	if err != nil {
 		log.Printf("Invalid comment ID: %v", err)
@@ -127,8 +99,7 @@ func handleCommentLike(userID int, commentIDStr string, isLike *bool) map[string
 		}
 	}
 	// Check if the comment_id exists in the comments table
-	var exists bool
-	err = database.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM comments WHERE id = ?)", commentID).Scan(&exists)
+	exists, err := s.stores.Comments.Exists(commentID)
 	if err != nil {
 		log.Printf("Error checking comment existence: %v", err)
 		return map[string]interface{}{
@@ -137,47 +108,28 @@ func handleCommentLike(userID int, commentIDStr string, isLike *bool) map[string
 	}
 
 	if !exists {
-		fmt.Println("Comment ID does not exist")
+		log.Printf("Comment %d does not exist", commentID)
 		return map[string]interface{}{
 			"error": "Comment ID does not exist",
 		}
 	}
 
-	if isLike == nil {
-		_, err = database.DB.Exec("DELETE FROM comment_likes WHERE user_id = ? AND comment_id = ?", userID, commentID)
-		if err != nil {
-			log.Printf("Error deleting comment like: %v", err)
-			return map[string]interface{}{
-				"error": "Failed to remove comment like",
-			}
+	if err := s.stores.Comments.React(userID, commentID, isLike); err != nil {
+		log.Printf("Error updating comment like: %v", err)
+		return map[string]interface{}{
+			"error": "Failed to submit interaction",
 		}
+	}
+	if isLike == nil {
 		return map[string]interface{}{
 			"message":       "Like removed",
 			"updatedIsLike": nil,
 		}
-	} else {
-
-		query := `
-            INSERT INTO comment_likes (user_id, comment_id, is_like, created_at)
-			VALUES (?, ?, ?, CURRENT_TIMESTAMP)
-			ON CONFLICT(user_id, comment_id)
-			DO UPDATE SET 
-    		is_like = excluded.is_like, 
-    		created_at = CURRENT_TIMESTAMP
-			`
-		_, err = database.DB.Exec(query, userID, commentID, isLike)
-		if err != nil {
-			log.Printf("Error inserting/updating comment like: %v", err)
-			return map[string]interface{}{
-				"error": "Failed to submit interaction",
-			}
-		}
-		log.Printf("Executing comment like query with userID: %d, commentID: %d, isLike: %v", userID, commentID, isLike)
+	}
 
-		log.Println("Interaction added/updated successfully")
-		return map[string]interface{}{
-			"message":       "Interaction updated successfully",
-			"updatedIsLike": *isLike,
-		}
+	log.Println("Interaction added/updated successfully")
+	return map[string]interface{}{
+		"message":       "Interaction updated successfully",
+		"updatedIsLike": *isLike,
 	}
 }
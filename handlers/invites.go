@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/models"
+)
+
+// RegistrationMode decides who may create an account
+type RegistrationMode string
+
+const (
+	// RegistrationOpen lets anyone register
+	RegistrationOpen RegistrationMode = "open"
+	// RegistrationInvite requires a valid invite code. The first codes, before
+	// anyone can create them from the forum, come from the invite command.
+	RegistrationInvite RegistrationMode = "invite"
+	// RegistrationClosed refuses all new accounts
+	RegistrationClosed RegistrationMode = "closed"
+)
+
+// ParseRegistrationMode validates a mode given on the command line
+func ParseRegistrationMode(value string) (RegistrationMode, error) {
+	switch mode := RegistrationMode(value); mode {
+	case RegistrationOpen, RegistrationInvite, RegistrationClosed:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown registration mode %q (want open, invite or closed)", value)
+}
+
+// RegistrationConfig controls account creation and the invite codes that gate it
+type RegistrationConfig struct {
+	Mode RegistrationMode
+	// DefaultInviteTTL is how long an invite stays valid when its creator does not say
+	DefaultInviteTTL time.Duration
+	// MaxInviteTTL caps the lifetime a creator may choose
+	MaxInviteTTL time.Duration
+	// MaxInviteUses caps how many accounts one invite may create
+	MaxInviteUses int
+}
+
+// defaultRegistration lets anyone sign up
+var defaultRegistration = RegistrationConfig{
+	Mode:             RegistrationOpen,
+	DefaultInviteTTL: 7 * 24 * time.Hour,
+	MaxInviteTTL:     90 * 24 * time.Hour,
+	MaxInviteUses:    100,
+}
+
+// Invite describes an invite code to its creator; the code itself is never stored
+type Invite struct {
+	ID        int64      `json:"id"`
+	MaxUses   int        `json:"max_uses"`
+	Uses      int        `json:"uses"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	Invitees  []string   `json:"invitees"`
+}
+
+// newInviteCode returns a random code formatted like "abcde-fghij-klmno"
+func newInviteCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	raw := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf))[:15]
+	return raw[:5] + "-" + raw[5:10] + "-" + raw[10:], nil
+}
+
+// normalizeInviteCode makes codes match however they were typed or pasted
+func normalizeInviteCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// createInvite stores a new invite and returns it with its code. createdBy is
+// zero for invites minted with the invite command, which belong to nobody.
+func (s *Server) createInvite(createdBy, maxUses int, ttl time.Duration) (Invite, string, error) {
+	code, err := newInviteCode()
+	if err != nil {
+		return Invite{}, "", err
+	}
+
+	now := time.Now().UTC()
+	record := &models.Invite{CreatedBy: createdBy, MaxUses: maxUses, CreatedAt: now, ExpiresAt: now.Add(ttl)}
+	record.ID, err = s.stores.Invites.Create(record, hashToken(normalizeInviteCode(code)))
+	if err != nil {
+		return Invite{}, "", err
+	}
+	return inviteFromRecord(record), code, nil
+}
+
+// inviteFromRecord describes a stored invite to its creator
+func inviteFromRecord(record *models.Invite) Invite {
+	invite := Invite{
+		ID:        record.ID,
+		MaxUses:   record.MaxUses,
+		Uses:      record.Uses,
+		CreatedAt: record.CreatedAt,
+		ExpiresAt: record.ExpiresAt,
+		RevokedAt: record.RevokedAt,
+		Invitees:  record.Invitees,
+	}
+	if invite.Invitees == nil {
+		invite.Invitees = []string{}
+	}
+	return invite
+}
+
+// MintInvite creates an invite for maxUses accounts that belongs to no user. It
+// is how the first people get into an invite-only forum.
+func (s *Server) MintInvite(maxUses int) (Invite, string, error) {
+	if maxUses < 1 || maxUses > s.cfg.Registration.MaxInviteUses {
+		return Invite{}, "", fmt.Errorf("an invite may register between 1 and %d accounts, not %d", s.cfg.Registration.MaxInviteUses, maxUses)
+	}
+	return s.createInvite(0, maxUses, s.cfg.Registration.DefaultInviteTTL)
+}
+
+func (s *Server) listInvites(userID int) ([]Invite, error) {
+	records, err := s.stores.Invites.ListByCreator(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	invites := []Invite{}
+	for i := range records {
+		invites = append(invites, inviteFromRecord(&records[i]))
+	}
+	return invites, nil
+}
+
+// InvitesHandler lists the invites the logged-in user has created and who joined with them
+func (s *Server) InvitesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := s.requireCapability(w, r, CapCreateInvites)
+	if !ok {
+		return
+	}
+
+	invites, err := s.listInvites(session.UserID)
+	if err != nil {
+		log.Printf("Error listing invites: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	jsonResponse(w, map[string]interface{}{
+		"invites":          invites,
+		"registrationMode": s.cfg.Registration.Mode,
+	})
+}
+
+// CreateInviteHandler issues an invite code that may register max_uses accounts
+// within expires_in_hours. The code is shown once in the response; only its hash is kept.
+func (s *Server) CreateInviteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := s.requireCapability(w, r, CapCreateInvites)
+	if !ok {
+		return
+	}
+
+	maxUses := 1
+	if value := r.FormValue("max_uses"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > s.cfg.Registration.MaxInviteUses {
+			jsonError(w, http.StatusBadRequest, fmt.Sprintf("max_uses must be a whole number between 1 and %d", s.cfg.Registration.MaxInviteUses))
+			return
+		}
+		maxUses = parsed
+	}
+
+	ttl := s.cfg.Registration.DefaultInviteTTL
+	if value := r.FormValue("expires_in_hours"); value != "" {
+		hours, err := strconv.Atoi(value)
+		maxHours := int(s.cfg.Registration.MaxInviteTTL / time.Hour)
+		if err != nil || hours < 1 || hours > maxHours {
+			jsonError(w, http.StatusBadRequest, fmt.Sprintf("expires_in_hours must be a whole number between 1 and %d", maxHours))
+			return
+		}
+		ttl = time.Duration(hours) * time.Hour
+	}
+
+	invite, code, err := s.createInvite(session.UserID, maxUses, ttl)
+	if err != nil {
+		log.Printf("Error storing invite: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Failed to create invite")
+		return
+	}
+	log.Printf("%s created invite %d for %d accounts", session.Nickname, invite.ID, maxUses)
+
+	jsonResponse(w, map[string]interface{}{
+		"message":    "Invite created. Copy the code or link now; it will not be shown again.",
+		"id":         invite.ID,
+		"code":       code,
+		"link":       s.absoluteURL("/?invite=" + code),
+		"max_uses":   maxUses,
+		"expires_at": invite.ExpiresAt,
+	})
+}
+
+// RevokeInviteHandler stops one of the logged-in user's invites from registering more accounts
+func (s *Server) RevokeInviteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := s.requireCapability(w, r, CapCreateInvites)
+	if !ok {
+		return
+	}
+
+	inviteID, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, "Invalid invite ID")
+		return
+	}
+
+	revoked, err := s.stores.Invites.Revoke(inviteID, session.UserID, time.Now().UTC())
+	if err != nil {
+		log.Printf("Error revoking invite: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Failed to revoke invite")
+		return
+	}
+	if !revoked {
+		jsonError(w, http.StatusNotFound, "Invite not found")
+		return
+	}
+
+	jsonResponse(w, map[string]string{"message": "Invite revoked"})
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMintedInviteAdmitsFirstUsers(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, func(cfg *Config) { cfg.Registration.Mode = RegistrationInvite })
+	c := ts.client(t)
+
+	expectStatus(t, c.post("/register", registrationForm("alice")), http.StatusForbidden)
+
+	_, code, err := ts.MintInvite(1)
+	if err != nil {
+		t.Fatalf("MintInvite: %v", err)
+	}
+	form := registrationForm("alice")
+	form.Set("invite_code", code)
+	expectStatus(t, c.post("/register", form), http.StatusOK)
+
+	// The invite was for one account only
+	form = registrationForm("bob")
+	form.Set("invite_code", code)
+	expectStatus(t, c.post("/register", form), http.StatusForbidden)
+	if _, err := ts.stores.Users.ByNickname("bob"); err == nil {
+		t.Error("a used-up invite still created an account")
+	}
+}
+
+func TestMintInviteChecksUses(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, nil)
+	for _, uses := range []int{0, ts.cfg.Registration.MaxInviteUses + 1} {
+		if _, _, err := ts.MintInvite(uses); err == nil {
+			t.Errorf("MintInvite(%d) succeeded", uses)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoginThrottleConfig controls how failed logins slow down and lock out further attempts.
+// Failures are counted separately per identifier (nickname/email) and per client IP.
+type LoginThrottleConfig struct {
+	// FreeAttempts is how many failures an identifier may have before backoff starts
+	FreeAttempts int
+	// IPFreeAttempts is the same allowance for a client IP, which may front many users
+	IPFreeAttempts int
+	// BaseDelay is the first backoff; it doubles with every further failure
+	BaseDelay time.Duration
+	// MaxDelay caps the exponential backoff
+	MaxDelay time.Duration
+	// LockoutThreshold is the failure count at which an identifier is locked out
+	LockoutThreshold int
+	// IPLockoutThreshold is the failure count at which a client IP is locked out
+	IPLockoutThreshold int
+	// LockoutDuration is how long a lockout lasts
+	LockoutDuration time.Duration
+	// ResetAfter forgets failures once no attempt has failed for this long
+	ResetAfter time.Duration
+}
+
+// defaultLoginThrottle are the throttling settings used unless configured otherwise
+var defaultLoginThrottle = LoginThrottleConfig{
+	FreeAttempts:       3,
+	IPFreeAttempts:     20,
+	BaseDelay:          1 * time.Second,
+	MaxDelay:           5 * time.Minute,
+	LockoutThreshold:   10,
+	IPLockoutThreshold: 50,
+	LockoutDuration:    15 * time.Minute,
+	ResetAfter:         24 * time.Hour,
+}
+
+func identifierThrottleKey(identifier string) string {
+	return "identifier:" + strings.ToLower(identifier)
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginRetryAfter returns how long the caller must wait before trying again,
+// or zero when none of the keys is currently throttled
+func (s *Server) loginRetryAfter(keys ...string) (time.Duration, error) {
+	now := time.Now().UTC()
+	var wait time.Duration
+	for _, key := range keys {
+		lockedUntil, err := s.stores.LoginAttempts.LockedUntil(key)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		if lockedUntil.After(now) && lockedUntil.Sub(now) > wait {
+			wait = lockedUntil.Sub(now)
+		}
+	}
+	return wait, nil
+}
+
+// recordLoginFailure counts a failed attempt against the key and locks it
+// once it has used up its free attempts
+func (s *Server) recordLoginFailure(key string, freeAttempts, lockoutThreshold int) error {
+	now := time.Now().UTC()
+
+	failures, err := s.stores.LoginAttempts.RecordFailure(key, now, now.Add(-s.cfg.LoginThrottle.ResetAfter))
+	if err != nil {
+		return err
+	}
+
+	delay := s.loginBackoff(failures, freeAttempts, lockoutThreshold)
+	if delay == 0 {
+		return nil
+	}
+
+	return s.stores.LoginAttempts.Lock(key, now.Add(delay))
+}
+
+// loginBackoff computes the delay imposed after the given number of failures
+func (s *Server) loginBackoff(failures, freeAttempts, lockoutThreshold int) time.Duration {
+	if failures >= lockoutThreshold {
+		return s.cfg.LoginThrottle.LockoutDuration
+	}
+	if failures < freeAttempts {
+		return 0
+	}
+
+	delay := float64(s.cfg.LoginThrottle.BaseDelay) * math.Pow(2, float64(failures-freeAttempts))
+	if delay > float64(s.cfg.LoginThrottle.MaxDelay) {
+		return s.cfg.LoginThrottle.MaxDelay
+	}
+	return time.Duration(delay)
+}
+
+// recordFailedLogin counts a failed attempt against both the identifier and the client IP
+func (s *Server) recordFailedLogin(identifierKey, ipKey string) {
+	if err := s.recordLoginFailure(identifierKey, s.cfg.LoginThrottle.FreeAttempts, s.cfg.LoginThrottle.LockoutThreshold); err != nil {
+		log.Printf("Error recording failed login: %v", err)
+	}
+	if err := s.recordLoginFailure(ipKey, s.cfg.LoginThrottle.IPFreeAttempts, s.cfg.LoginThrottle.IPLockoutThreshold); err != nil {
+		log.Printf("Error recording failed login: %v", err)
+	}
+}
+
+// clearLoginFailures forgets the failures of a key after a successful login
+func (s *Server) clearLoginFailures(key string) error {
+	return s.stores.LoginAttempts.Clear(key)
+}
+
+func writeRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":       fmt.Sprintf("Too many failed login attempts. Please try again in %d seconds.", seconds),
+		"retry_after": seconds,
+	})
+}
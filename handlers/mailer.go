@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+
+	"forum/mail"
+)
+
+func (s *Server) sendMail(to, subject, body string) error {
+	if s.mailer == nil {
+		return errors.New("no mailer configured")
+	}
+	return s.mailer.Send(mail.Message{To: to, Subject: subject, Body: body})
+}
+
+// absoluteURL joins a path onto BaseURL
+func (s *Server) absoluteURL(path string) string {
+	return strings.TrimRight(s.cfg.BaseURL, "/") + path
+}
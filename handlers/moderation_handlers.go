@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"forum/store"
+)
+
+// DeletePostHandler removes a post with its comments and reactions.
+// Authors may delete their own posts; anyone else needs delete_any_post.
+func (s *Server) DeletePostHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := s.requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	postID, err := strconv.Atoi(r.FormValue("post_id"))
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, "Invalid post ID")
+		return
+	}
+
+	authorID, err := s.stores.Posts.AuthorID(postID)
+	if err == sql.ErrNoRows {
+		jsonError(w, http.StatusNotFound, "Post not found")
+		return
+	} else if err != nil {
+		log.Printf("Database error: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if authorID != session.UserID && !session.Role.Can(CapDeleteAnyPost) {
+		jsonError(w, http.StatusForbidden, "You can only delete your own posts")
+		return
+	}
+
+	if err := s.stores.Posts.Delete(postID); err != nil {
+		log.Printf("Error deleting post %d: %v", postID, err)
+		jsonError(w, http.StatusInternalServerError, "Failed to delete post")
+		return
+	}
+	if authorID != session.UserID {
+		log.Printf("%s (%s) deleted post %d", session.Nickname, session.Role, postID)
+	}
+
+	jsonResponse(w, map[string]string{"message": "Post deleted"})
+}
+
+// DeleteCommentHandler removes a comment and its reactions.
+// Authors may delete their own comments; anyone else needs delete_any_comment.
+func (s *Server) DeleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := s.requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	commentID, err := strconv.Atoi(r.FormValue("comment_id"))
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, "Invalid comment ID")
+		return
+	}
+
+	authorID, err := s.stores.Comments.AuthorID(commentID)
+	if err == sql.ErrNoRows {
+		jsonError(w, http.StatusNotFound, "Comment not found")
+		return
+	} else if err != nil {
+		log.Printf("Database error: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if authorID != session.UserID && !session.Role.Can(CapDeleteAnyComment) {
+		jsonError(w, http.StatusForbidden, "You can only delete your own comments")
+		return
+	}
+
+	if err := s.stores.Comments.Delete(commentID); err != nil {
+		log.Printf("Error deleting comment %d: %v", commentID, err)
+		jsonError(w, http.StatusInternalServerError, "Failed to delete comment")
+		return
+	}
+	if authorID != session.UserID {
+		log.Printf("%s (%s) deleted comment %d", session.Nickname, session.Role, commentID)
+	}
+
+	jsonResponse(w, map[string]string{"message": "Comment deleted"})
+}
+
+// CreateCategoryHandler adds a category; it needs manage_categories
+func (s *Server) CreateCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if _, ok := s.requireCapability(w, r, CapManageCategories); !ok {
+		return
+	}
+
+	const maxCategory = 30
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		jsonError(w, http.StatusBadRequest, "Category name cannot be empty")
+		return
+	}
+	if utf8.RuneCountInString(name) > maxCategory {
+		jsonError(w, http.StatusBadRequest, "Category name is too long")
+		return
+	}
+
+	created, err := s.stores.Posts.CreateCategory(name)
+	if err != nil {
+		log.Printf("Error creating category: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Failed to create category")
+		return
+	}
+	if !created {
+		jsonError(w, http.StatusConflict, "Category already exists")
+		return
+	}
+
+	jsonResponse(w, map[string]string{"message": "Category created"})
+}
+
+// DeleteCategoryHandler removes a category that no post uses; it needs manage_categories
+func (s *Server) DeleteCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if _, ok := s.requireCapability(w, r, CapManageCategories); !ok {
+		return
+	}
+
+	name := r.FormValue("name")
+	categoryID, posts, err := s.stores.Posts.CategoryUsage(name)
+	if err == sql.ErrNoRows {
+		jsonError(w, http.StatusNotFound, "Category not found")
+		return
+	} else if err != nil {
+		log.Printf("Database error: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if posts > 0 {
+		jsonError(w, http.StatusConflict, "Category is still used by "+strconv.Itoa(posts)+" posts")
+		return
+	}
+
+	if err := s.stores.Posts.DeleteCategory(categoryID); err != nil {
+		log.Printf("Error deleting category: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Failed to delete category")
+		return
+	}
+
+	jsonResponse(w, map[string]string{"message": "Category deleted"})
+}
+
+// SetRoleHandler assigns a role to a user; it needs manage_roles
+func (s *Server) SetRoleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := s.requireCapability(w, r, CapManageRoles)
+	if !ok {
+		return
+	}
+
+	role := Role(r.FormValue("role"))
+	if !role.valid() {
+		jsonError(w, http.StatusBadRequest, "Unknown role")
+		return
+	}
+
+	nickname := r.FormValue("nickname")
+	userID, err := s.stores.Users.IDByNickname(nickname)
+	if err == sql.ErrNoRows {
+		jsonError(w, http.StatusNotFound, "User not found")
+		return
+	} else if err != nil {
+		log.Printf("Database error: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if err := s.stores.Users.SetRole(userID, string(role)); err == store.ErrLastAdmin {
+		jsonError(w, http.StatusConflict, "The forum must keep at least one admin")
+		return
+	} else if err != nil {
+		log.Printf("Error setting role: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Failed to update role")
+		return
+	}
+	log.Printf("%s set the role of %s to %s", session.Nickname, nickname, role)
+
+	jsonResponse(w, map[string]string{"message": nickname + " is now a " + string(role)})
+}
@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"forum/models"
+	"forum/oidc"
+	"forum/store"
+)
+
+// defaultOIDCLoginTTL is how long a user has to finish logging in at the provider unless configured otherwise
+const defaultOIDCLoginTTL = 10 * time.Minute
+
+const oidcStateCookieName = "oidc_state"
+
+// errOIDCLogin is a login failure whose message can be shown to the user
+type errOIDCLogin string
+
+func (e errOIDCLogin) Error() string { return string(e) }
+
+// OIDCLoginHandler sends the browser to the provider's login page
+func (s *Server) OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if s.oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, err := oidc.NewAuthRequest()
+	if err != nil {
+		log.Printf("Error generating provider login: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now().UTC()
+	err = s.stores.Identities.CreateLogin(hashToken(req.State), req.Nonce, req.CodeVerifier, now, now.Add(s.cfg.OIDCLoginTTL))
+	if err != nil {
+		log.Printf("Error storing provider login: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	authURL, err := s.oidc.AuthCodeURL(ctx, req)
+	if err != nil {
+		log.Printf("Error contacting identity provider: %v", err)
+		redirectLoginError(w, r, s.cfg.OIDCProviderName+" sign-in is unavailable right now")
+		return
+	}
+
+	// The state is also kept in a cookie so the callback only completes in the browser that started it
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    req.State,
+		Path:     "/oauth",
+		MaxAge:   int(s.cfg.OIDCLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   s.cfg.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallbackHandler finishes a provider login: it checks the state, exchanges the
+// code, validates the ID token and logs the matching local user in
+func (s *Server) OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if s.oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	state := query.Get("state")
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookieName, Value: "", Path: "/oauth", MaxAge: -1})
+
+	cookie, err := r.Cookie(oidcStateCookieName)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		redirectLoginError(w, r, "Your sign-in request has expired. Please try again.")
+		return
+	}
+
+	var req oidc.AuthRequest
+	var expiresAt time.Time
+	req.Nonce, req.CodeVerifier, expiresAt, err = s.stores.Identities.TakeLogin(hashToken(state))
+	if err == sql.ErrNoRows || (err == nil && !time.Now().Before(expiresAt)) {
+		redirectLoginError(w, r, "Your sign-in request has expired. Please try again.")
+		return
+	} else if err != nil {
+		log.Printf("Database error: %v", err)
+		redirectLoginError(w, r, "Sign-in failed. Please try again.")
+		return
+	}
+
+	if providerError := query.Get("error"); providerError != "" {
+		log.Printf("Identity provider refused login: %s %s", providerError, query.Get("error_description"))
+		redirectLoginError(w, r, s.cfg.OIDCProviderName+" sign-in was cancelled or refused")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	claims, err := s.oidc.Exchange(ctx, query.Get("code"), req)
+	if err != nil {
+		log.Printf("Error completing provider login: %v", err)
+		redirectLoginError(w, r, s.cfg.OIDCProviderName+" sign-in failed. Please try again.")
+		return
+	}
+
+	userID, err := s.oidcUser(claims)
+	var loginErr errOIDCLogin
+	if errors.As(err, &loginErr) {
+		redirectLoginError(w, r, loginErr.Error())
+		return
+	} else if err != nil {
+		log.Printf("Error resolving provider account: %v", err)
+		redirectLoginError(w, r, "Sign-in failed. Please try again.")
+		return
+	}
+
+	// Accounts with two-factor enabled still need their second factor
+	user, err := s.stores.Users.ByID(userID)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		redirectLoginError(w, r, "Sign-in failed. Please try again.")
+		return
+	}
+	if user.TwoFactorEnabled {
+		challenge, err := s.createMFAChallenge(userID)
+		if err != nil {
+			log.Printf("Error creating two-factor challenge: %v", err)
+			redirectLoginError(w, r, "Sign-in failed. Please try again.")
+			return
+		}
+		http.Redirect(w, r, "/?two_factor="+url.QueryEscape(challenge), http.StatusSeeOther)
+		return
+	}
+
+	if _, err := s.startSession(w, r, userID, false); err != nil {
+		log.Printf("Error creating session: %v", err)
+		redirectLoginError(w, r, "Sign-in failed. Please try again.")
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func redirectLoginError(w http.ResponseWriter, r *http.Request, message string) {
+	http.Redirect(w, r, "/?login_error="+url.QueryEscape(message), http.StatusSeeOther)
+}
+
+// oidcUser finds the local account for a provider identity. Identities seen before map
+// to the user they were linked to; otherwise a user with the same email is linked if
+// both the provider and the forum have verified it, and failing that a new account is
+// created.
+func (s *Server) oidcUser(claims *oidc.Claims) (int, error) {
+	issuer := s.oidc.Issuer()
+
+	userID, err := s.stores.Identities.UserID(issuer, claims.Subject)
+	if err == nil {
+		return userID, nil
+	} else if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	email := strings.ToLower(strings.TrimSpace(claims.Email))
+	if validateEmail(email) != "" {
+		return 0, errOIDCLogin(s.cfg.OIDCProviderName + " did not share a usable email address")
+	}
+
+	user, err := s.stores.Users.ByEmail(email)
+	if err == nil {
+		// Both sides must have verified the address: the provider proves the person owns it,
+		// and the forum proves the local account's owner does. Otherwise whoever registered the
+		// address first, with a password of their choosing, would share the account.
+		if !bool(claims.EmailVerified) || !user.EmailVerified {
+			return 0, errOIDCLogin("An account with this email already exists. Log in with your password.")
+		}
+		return user.ID, s.linkIdentity(user.ID, issuer, claims.Subject)
+	} else if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	// Provider sign-in cannot carry an invite code, so it only creates accounts when registration is open
+	if s.cfg.Registration.Mode != RegistrationOpen {
+		return 0, errOIDCLogin("No forum account is linked to this " + s.cfg.OIDCProviderName + " login, and new accounts cannot be created this way")
+	}
+	return s.createOIDCUser(claims, issuer, email)
+}
+
+// linkIdentity records that the provider account belongs to the user
+func (s *Server) linkIdentity(userID int, issuer, subject string) error {
+	if err := s.stores.Identities.Link(userID, issuer, subject); err != nil {
+		return err
+	}
+	log.Printf("Linked %s account %s to user %d", issuer, subject, userID)
+	return nil
+}
+
+// createOIDCUser registers a new account for a first-time provider login
+func (s *Server) createOIDCUser(claims *oidc.Claims, issuer, email string) (int, error) {
+	firstName, lastName := oidcNames(claims)
+
+	// The account has no usable password until the user sets one through a reset
+	randomPassword, err := randomToken(32)
+	if err != nil {
+		return 0, err
+	}
+	hashedPassword, err := s.hashPassword(randomPassword)
+	if err != nil {
+		return 0, err
+	}
+
+	user := &models.User{
+		Email:         email,
+		PasswordHash:  hashedPassword,
+		FirstName:     firstName,
+		LastName:      lastName,
+		EmailVerified: bool(claims.EmailVerified),
+	}
+	err = s.stores.Identities.CreateUser(user, nicknameCandidates(claims), issuer, claims.Subject)
+	if err == store.ErrNicknamesTaken {
+		return 0, errOIDCLogin("Could not choose a nickname for your account. Please register instead.")
+	} else if err != nil {
+		return 0, err
+	}
+	userID, nickname := user.ID, user.Nickname
+	log.Printf("Created user %s for %s account %s", nickname, issuer, claims.Subject)
+
+	if !claims.EmailVerified {
+		if err := s.sendVerificationEmail(userID, nickname, email); err != nil {
+			log.Printf("Error sending verification email: %v", err)
+		}
+	}
+	s.BroadcastNewUser(nickname, firstName, lastName)
+
+	return userID, nil
+}
+
+// oidcNames picks first and last names from the token, falling back to the full name
+func oidcNames(claims *oidc.Claims) (string, string) {
+	first, last := strings.TrimSpace(claims.GivenName), strings.TrimSpace(claims.FamilyName)
+	if first == "" && last == "" {
+		fields := strings.Fields(claims.Name)
+		if len(fields) > 0 {
+			first = fields[0]
+			last = strings.Join(fields[1:], " ")
+		}
+	}
+	if first == "" {
+		first = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+	if last == "" {
+		last = "-"
+	}
+	return truncateRunes(first, 50), truncateRunes(last, 50)
+}
+
+// nicknameCandidates derives nicknames from the provider's username, email or name,
+// in the order to try them: the plain one first, then with a number added
+func nicknameCandidates(claims *oidc.Claims) []string {
+	base := ""
+	for _, candidate := range []string{claims.PreferredUsername, strings.SplitN(claims.Email, "@", 2)[0], claims.Name} {
+		if base = sanitizeNickname(candidate); base != "" {
+			break
+		}
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	nicknames := []string{base}
+	for i := 2; i <= 100; i++ {
+		suffix := fmt.Sprint(i)
+		nicknames = append(nicknames, truncateRunes(base, 30-len(suffix))+suffix)
+	}
+	return nicknames
+}
+
+// sanitizeNickname keeps letters, digits, '.', '_' and '-' and shortens the result
+func sanitizeNickname(s string) string {
+	var b strings.Builder
+	for _, r := range strings.TrimSpace(s) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '_' || r == '-':
+			b.WriteRune(r)
+		case unicode.IsSpace(r):
+			b.WriteRune('_')
+		}
+	}
+	return truncateRunes(strings.Trim(b.String(), "._-"), 30)
+}
+
+func truncateRunes(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	return string([]rune(s)[:max])
+}
@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"forum/oidc"
+	"forum/oidc/oidctest"
+)
+
+// newOIDCTestServer starts a forum that offers sign-in with a fresh test provider
+func newOIDCTestServer(t *testing.T, configure func(*Config)) (*testServer, *oidctest.Server) {
+	t.Helper()
+	idp := oidctest.NewServer("forum")
+	t.Cleanup(idp.Close)
+	ts := newTestServerWith(t, configure, func(cfg Config, deps *Deps) {
+		deps.OIDCProvider = oidc.NewProvider(oidc.Config{
+			Issuer:      idp.URL,
+			ClientID:    "forum",
+			RedirectURL: cfg.BaseURL + "/oauth/callback",
+			HTTPClient:  idp.Client(),
+		})
+	})
+	return ts, idp
+}
+
+// authorizeWithProvider starts a provider sign-in in the client's browser and
+// returns the callback the provider sends it back to
+func authorizeWithProvider(t *testing.T, c *testClient) string {
+	t.Helper()
+	resp := c.get("/oauth/login")
+	expectStatus(t, resp, http.StatusFound)
+
+	// The test provider logs the user in without asking
+	resp, err := c.http.Get(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("authorization endpoint: %v", err)
+	}
+	resp.Body.Close()
+	expectStatus(t, resp, http.StatusFound)
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return callback.RequestURI()
+}
+
+// finishProviderLogin follows the callback and returns the login error the forum
+// reports, if any
+func finishProviderLogin(t *testing.T, c *testClient, callback string) string {
+	t.Helper()
+	resp := c.get(callback)
+	expectStatus(t, resp, http.StatusSeeOther)
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location.Query().Get("login_error")
+}
+
+// signInWithProvider runs a whole provider sign-in and returns the login error, if any
+func signInWithProvider(t *testing.T, c *testClient) string {
+	t.Helper()
+	return finishProviderLogin(t, c, authorizeWithProvider(t, c))
+}
+
+func TestOIDCLoginCreatesAccount(t *testing.T) {
+	t.Parallel()
+	ts, idp := newOIDCTestServer(t, nil)
+	idp.SetIdentity(map[string]interface{}{
+		"sub":                "ada-1",
+		"email":              "Ada@Example.com",
+		"email_verified":     true,
+		"preferred_username": "ada lovelace",
+		"given_name":         "Ada",
+		"family_name":        "Lovelace",
+	})
+
+	c := ts.client(t)
+	if loginError := signInWithProvider(t, c); loginError != "" {
+		t.Fatalf("sign-in failed: %s", loginError)
+	}
+	if !c.loggedIn() {
+		t.Fatal("provider sign-in did not start a session")
+	}
+
+	user, err := ts.stores.Users.ByEmail("ada@example.com")
+	if err != nil {
+		t.Fatalf("no account was created: %v", err)
+	}
+	if user.Nickname != "ada_lovelace" || user.FirstName != "Ada" || user.LastName != "Lovelace" || !user.EmailVerified {
+		t.Errorf("created user = %+v", user)
+	}
+	if userID, err := ts.stores.Identities.UserID(idp.URL, "ada-1"); err != nil || userID != user.ID {
+		t.Errorf("identity links to user %d (%v), want %d", userID, err, user.ID)
+	}
+
+	// Later sign-ins follow the linked identity, even after the email changes at the provider
+	idp.SetIdentity(map[string]interface{}{"sub": "ada-1", "email": "countess@example.com", "email_verified": true})
+	if loginError := signInWithProvider(t, ts.client(t)); loginError != "" {
+		t.Fatalf("second sign-in failed: %s", loginError)
+	}
+	sessions, err := ts.stores.Sessions.ListByUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Errorf("user has %d sessions, want 2", len(sessions))
+	}
+	if _, err := ts.stores.Users.ByEmail("countess@example.com"); err != sql.ErrNoRows {
+		t.Errorf("looking up the new email = %v, want no second account", err)
+	}
+}
+
+func TestOIDCLoginLinksVerifiedEmail(t *testing.T) {
+	t.Parallel()
+	ts, idp := newOIDCTestServer(t, nil)
+	aliceID := ts.signUpUserID(t, "alice")
+	idp.SetIdentity(map[string]interface{}{"sub": "alice-at-idp", "email": "ALICE@example.com", "email_verified": true})
+
+	c := ts.client(t)
+	if loginError := signInWithProvider(t, c); loginError != "" {
+		t.Fatalf("sign-in failed: %s", loginError)
+	}
+	if !c.loggedIn() {
+		t.Fatal("provider sign-in did not start a session")
+	}
+	if userID, err := ts.stores.Identities.UserID(idp.URL, "alice-at-idp"); err != nil || userID != aliceID {
+		t.Errorf("identity links to user %d (%v), want alice (%d)", userID, err, aliceID)
+	}
+	sessions, err := ts.stores.Sessions.ListByUser(aliceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Errorf("alice has %d sessions, want 2", len(sessions))
+	}
+}
+
+func TestOIDCLoginRefusesUnverifiedLocalEmail(t *testing.T) {
+	t.Parallel()
+	ts, idp := newOIDCTestServer(t, nil)
+	// Someone registers with the victim's address and a password of their own, but cannot verify it
+	form := registrationForm("mallory")
+	form.Set("email", "victim@example.com")
+	expectStatus(t, ts.client(t).post("/register", form), http.StatusOK)
+	mallory, err := ts.stores.Users.ByNickname("mallory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.SetIdentity(map[string]interface{}{"sub": "victim", "email": "victim@example.com", "email_verified": true})
+
+	victim := ts.client(t)
+	if loginError := signInWithProvider(t, victim); !strings.Contains(loginError, "already exists") {
+		t.Fatalf("login error = %q, want the account to be refused", loginError)
+	}
+	if victim.loggedIn() {
+		t.Fatal("the victim was logged into an account someone else holds the password of")
+	}
+	if userID, err := ts.stores.Identities.UserID(idp.URL, "victim"); err != sql.ErrNoRows {
+		t.Errorf("identity links to user %d (%v), want no link to mallory (%d)", userID, err, mallory.ID)
+	}
+	if user, err := ts.stores.Users.ByID(mallory.ID); err != nil || user.EmailVerified {
+		t.Errorf("sign-in verified the unclaimed address: %+v, %v", user, err)
+	}
+}
+
+func TestOIDCLoginRefusesUnverifiedEmailMatch(t *testing.T) {
+	t.Parallel()
+	ts, idp := newOIDCTestServer(t, nil)
+	aliceID := ts.signUpUserID(t, "alice")
+	// Anyone can claim an address the provider has not verified
+	idp.SetIdentity(map[string]interface{}{"sub": "mallory", "email": "alice@example.com", "email_verified": false})
+
+	c := ts.client(t)
+	if loginError := signInWithProvider(t, c); !strings.Contains(loginError, "already exists") {
+		t.Fatalf("login error = %q, want the account to be refused", loginError)
+	}
+	if c.loggedIn() {
+		t.Fatal("an unverified email logged in as alice")
+	}
+	if userID, err := ts.stores.Identities.UserID(idp.URL, "mallory"); err != sql.ErrNoRows {
+		t.Errorf("identity links to user %d (%v), want no link to alice (%d)", userID, err, aliceID)
+	}
+}
+
+func TestOIDCLoginCreatesNoAccountsByInvite(t *testing.T) {
+	t.Parallel()
+	ts, idp := newOIDCTestServer(t, func(cfg *Config) { cfg.Registration.Mode = RegistrationInvite })
+	idp.SetIdentity(map[string]interface{}{"sub": "stranger", "email": "stranger@example.com", "email_verified": true})
+
+	c := ts.client(t)
+	if loginError := signInWithProvider(t, c); !strings.Contains(loginError, "cannot be created") {
+		t.Fatalf("login error = %q, want new accounts refused", loginError)
+	}
+	if c.loggedIn() {
+		t.Fatal("sign-in created an account without an invite")
+	}
+	if _, err := ts.stores.Users.ByEmail("stranger@example.com"); err != sql.ErrNoRows {
+		t.Errorf("looking up the stranger = %v, want no account", err)
+	}
+}
+
+func TestOIDCCallbackRejectsReplayedState(t *testing.T) {
+	t.Parallel()
+	ts, idp := newOIDCTestServer(t, nil)
+	idp.SetIdentity(map[string]interface{}{"sub": "ada-1", "email": "ada@example.com", "email_verified": true})
+
+	c := ts.client(t)
+	callback := authorizeWithProvider(t, c)
+	if loginError := finishProviderLogin(t, c, callback); loginError != "" {
+		t.Fatalf("sign-in failed: %s", loginError)
+	}
+
+	// Replaying the callback, even with the state cookie, finds the login already used
+	attacker := ts.client(t)
+	state, _ := url.ParseQuery(strings.SplitN(callback, "?", 2)[1])
+	forum, _ := url.Parse(ts.URL + "/oauth")
+	attacker.http.Jar.SetCookies(forum, []*http.Cookie{{Name: oidcStateCookieName, Value: state.Get("state"), Path: "/oauth"}})
+	if loginError := finishProviderLogin(t, attacker, callback); !strings.Contains(loginError, "expired") {
+		t.Fatalf("replayed callback: login error = %q, want the state refused", loginError)
+	}
+	if attacker.loggedIn() {
+		t.Fatal("a replayed callback started a session")
+	}
+}
+
+func TestOIDCCallbackNeedsTheBrowserThatStarted(t *testing.T) {
+	t.Parallel()
+	ts, idp := newOIDCTestServer(t, nil)
+	idp.SetIdentity(map[string]interface{}{"sub": "ada-1", "email": "ada@example.com", "email_verified": true})
+
+	// A callback link planted in another browser carries no state cookie
+	callback := authorizeWithProvider(t, ts.client(t))
+	victim := ts.client(t)
+	if loginError := finishProviderLogin(t, victim, callback); !strings.Contains(loginError, "expired") {
+		t.Fatalf("login error = %q, want the state refused", loginError)
+	}
+	if victim.loggedIn() {
+		t.Fatal("a callback without the state cookie started a session")
+	}
+}
+
+func TestOIDCCallbackRejectsBadIDTokens(t *testing.T) {
+	t.Parallel()
+	ts, idp := newOIDCTestServer(t, nil)
+	idp.SetIdentity(map[string]interface{}{"sub": "ada-1", "email": "ada@example.com", "email_verified": true})
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		idToken func(claims map[string]interface{}) string
+	}{
+		{"bad signature", func(claims map[string]interface{}) string {
+			return oidctest.Sign(otherKey, idp.KeyID, claims)
+		}},
+		{"wrong issuer", func(claims map[string]interface{}) string {
+			claims["iss"] = "https://evil.example"
+			return idp.Sign(claims)
+		}},
+		{"wrong audience", func(claims map[string]interface{}) string {
+			claims["aud"] = "another-client"
+			return idp.Sign(claims)
+		}},
+		{"expired", func(claims map[string]interface{}) string {
+			claims["exp"] = time.Now().Add(-time.Hour).Unix()
+			return idp.Sign(claims)
+		}},
+		{"wrong nonce", func(claims map[string]interface{}) string {
+			claims["nonce"] = "another-login"
+			return idp.Sign(claims)
+		}},
+	} {
+		idp.SetIDToken(tt.idToken)
+		c := ts.client(t)
+		if loginError := signInWithProvider(t, c); !strings.Contains(loginError, "sign-in failed") {
+			t.Errorf("%s: login error = %q, want sign-in to fail", tt.name, loginError)
+		}
+		if c.loggedIn() {
+			t.Errorf("%s: the token started a session", tt.name)
+		}
+	}
+	if _, err := ts.stores.Users.ByEmail("ada@example.com"); err != sql.ErrNoRows {
+		t.Errorf("looking up ada = %v, want no account", err)
+	}
+
+	idp.SetIDToken(nil)
+	if loginError := signInWithProvider(t, ts.client(t)); loginError != "" {
+		t.Fatalf("sign-in with a good token failed: %s", loginError)
+	}
+}
+
+func TestOIDCLoginWithTwoFactorAsksForSecondFactor(t *testing.T) {
+	t.Parallel()
+	ts, idp := newOIDCTestServer(t, nil)
+	alice := ts.signUp(t, "alice")
+	enableTwoFactor(t, alice)
+	idp.SetIdentity(map[string]interface{}{"sub": "alice-at-idp", "email": "alice@example.com", "email_verified": true})
+
+	c := ts.client(t)
+	resp := c.get(authorizeWithProvider(t, c))
+	expectStatus(t, resp, http.StatusSeeOther)
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location.Query().Get("two_factor") == "" {
+		t.Fatalf("redirected to %s, want a two-factor challenge", location)
+	}
+	if c.loggedIn() {
+		t.Fatal("provider sign-in skipped the second factor")
+	}
+}
+
+func TestOIDCRoutesAreOffWithoutProvider(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, nil)
+	c := ts.client(t)
+	expectStatus(t, c.get("/oauth/login"), http.StatusNotFound)
+	expectStatus(t, c.get("/oauth/callback?state=x&code=y"), http.StatusNotFound)
+}
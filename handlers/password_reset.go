@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"forum/models"
+)
+
+// defaultPasswordResetTTL is how long a password reset link stays valid unless configured otherwise
+const defaultPasswordResetTTL = 1 * time.Hour
+
+// newResetToken returns a random token for the reset link and the hash stored in the database
+func newResetToken() (string, string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	return token, hashToken(token), nil
+}
+
+// ForgotPasswordHandler emails a single-use reset link to the account with the given address.
+// The response is the same whether or not the address is registered.
+func (s *Server) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Method not allowed"})
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.FormValue("email")))
+	if email == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Email is required"})
+		return
+	}
+
+	response := map[string]string{"message": "If an account with that email exists, a password reset link has been sent."}
+
+	user, err := s.stores.Users.ByEmail(email)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+		return
+	} else if err != nil {
+		log.Printf("Database error: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+	userID, nickname := user.ID, user.Nickname
+
+	token, tokenHash, err := newResetToken()
+	if err != nil {
+		log.Printf("Error generating reset token: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	if err := s.storeResetToken(userID, tokenHash); err != nil {
+		log.Printf("Error storing reset token: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	link := s.absoluteURL("/reset_password?token=" + url.QueryEscape(token))
+	body := fmt.Sprintf(
+		"Hi %s,\n\nSomeone asked to reset the password of your forum account.\n"+
+			"Open the link below within %d minutes to choose a new password:\n\n%s\n\n"+
+			"If you did not ask for this, you can ignore this email.\n",
+		nickname, int(s.cfg.PasswordResetTTL.Minutes()), link,
+	)
+	if err := s.sendMail(email, "Reset your forum password", body); err != nil {
+		log.Printf("Error sending password reset email: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to send reset email"})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// ResetPasswordHandler shows the reset form on GET and sets the new password on POST.
+// A successful reset consumes the token and logs the user out everywhere.
+func (s *Server) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		s.showResetPasswordPage(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Method not allowed"})
+		return
+	}
+
+	token := r.FormValue("token")
+	password := r.FormValue("password")
+
+	reset, err := s.stores.PasswordResets.ByTokenHash(hashToken(token))
+	var user *models.User
+	if err == nil {
+		user, err = s.stores.Users.ByID(reset.UserID)
+	}
+	if err == sql.ErrNoRows || (err == nil && (reset.UsedAt != nil || !time.Now().Before(reset.ExpiresAt))) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "This reset link is invalid or has expired"})
+		return
+	} else if err != nil {
+		log.Printf("Database error: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	if msg := s.validatePassword(password, user.Nickname, user.Email, user.FirstName, user.LastName); msg != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  "Validation error",
+			"fields": map[string]string{"password": msg},
+		})
+		return
+	}
+
+	hashedPassword, err := s.hashPassword(password)
+	if err != nil {
+		log.Printf("Error hashing password: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Error hashing password"})
+		return
+	}
+
+	applied, err := s.stores.PasswordResets.Redeem(hashToken(token), user.ID, hashedPassword, time.Now().UTC())
+	if err != nil {
+		log.Printf("Error resetting password: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+	if !applied {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "This reset link is invalid or has expired"})
+		return
+	}
+
+	s.disconnectUser(user.Nickname, "Password changed")
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Your password has been reset. Please log in."})
+}
+
+// storeResetToken replaces any earlier reset token of the user, so only the most recent link works
+func (s *Server) storeResetToken(userID int, tokenHash string) error {
+	now := time.Now().UTC()
+	return s.stores.PasswordResets.Replace(tokenHash, &models.PasswordReset{
+		UserID:    userID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.cfg.PasswordResetTTL),
+	})
+}
+
+func (s *Server) showResetPasswordPage(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := template.ParseFiles(filepath.Join(s.cfg.PagesDir, "reset_password.html"))
+	if err != nil {
+		log.Printf("Template parsing error: %v", err)
+		http.Error(w, "Error parsing template", http.StatusInternalServerError)
+		return
+	}
+
+	csrfToken, err := s.csrfTokenFor(w, r)
+	if err != nil {
+		log.Printf("Error issuing CSRF token: %v", err)
+		http.Error(w, "Error rendering page", http.StatusInternalServerError)
+		return
+	}
+
+	err = tmpl.Execute(w, map[string]string{
+		"CSRFToken": csrfToken,
+		"Token":     r.URL.Query().Get("token"),
+	})
+	if err != nil {
+		log.Printf("Error executing template: %v", err)
+	}
+}
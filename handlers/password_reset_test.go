@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestPasswordResetLogsOutEverywhere(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, nil)
+	c := ts.signUp(t, "alice")
+
+	expectStatus(t, ts.client(t).post("/forgot_password", url.Values{"email": {"alice@example.com"}}), http.StatusOK)
+	link, err := url.Parse(ts.mailer.lastLink(t, "alice@example.com", "/reset_password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := link.Query().Get("token")
+
+	const newPassword = "Another Strong passphrase 77"
+	reset := ts.client(t)
+	expectStatus(t, reset.post("/reset_password", url.Values{"token": {token}, "password": {newPassword}}), http.StatusOK)
+	expectStatus(t, reset.post("/reset_password", url.Values{"token": {token}, "password": {newPassword + "8"}}), http.StatusBadRequest)
+
+	if c.loggedIn() {
+		t.Error("the reset did not end the existing session")
+	}
+	expectStatus(t, ts.client(t).post("/login", url.Values{"email": {"alice"}, "password": {testPassword}}), http.StatusUnauthorized)
+	ts.client(t).login("alice", newPassword)
+}
+
+func TestChangePasswordKeepsThisDevice(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, nil)
+	laptop := ts.signUp(t, "alice")
+	phone := ts.client(t)
+	phone.login("alice", testPassword)
+
+	resp := laptop.post("/change_password", url.Values{
+		"current_password": {testPassword},
+		"new_password":     {"Another Strong passphrase 77"},
+	})
+	expectStatus(t, resp, http.StatusOK)
+	if revoked := decodeJSON(t, resp)["revoked"]; revoked != float64(1) {
+		t.Errorf("revoked = %v, want 1", revoked)
+	}
+	if !laptop.loggedIn() {
+		t.Error("changing the password logged out the device that changed it")
+	}
+	if phone.loggedIn() {
+		t.Error("the other device is still logged in")
+	}
+}
@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// PasswordConfig controls which new passwords are accepted at registration,
+// password reset and password change
+type PasswordConfig struct {
+	MinLength int
+	MaxLength int
+	// MinScore is the lowest acceptable strength score, from 0 (trivial to guess) to 4 (strong)
+	MinScore int
+	// HashConcurrency caps how many passwords are hashed or checked at once; each
+	// argon2id run holds its whole memory cost until it finishes
+	HashConcurrency int
+}
+
+// defaultPasswordRules are the password requirements used unless configured otherwise
+var defaultPasswordRules = PasswordConfig{
+	MinLength:       8,
+	MaxLength:       100,
+	MinScore:        3,
+	HashConcurrency: 4,
+}
+
+// strengthThresholds are the estimated bits of entropy needed for scores 1 to 4
+var strengthThresholds = [4]float64{25, 35, 50, 65}
+
+// PasswordBlocklist holds common and breached passwords to refuse; the zero value refuses none
+type PasswordBlocklist struct {
+	// words are plain passwords, lower-cased; they also count as dictionary words
+	// when they appear inside a longer password
+	words map[string]bool
+	// hashes are upper-case hex SHA-1 digests, as published in breach corpora
+	hashes map[string]bool
+}
+
+// LoadPasswordBlocklist reads a file of passwords to refuse, one per line. A line is
+// either a plain password or a SHA-1 hex digest, optionally followed by ":count" as in
+// breach downloads. Blank lines and lines starting with # are ignored.
+func LoadPasswordBlocklist(path string) (*PasswordBlocklist, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	words := make(map[string]bool)
+	hashes := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if digest, _, _ := strings.Cut(line, ":"); isSHA1Hex(digest) {
+			hashes[strings.ToUpper(digest)] = true
+			continue
+		}
+		words[strings.ToLower(line)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return &PasswordBlocklist{words: words, hashes: hashes}, nil
+}
+
+// Len is the number of passwords and digests in the blocklist
+func (b *PasswordBlocklist) Len() int {
+	return len(b.words) + len(b.hashes)
+}
+
+func isSHA1Hex(s string) bool {
+	if len(s) != 2*sha1.Size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// contains reports whether the password appears in the blocklist
+func (b *PasswordBlocklist) contains(password string) bool {
+	if b.words[strings.ToLower(password)] {
+		return true
+	}
+	sum := sha1.Sum([]byte(password))
+	return b.hashes[strings.ToUpper(hex.EncodeToString(sum[:]))]
+}
+
+// PasswordStrength is the estimated resistance of a password to guessing
+type PasswordStrength struct {
+	// Entropy is the estimated number of guesses needed, in bits
+	Entropy float64
+	// Score buckets the entropy from 0 (trivial to guess) to 4 (strong)
+	Score int
+	// Weaknesses describe the predictable patterns that lowered the estimate
+	Weaknesses []string
+}
+
+// leetSubstitutions undoes common character swaps before looking for words
+var leetSubstitutions = map[rune]rune{
+	'@': 'a', '4': 'a', '8': 'b', '3': 'e', '6': 'g', '1': 'i', '!': 'i',
+	'0': 'o', '$': 's', '5': 's', '7': 't', '2': 'z',
+}
+
+// keyboardRows are scanned in both directions for runs such as "qwerty" or "4321"
+var keyboardRows = []string{"1234567890", "qwertyuiop", "asdfghjkl", "zxcvbnm"}
+
+// estimatePasswordStrength estimates how many guesses a password would take.
+// Characters are worth log2 of the alphabet they are drawn from, except where they
+// form a predictable pattern: repeated characters, alphabetic, numeric or keyboard
+// sequences, the user's own details and words from dictionary only count as one
+// guess among the possibilities of that pattern.
+func estimatePasswordStrength(password string, userInputs []string, dictionary map[string]bool) PasswordStrength {
+	runes := []rune(strings.ToLower(password))
+	plain := make([]rune, len(runes))
+	for i, r := range runes {
+		if sub, ok := leetSubstitutions[r]; ok {
+			plain[i] = sub
+		} else {
+			plain[i] = r
+		}
+	}
+
+	var strength PasswordStrength
+	covered := make([]bool, len(runes))
+	cover := func(start, end int) {
+		for i := start; i < end; i++ {
+			covered[i] = true
+		}
+	}
+	free := func(start, end int) bool {
+		for i := start; i < end; i++ {
+			if covered[i] {
+				return false
+			}
+		}
+		return true
+	}
+	noted := make(map[string]bool)
+	note := func(weakness string) {
+		if !noted[weakness] {
+			noted[weakness] = true
+			strength.Weaknesses = append(strength.Weaknesses, weakness)
+		}
+	}
+
+	personal := make(map[string]bool)
+	for _, input := range userInputs {
+		input = strings.ToLower(strings.TrimSpace(input))
+		if local, _, found := strings.Cut(input, "@"); found {
+			input = local
+		}
+		if len([]rune(input)) >= 3 {
+			personal[input] = true
+		}
+	}
+
+	// Repeated characters: "aaaa" is little harder to guess than "a"
+	for start := 0; start < len(runes); {
+		end := start + 1
+		for end < len(runes) && runes[end] == runes[start] {
+			end++
+		}
+		if end-start >= 3 && free(start, end) {
+			strength.Entropy += math.Log2(float64(end - start))
+			cover(start+1, end)
+			note("avoid repeated characters such as \"aaa\"")
+		}
+		start = end
+	}
+
+	// Sequences: "abcd", "9876" and runs along a keyboard row
+	for start := 0; start < len(runes); start++ {
+		if covered[start] {
+			continue
+		}
+		end := sequenceEnd(runes, start)
+		if end-start >= 3 && free(start, end) {
+			strength.Entropy += math.Log2(float64(end-start)) + 1
+			cover(start+1, end)
+			note("avoid sequences such as \"abc\", \"123\" or \"qwerty\"")
+			start = end - 1
+		}
+	}
+
+	// Words, longest first so that "password" wins over "pass"
+	dictionaryBits := math.Log2(float64(len(dictionary) + 1))
+	for length := len(runes); length >= 3; length-- {
+		for start := 0; start+length <= len(runes); start++ {
+			if !free(start, start+length) {
+				continue
+			}
+			literal := string(runes[start : start+length])
+			word := string(plain[start : start+length])
+			switch {
+			case personal[literal] || personal[word]:
+				strength.Entropy += 1
+				note("avoid using your nickname, name or email address")
+			case length >= 4 && (dictionary[literal] || dictionary[word]):
+				strength.Entropy += dictionaryBits
+				note(fmt.Sprintf("avoid common words and passwords such as %q", literal))
+			default:
+				continue
+			}
+			cover(start, start+length)
+		}
+	}
+
+	poolBits := math.Log2(float64(alphabetSize(password)))
+	for i := range runes {
+		if !covered[i] {
+			strength.Entropy += poolBits
+		}
+	}
+
+	for _, threshold := range strengthThresholds {
+		if strength.Entropy >= threshold {
+			strength.Score++
+		}
+	}
+	return strength
+}
+
+// sequenceEnd returns where the alphabetic, numeric or keyboard sequence starting at start ends
+func sequenceEnd(runes []rune, start int) int {
+	best := start + 1
+	for _, step := range []rune{1, -1} {
+		end := start + 1
+		for end < len(runes) && runes[end]-runes[end-1] == step && isAlphanumeric(runes[end]) {
+			end++
+		}
+		if end > best {
+			best = end
+		}
+	}
+	for _, row := range keyboardRows {
+		for _, order := range []string{row, reverse(row)} {
+			end := start + 1
+			for end < len(runes) && strings.Contains(order, string(runes[end-1:end+1])) {
+				end++
+			}
+			if end > best {
+				best = end
+			}
+		}
+	}
+	return best
+}
+
+func isAlphanumeric(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9')
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+// alphabetSize estimates how many characters each position of the password could hold
+func alphabetSize(password string) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	size := 0
+	for _, class := range []struct {
+		present bool
+		size    int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.present {
+			size += class.size
+		}
+	}
+	return max(size, 1)
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEstimatePasswordStrength(t *testing.T) {
+	dictionary := map[string]bool{"password": true, "dragon": true, "monkey": true, "sunshine": true}
+	userInputs := []string{"alice", "Alice@Example.com", "Liddell"}
+
+	tests := []struct {
+		password string
+		score    int
+		// weakness is part of the advice expected, or empty when none is
+		weakness string
+	}{
+		// Common words, also behind character swaps
+		{"password", 0, "common words"},
+		{"P@ssw0rd", 0, `"p@ssw0rd"`},
+		{"Dragon!Monkey7", 0, `"monkey"`},
+		{"Sunshine2!Mv", 1, "common words"},
+		// Repeats
+		{"aaaaaaaaaaaa", 0, "repeated characters"},
+		{"Aaa111!!", 1, "repeated characters"},
+		// Alphabetic, numeric and keyboard sequences, both ways
+		{"abcdefgh", 0, "sequences"},
+		{"zyxwvuts", 0, "sequences"},
+		{"12345678", 0, "sequences"},
+		{"87654321", 0, "sequences"},
+		{"qwertyuiop", 0, "sequences"},
+		{"poiuytrewq", 0, "sequences"},
+		{"Qwerty123!", 1, "sequences"},
+		// The user's own details
+		{"alice1990!", 1, "nickname, name or email"},
+		{"Liddell!42x", 1, "nickname, name or email"},
+		// Random characters score by length
+		{"kT9#", 1, ""},
+		{"kT9#mQ2", 2, ""},
+		{"kT9#mQ2x", 3, ""},
+		{"kT9#mQ2xLw", 4, ""},
+		{"Correct horse Battery staple 9!", 4, ""},
+	}
+	for _, tt := range tests {
+		strength := estimatePasswordStrength(tt.password, userInputs, dictionary)
+		if strength.Score != tt.score {
+			t.Errorf("%q: score %d (%.1f bits), want %d", tt.password, strength.Score, strength.Entropy, tt.score)
+		}
+		advice := strings.Join(strength.Weaknesses, "; ")
+		if tt.weakness == "" && advice != "" {
+			t.Errorf("%q: unexpected advice %q", tt.password, advice)
+		} else if !strings.Contains(advice, tt.weakness) {
+			t.Errorf("%q: advice %q does not mention %q", tt.password, advice, tt.weakness)
+		}
+	}
+}
+
+func TestPatternsCountLessThanRandomCharacters(t *testing.T) {
+	random := estimatePasswordStrength("kT9#mQ2xLw9P", nil, nil).Entropy
+	for _, weaker := range []string{"kT9#mQ2xaaaa", "kT9#mQ2xabcd", "kT9#mQ2x4321", "kT9#mQ2xasdf"} {
+		if entropy := estimatePasswordStrength(weaker, nil, nil).Entropy; entropy >= random {
+			t.Errorf("%q: %.1f bits, want fewer than the %.1f of random characters", weaker, entropy, random)
+		}
+	}
+}
+
+func TestValidatePasswordMinScore(t *testing.T) {
+	s := &Server{cfg: DefaultConfig(), blocklist: &PasswordBlocklist{}}
+	if s.cfg.Passwords.MinScore != 3 {
+		t.Fatalf("default MinScore = %d, the cases below assume 3", s.cfg.Passwords.MinScore)
+	}
+
+	// Both have every character class; only the strength estimate tells them apart
+	if problem := s.validatePassword("Zz9!Zz9!", "alice"); problem != "" {
+		t.Errorf("score 3 password refused: %s", problem)
+	}
+	if problem := s.validatePassword("Qw3rty!9", "alice"); !strings.Contains(problem, "too easy to guess (strength 2 of 4)") {
+		t.Errorf("score 2 password: problem %q, want it refused as too easy to guess", problem)
+	}
+	if problem := s.validatePassword("Alice!2024x", "alice"); !strings.Contains(problem, "nickname, name or email") {
+		t.Errorf("password with the nickname: problem %q, want it refused", problem)
+	}
+
+	s.cfg.Passwords.MinScore = 2
+	if problem := s.validatePassword("Qw3rty!9", "alice"); problem != "" {
+		t.Errorf("score 2 password refused with MinScore 2: %s", problem)
+	}
+}
+
+func TestPasswordBlocklist(t *testing.T) {
+	breached := sha1.Sum([]byte("Tr0ub4dor&3"))
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	content := strings.Join([]string{
+		"# common passwords",
+		"Dragon",
+		"",
+		"  letmein  ",
+		strings.ToLower(hex.EncodeToString(breached[:])) + ":1234",
+	}, "\n")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	blocklist, err := LoadPasswordBlocklist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blocklist.Len() != 3 {
+		t.Errorf("Len = %d, want 3", blocklist.Len())
+	}
+	for password, want := range map[string]bool{
+		"dragon":      true,
+		"DRAGON":      true,
+		"letmein":     true,
+		"Tr0ub4dor&3": true,
+		// Digests match exactly, unlike plain words
+		"tr0ub4dor&3":        false,
+		"# common passwords": false,
+		"dragonfly":          false,
+	} {
+		if got := blocklist.contains(password); got != want {
+			t.Errorf("contains(%q) = %v, want %v", password, got, want)
+		}
+	}
+
+	// Listed words also weaken longer passwords that contain them
+	s := &Server{cfg: DefaultConfig(), blocklist: blocklist}
+	if problem := s.validatePassword("Letmein!Dragon7", "alice"); !strings.Contains(problem, "common words") {
+		t.Errorf("password of listed words: problem %q, want it refused", problem)
+	}
+	if problem := s.validatePassword("Tr0ub4dor&3", "alice"); !strings.Contains(problem, "breached passwords") {
+		t.Errorf("breached password: problem %q, want it refused", problem)
+	}
+
+	if _, err := LoadPasswordBlocklist(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("loading a missing blocklist succeeded")
+	}
+
+	// The zero value refuses nothing
+	if (&PasswordBlocklist{}).contains("password") {
+		t.Error("the empty blocklist refused a password")
+	}
+}
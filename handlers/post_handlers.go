@@ -3,23 +3,40 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"path/filepath"
 	"strings"
 	"time"
+	"unicode/utf8"
 
-	
-	"forum/database"
 	"forum/models"
-	"forum/utils"
+	"forum/store"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// PostConfig limits the posts users may submit
+type PostConfig struct {
+	// MaxTitleLength and MaxContentLength are counted in characters
+	MaxTitleLength   int
+	MaxContentLength int
+	// Cooldown is the least time a user must wait between two posts
+	Cooldown time.Duration
+}
+
+// defaultPosts are the post limits used unless configured otherwise
+var defaultPosts = PostConfig{
+	MaxTitleLength:   100,
+	MaxContentLength: 1000,
+	Cooldown:         1 * time.Second,
+}
+
 // Unchanged HomePage handler
-func HomePage(w http.ResponseWriter, r *http.Request) {
+func (s *Server) HomePage(w http.ResponseWriter, r *http.Request) {
 	response := make(map[string]interface{})
 
 	if r.Method != http.MethodGet {
@@ -42,14 +59,36 @@ func HomePage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tmpl, err := template.ParseFiles("./pages/index.html")
+	tmpl, err := template.ParseFiles(filepath.Join(s.cfg.PagesDir, "index.html"))
 	if err != nil {
 		log.Printf("Template parsing error: %v", err)
 		http.Error(w, "Error parsing template", http.StatusInternalServerError)
 		return
 	}
 
-	err = tmpl.Execute(w, nil)
+	csrfToken, err := s.csrfTokenFor(w, r)
+	if err != nil {
+		log.Printf("Error issuing CSRF token: %v", err)
+		http.Error(w, "Error rendering page", http.StatusInternalServerError)
+		return
+	}
+
+	oidcName := ""
+	if s.oidc != nil {
+		oidcName = s.cfg.OIDCProviderName
+	}
+
+	err = tmpl.Execute(w, map[string]interface{}{
+		"CSRFToken":         csrfToken,
+		"OIDCName":          oidcName,
+		"GenderOptions":     s.cfg.Demographics.GenderOptions,
+		"AllowCustomGender": s.cfg.Demographics.AllowCustomGender,
+		"MaxCustomGender":   s.cfg.Demographics.MaxCustomGender,
+		"RequireAge":        s.cfg.Demographics.RequireAge,
+		"MinAge":            s.cfg.Demographics.MinAge,
+		"MaxAge":            s.cfg.Demographics.MaxAge,
+		"RegistrationMode":  string(s.cfg.Registration.Mode),
+	})
 	if err != nil {
 		log.Printf("Error executing template: %v", err)
 		http.Error(w, "Error rendering posts", http.StatusInternalServerError)
@@ -58,7 +97,7 @@ func HomePage(w http.ResponseWriter, r *http.Request) {
 }
 
 // Updated ShowPosts handler
-func ShowPosts(w http.ResponseWriter, r *http.Request) {
+func (s *Server) ShowPosts(w http.ResponseWriter, r *http.Request) {
 	response := make(map[string]interface{})
 
 	if r.Method != http.MethodGet {
@@ -68,140 +107,37 @@ func ShowPosts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, sessionToken, _, err := RequireLogin(w, r)
+	session, err := s.currentSession(w, r)
 	if err != nil {
 		response["error"] = "Unauthorized access. Please log in."
 		w.WriteHeader(http.StatusUnauthorized)
 		json.NewEncoder(w).Encode(response)
 		return
 	}
+	viewerID := 0
+	if session != nil {
+		viewerID = session.UserID
+	}
 
 	category := r.URL.Query().Get("category")
-	var postStmt string
-	var postRows *sql.Rows
-
-	if category == "all" || category == "" {
-		postStmt = "SELECT id, title, content, created_at FROM Posts ORDER BY created_at DESC"
-		postRows, err = database.DB.Query(postStmt)
-	} else {
-		postStmt = `
-			SELECT p.id, p.title, p.content, p.created_at
-			FROM Posts p
-			INNER JOIN post_categories pc ON p.id = pc.post_id
-			INNER JOIN categories c ON pc.category_id = c.id
-			WHERE c.name = ?
-			ORDER BY p.created_at DESC
-		`
-		postRows, err = database.DB.Query(postStmt, category)
+	if category == "all" {
+		category = ""
 	}
 
+	posts, err := s.stores.Posts.List(category, viewerID)
 	if err != nil {
 		log.Printf("Error querying posts: %v", err)
 		http.Error(w, "Error retrieving posts", http.StatusInternalServerError)
 		return
 	}
-	defer postRows.Close()
-
-	var posts []models.PostWithLike
-	for postRows.Next() {
-		var p models.Post
-		var postWithLike models.PostWithLike
-		var postID int
-		var createdAt time.Time
-		err = postRows.Scan(&postID, &p.Title, &p.Content, &createdAt)
-		if err != nil {
-			log.Printf("Error scanning post: %v", err)
-			continue
-		}
-		p.PostID = postID
-		p.CreatedAt = createdAt
-
-		var userID int
-		userIdStmt := "SELECT user_id FROM posts WHERE id = ?"
-		err = database.DB.QueryRow(userIdStmt, postID).Scan(&userID)
-		if err != nil {
-			response["error"] = "Unauthorized access. Please log in."
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(response)
-			return
-		}
-
-		authorStmt := "SELECT nickname FROM users WHERE id = ?"
-		err = database.DB.QueryRow(authorStmt, userID).Scan(&p.Author)
-		if err != nil {
-			response["error"] = "Failed to get author information."
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(response)
-			return
-		}
-
-		if sessionToken != "guest" {
-			var isLike sql.NullBool
-			err = database.DB.QueryRow(`
-				SELECT is_like FROM post_likes
-				WHERE post_id = ? AND user_id = ?
-			`, postID, userID).Scan(&isLike)
-			if err != nil && err != sql.ErrNoRows {
-				log.Printf("Error retrieving like status for post %d: %v", postID, err)
-				continue
-			}
-			if isLike.Valid {
-				if isLike.Bool {
-					postWithLike.IsLike = 1
-				} else {
-					postWithLike.IsLike = 2
-				}
-			} else {
-				postWithLike.IsLike = -1
-			}
-		}
 
-		err = database.DB.QueryRow(`
-			SELECT
-				COUNT(CASE WHEN is_like = true THEN 1 END) AS like_count,
-				COUNT(CASE WHEN is_like = false THEN 1 END) AS dislike_count
-			FROM post_likes
-			WHERE post_id = ?
-		`, postID).Scan(&postWithLike.LikeCount, &postWithLike.DislikeCount)
+	for i := range posts {
+		comments, err := s.stores.Comments.ForPost(posts[i].PostID, viewerID)
 		if err != nil {
-			log.Printf("Error retrieving like/dislike counts for post %d: %v", postID, err)
-			continue
-		}
-
-		catStmt := `
-			SELECT c.name
-			FROM categories c
-			INNER JOIN post_categories pc ON c.id = pc.category_id
-			WHERE pc.post_id = ?`
-		catRows, err := database.DB.Query(catStmt, postID)
-		if err != nil {
-			log.Printf("Error querying categories for post %d: %v", postID, err)
-			continue
-		}
-
-		var categories []string
-		for catRows.Next() {
-			var category string
-			if err := catRows.Scan(&category); err != nil {
-				log.Printf("Error scanning category for post %d: %v", postID, err)
-				continue
-			}
-			categories = append(categories, category)
-		}
-		catRows.Close()
-
-		comments, err := ShowComments(postID, w, r)
-		if err != nil {
-			log.Printf("Error retrieving comments for post %d: %v", postID, err)
+			log.Printf("Error retrieving comments for post %d: %v", posts[i].PostID, err)
 			comments = []models.CommentWithLike{}
 		}
-
-		p.Categories = categories
-		p.Comments = comments
-
-		postWithLike.Post = p
-
-		posts = append(posts, postWithLike)
+		posts[i].Comments = comments
 	}
 
 	if len(posts) == 0 {
@@ -221,11 +157,11 @@ func ShowPosts(w http.ResponseWriter, r *http.Request) {
 }
 
 // Updated PostSubmit handler
-func PostSubmit(w http.ResponseWriter, r *http.Request) {
-	nickname, sessionToken, loggedIn, _ := RequireLogin(w, r) // Changed variable name
+func (s *Server) PostSubmit(w http.ResponseWriter, r *http.Request) {
+	session, _ := s.currentSession(w, r)
 	response := make(map[string]interface{})
 
-	if !loggedIn {
+	if session == nil {
 		log.Println("User not logged in")
 		response["error"] = "You need to log in to submit a post."
 		w.WriteHeader(http.StatusUnauthorized)
@@ -240,12 +176,13 @@ func PostSubmit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	title := utils.EscapeString(r.FormValue("title"))
-	content := utils.EscapeString(r.FormValue("content"))
-	categoryNames := r.Form["category"]
+	if !s.requireVerifiedEmail(w, session.Nickname) {
+		return
+	}
 
-	const maxTitle = 100
-	const maxContent = 1000
+	title := r.FormValue("title")
+	content := r.FormValue("content")
+	categoryNames := r.Form["category"]
 
 	if strings.TrimSpace(title) == "" || strings.TrimSpace(content) == "" || len(categoryNames) == 0 {
 		response["error"] = "All fields (title, content, and category) are required."
@@ -254,47 +191,21 @@ func PostSubmit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len(title) > maxTitle {
-		response["error"] = fmt.Sprintf("Title cannot be longer than %d characters.", maxTitle)
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
-		return
-	}
-
-	if len(content) > maxContent {
-		response["error"] = fmt.Sprintf("Content cannot be longer than %d characters.", maxContent)
+	if utf8.RuneCountInString(title) > s.cfg.Posts.MaxTitleLength {
+		response["error"] = fmt.Sprintf("Title cannot be longer than %d characters.", s.cfg.Posts.MaxTitleLength)
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(response)
 		return
 	}
 
-	var exists bool
-	// Changed from username to nickname
-	err := database.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE nickname = ?)", nickname).Scan(&exists)
-	if err != nil {
-		log.Printf("Error checking user existence: %v", err)
-		response["error"] = "Failed to validate user."
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
-		return
-	}
-
-	if !exists {
-		response["error"] = "User not found."
+	if utf8.RuneCountInString(content) > s.cfg.Posts.MaxContentLength {
+		response["error"] = fmt.Sprintf("Content cannot be longer than %d characters.", s.cfg.Posts.MaxContentLength)
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(response)
 		return
 	}
 
-	var lastPostTime time.Time
-	err = database.DB.QueryRow(`
-		SELECT created_at
-		FROM Posts
-		WHERE user_id = (SELECT id FROM users WHERE session_token = ?)
-		ORDER BY created_at DESC
-		LIMIT 1
-	`, sessionToken).Scan(&lastPostTime)
-
+	lastPostTime, err := s.stores.Posts.LastPostTime(session.UserID)
 	if err != nil && err != sql.ErrNoRows {
 		log.Printf("Error checking last post time: %v", err)
 		response["error"] = "Failed to validate post frequency."
@@ -305,11 +216,10 @@ func PostSubmit(w http.ResponseWriter, r *http.Request) {
 
 	if err != sql.ErrNoRows {
 		timeSinceLastPost := time.Since(lastPostTime)
-		const postCooldown = 1 * time.Second
-		if timeSinceLastPost < postCooldown {
+		if timeSinceLastPost < s.cfg.Posts.Cooldown {
 			response["error"] = fmt.Sprintf(
-				"You can only create a post every 1 second. Please wait %d seconds.",
-				int(postCooldown.Seconds()-timeSinceLastPost.Seconds()),
+				"You can only create a post every %s. Please wait %s.",
+				s.cfg.Posts.Cooldown, max((s.cfg.Posts.Cooldown-timeSinceLastPost).Round(time.Second), time.Second),
 			)
 			w.WriteHeader(http.StatusBadRequest)
 			json.NewEncoder(w).Encode(response)
@@ -317,78 +227,17 @@ func PostSubmit(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	tx, err := database.DB.Begin()
-	if err != nil {
-		log.Printf("Error starting transaction: %v", err)
-		response["error"] = "Database error during category linking."
-		w.WriteHeader(http.StatusInternalServerError)
+	var unknownCategory store.UnknownCategoryError
+	if _, err := s.stores.Posts.Create(session.UserID, title, content, categoryNames); errors.As(err, &unknownCategory) {
+		response["error"] = fmt.Sprintf("Category '%s' not found.", string(unknownCategory))
+		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(response)
 		return
-	}
-
-	insertPostQuery := `
-		INSERT INTO Posts (user_id, title, content, created_at)
-		SELECT id, ?, ?, ? FROM users WHERE session_token = ?
-	`
-	result, err := tx.Exec(insertPostQuery, title, content, time.Now(), sessionToken)
-	if err != nil {
-		log.Printf("Error inserting post: %v", err)
+	} else if err != nil {
+		log.Printf("Error submitting post: %v", err)
 		response["error"] = "Failed to submit post."
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(response)
-		tx.Rollback()
-		return
-	}
-
-	postID, err := result.LastInsertId()
-	if err != nil {
-		log.Printf("Error retrieving post ID: %v", err)
-		response["error"] = "Failed to retrieve post ID."
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
-		tx.Rollback()
-		return
-	}
-
-	for _, categoryName := range categoryNames {
-		var categoryID int
-		err := tx.QueryRow("SELECT id FROM categories WHERE name = ?", categoryName).Scan(&categoryID)
-		if err == sql.ErrNoRows {
-			response["error"] = fmt.Sprintf("Category '%s' not found.", categoryName)
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(response)
-			tx.Rollback()
-			return
-		} else if err != nil {
-			log.Printf("Error during category lookup: %v", err)
-			response["error"] = "Database error during category lookup."
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(response)
-			tx.Rollback()
-			return
-		}
-
-		insertPostCategoryQuery := `
-			INSERT INTO post_categories (post_id, category_id)
-			VALUES (?, ?)
-		`
-		_, err = tx.Exec(insertPostCategoryQuery, postID, categoryID)
-		if err != nil {
-			log.Printf("Error inserting post-category link: %v", err)
-			response["error"] = "Failed to link post with categories."
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(response)
-			tx.Rollback()
-			return
-		}
-	}
-
-	err = tx.Commit()
-	if err != nil {
-		log.Printf("Error committing transaction: %v", err)
-		response["error"] = "Failed to finalize post submission."
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
 		return
 	}
 
@@ -396,4 +245,4 @@ func PostSubmit(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
-}
\ No newline at end of file
+}
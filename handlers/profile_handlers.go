@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// Profile is the editable account information returned to its owner
+type Profile struct {
+	Nickname         string `json:"nickname"`
+	Email            string `json:"email"`
+	FirstName        string `json:"first_name"`
+	LastName         string `json:"last_name"`
+	Age              *int   `json:"age"`
+	Gender           string `json:"gender"`
+	EmailVerified    bool   `json:"email_verified"`
+	TwoFactorEnabled bool   `json:"two_factor_enabled"`
+}
+
+func (s *Server) loadProfile(userID int) (Profile, error) {
+	user, err := s.stores.Users.ByID(userID)
+	if err != nil {
+		return Profile{}, err
+	}
+	return Profile{
+		Nickname:         user.Nickname,
+		Email:            user.Email,
+		FirstName:        user.FirstName,
+		LastName:         user.LastName,
+		Age:              user.Age,
+		Gender:           user.Gender,
+		EmailVerified:    user.EmailVerified,
+		TwoFactorEnabled: user.TwoFactorEnabled,
+	}, nil
+}
+
+// ProfileHandler returns the logged-in user's profile on GET and updates their
+// first name, last name, age and gender on POST. Fields left out of the form keep their value.
+func (s *Server) ProfileHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	profile, err := s.loadProfile(session.UserID)
+	if err != nil {
+		log.Printf("Error loading profile: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		jsonResponse(w, profile)
+		return
+	case http.MethodPost:
+	default:
+		jsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		jsonError(w, http.StatusBadRequest, "Failed to parse form data")
+		return
+	}
+
+	if _, ok := r.PostForm["first_name"]; ok {
+		profile.FirstName = r.PostFormValue("first_name")
+	}
+	if _, ok := r.PostForm["last_name"]; ok {
+		profile.LastName = r.PostFormValue("last_name")
+	}
+	if _, ok := r.PostForm["gender"]; ok {
+		profile.Gender = genderFromForm(r)
+	}
+	if _, ok := r.PostForm["age"]; ok {
+		profile.Age, err = parseAge(r.PostFormValue("age"))
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, "Invalid age value")
+			return
+		}
+	}
+
+	if errors := s.validateProfile(profile.FirstName, profile.LastName, profile.Age, profile.Gender); len(errors) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  "Validation error",
+			"fields": errors,
+		})
+		return
+	}
+
+	err = s.stores.Users.UpdateProfile(session.UserID, profile.FirstName, profile.LastName, profile.Age, profile.Gender)
+	if err != nil {
+		log.Printf("Error updating profile: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Failed to update profile")
+		return
+	}
+
+	jsonResponse(w, profile)
+}
+
+// requireCurrentPassword re-authenticates the user before a credential change.
+// Wrong passwords count as failed logins so a stolen session cannot be used to guess it.
+// It writes the error response and returns false when the password is not accepted.
+func (s *Server) requireCurrentPassword(w http.ResponseWriter, r *http.Request, session *Session) bool {
+	identifierKey := identifierThrottleKey(session.Nickname)
+	ipKey := ipThrottleKey(clientIP(r))
+
+	wait, err := s.loginRetryAfter(identifierKey, ipKey)
+	if err != nil {
+		log.Printf("Error checking login throttle: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return false
+	}
+	if wait > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		writeRetryAfter(w, wait)
+		return false
+	}
+
+	valid, err := s.checkPassword(session.UserID, r.FormValue("current_password"))
+	if err != nil {
+		log.Printf("Error checking password: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return false
+	}
+	if !valid {
+		s.recordFailedLogin(identifierKey, ipKey)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  "Incorrect password",
+			"fields": map[string]string{"current_password": "Incorrect password"},
+		})
+		return false
+	}
+	return true
+}
+
+// ChangePasswordHandler sets a new password after checking the current one,
+// then logs the user out everywhere except the session making the change
+func (s *Server) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := s.requireSession(w, r)
+	if !ok || !s.requireFreshSecondFactor(w, session) || !s.requireCurrentPassword(w, r, session) {
+		return
+	}
+
+	profile, err := s.loadProfile(session.UserID)
+	if err != nil {
+		log.Printf("Error loading profile: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	password := r.FormValue("new_password")
+	if msg := s.validatePassword(password, profile.Nickname, profile.Email, profile.FirstName, profile.LastName); msg != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  "Validation error",
+			"fields": map[string]string{"new_password": msg},
+		})
+		return
+	}
+
+	hashedPassword, err := s.hashPassword(password)
+	if err != nil {
+		log.Printf("Error hashing password: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Error hashing password")
+		return
+	}
+
+	revoked, err := s.updatePassword(session, hashedPassword)
+	if err != nil {
+		log.Printf("Error changing password: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Failed to change password")
+		return
+	}
+	s.disconnectOtherSessions(session.Nickname, session.TokenHash, "Password changed")
+
+	jsonResponse(w, map[string]interface{}{
+		"message": "Password changed. Your other sessions have been logged out.",
+		"revoked": revoked,
+	})
+}
+
+// updatePassword stores the new hash and revokes the user's other sessions and
+// outstanding reset links in one transaction
+func (s *Server) updatePassword(session *Session, hashedPassword string) (int64, error) {
+	return s.stores.Users.ChangePassword(session.UserID, hashedPassword, session.TokenHash)
+}
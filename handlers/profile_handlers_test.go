@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// profile returns what /profile says about the client's account
+func (c *testClient) profile() map[string]interface{} {
+	c.t.Helper()
+	resp := c.get("/profile")
+	expectStatus(c.t, resp, http.StatusOK)
+	return decodeJSON(c.t, resp)
+}
+
+func TestProfileUpdateRoundTrip(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, nil)
+	c := ts.signUp(t, "alice")
+
+	profile := c.profile()
+	if profile["nickname"] != "alice" || profile["email"] != "alice@example.com" || profile["age"] != 30.0 || profile["gender"] != "Prefer not to say" {
+		t.Fatalf("profile after sign-up = %v", profile)
+	}
+
+	update := url.Values{
+		"first_name":    {"Alicia"},
+		"gender":        {customGenderOption},
+		"gender_custom": {" Genderqueer "},
+		"age":           {"31"},
+	}
+	resp := c.post("/profile", update)
+	expectStatus(t, resp, http.StatusOK)
+	if body := decodeJSON(t, resp); body["first_name"] != "Alicia" || body["gender"] != "Genderqueer" {
+		t.Errorf("update response = %v", body)
+	}
+
+	// The change is stored, and fields left out of the form keep their value
+	profile = c.profile()
+	for field, want := range map[string]interface{}{
+		"first_name": "Alicia",
+		"last_name":  "User",
+		"age":        31.0,
+		"gender":     "Genderqueer",
+	} {
+		if profile[field] != want {
+			t.Errorf("%s = %v, want %v", field, profile[field], want)
+		}
+	}
+
+	// A blank age removes it when age is optional
+	expectStatus(t, c.post("/profile", url.Values{"age": {""}}), http.StatusOK)
+	user, err := ts.stores.Users.ByNickname("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Age != nil || user.FirstName != "Alicia" || user.Gender != "Genderqueer" {
+		t.Errorf("stored user = age %v, first name %q, gender %q", user.Age, user.FirstName, user.Gender)
+	}
+}
+
+func TestProfileUpdateIsValidated(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, func(cfg *Config) {
+		cfg.Demographics.RequireAge = true
+		cfg.Demographics.MinAge = 13
+		cfg.Demographics.MaxCustomGender = 10
+	})
+	c := ts.signUp(t, "alice")
+
+	for _, tc := range []struct {
+		form  url.Values
+		field string
+	}{
+		{url.Values{"age": {"12"}}, "age"},
+		{url.Values{"age": {"151"}}, "age"},
+		{url.Values{"age": {""}}, "age"},
+		{url.Values{"gender": {customGenderOption}, "gender_custom": {"Much too long"}}, "gender"},
+		{url.Values{"gender": {customGenderOption}}, "gender"},
+		{url.Values{"first_name": {""}}, "first_name"},
+	} {
+		resp := c.post("/profile", tc.form)
+		expectStatus(t, resp, http.StatusBadRequest)
+		if fields, _ := decodeJSON(t, resp)["fields"].(map[string]interface{}); fields[tc.field] == nil {
+			t.Errorf("%v: no error for %s in %v", tc.form, tc.field, fields)
+		}
+	}
+	expectStatus(t, c.post("/profile", url.Values{"age": {"thirty"}}), http.StatusBadRequest)
+
+	// Nothing was stored by the refused updates
+	profile := c.profile()
+	if profile["age"] != 30.0 || profile["gender"] != "Prefer not to say" || profile["first_name"] != "Test" {
+		t.Errorf("profile after refused updates = %v", profile)
+	}
+
+	expectStatus(t, ts.client(t).post("/profile", url.Values{"age": {"40"}}), http.StatusUnauthorized)
+}
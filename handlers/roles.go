@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+
+	"forum/store"
+)
+
+// Role is the access level of an account, stored in users.role
+type Role string
+
+const (
+	RoleAdmin     Role = store.AdminRole
+	RoleModerator Role = "moderator"
+	RoleMember    Role = "member"
+)
+
+// Capability names an action that only some roles may perform
+type Capability string
+
+const (
+	CapDeleteAnyPost    Capability = "delete_any_post"
+	CapDeleteAnyComment Capability = "delete_any_comment"
+	CapManageCategories Capability = "manage_categories"
+	CapManageRoles      Capability = "manage_roles"
+	CapCreateInvites    Capability = "create_invites"
+)
+
+// roleCapabilities lists what each role may do beyond what every member can
+var roleCapabilities = map[Role][]Capability{
+	RoleAdmin:     {CapDeleteAnyPost, CapDeleteAnyComment, CapManageCategories, CapManageRoles, CapCreateInvites},
+	RoleModerator: {CapDeleteAnyPost, CapDeleteAnyComment, CapCreateInvites},
+	RoleMember:    {},
+}
+
+func (role Role) valid() bool {
+	_, ok := roleCapabilities[role]
+	return ok
+}
+
+// Can reports whether the role grants the capability
+func (role Role) Can(capability Capability) bool {
+	for _, c := range roleCapabilities[role] {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// requireCapability is like requireSession but also writes a 403 response and
+// returns false when the user's role does not grant the capability.
+func (s *Server) requireCapability(w http.ResponseWriter, r *http.Request, capability Capability) (*Session, bool) {
+	session, ok := s.requireSession(w, r)
+	if !ok {
+		return nil, false
+	}
+	if !session.Role.Can(capability) {
+		http.Error(w, "Forbidden: your role does not allow this action", http.StatusForbidden)
+		return nil, false
+	}
+	return session, true
+}
+
+// BootstrapAdmin promotes the account with the given nickname or email to admin.
+// It is how the first admin is created; later admins can be appointed from the forum.
+func (s *Server) BootstrapAdmin(identifier string) error {
+	user, err := s.stores.Users.ByLogin(identifier)
+	if err == sql.ErrNoRows {
+		if s.cfg.Registration.Mode == RegistrationInvite {
+			return fmt.Errorf("no account with nickname or email %q; register it first with a code from the invite command", identifier)
+		}
+		return fmt.Errorf("no account with nickname or email %q; register it first", identifier)
+	} else if err != nil {
+		return err
+	}
+
+	if err := s.stores.Users.SetRole(user.ID, string(RoleAdmin)); err != nil {
+		return err
+	}
+	log.Printf("%s is now an admin", user.Nickname)
+	return nil
+}
+
+// WarnIfNoAdmin logs how to create the first admin when the forum has none
+func (s *Server) WarnIfNoAdmin() {
+	admins, err := s.stores.Users.CountByRole(string(RoleAdmin))
+	if err != nil {
+		log.Printf("Error counting admins: %v", err)
+		return
+	}
+	if admins == 0 && s.cfg.Registration.Mode == RegistrationInvite {
+		log.Println("No admin account exists yet; create an invite with the invite command, register with it, then restart with -make-admin <nickname or email>")
+	} else if admins == 0 {
+		log.Println("No admin account exists yet; restart with -make-admin <nickname or email> to promote one")
+	}
+}
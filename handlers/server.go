@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"sync"
+
+	"forum/mail"
+	"forum/oidc"
+	"forum/passhash"
+	"forum/store"
+)
+
+// Deps are the services a Server works with. Either DB or Stores is required:
+// Stores default to the SQLite stores on DB, which the handlers never query
+// directly. Passwords default to PasswordPolicy(passhash.DefaultArgon2id).
+// Without a Mailer no emails are sent, and without an OIDCProvider single sign-on is off.
+type Deps struct {
+	DB     *sql.DB
+	Stores store.Stores
+	Mailer mail.Mailer
+	// OIDCProvider is the external identity provider users may sign in with
+	OIDCProvider *oidc.Provider
+	// SigningKey signs the links sent by email
+	SigningKey []byte
+	Passwords  *passhash.Policy
+	// Blocklist holds the passwords to refuse, see LoadPasswordBlocklist
+	Blocklist *PasswordBlocklist
+}
+
+// Server is one forum: its routes, its chat hub and everything the handlers
+// need. Servers share no state, so several can run in one process.
+type Server struct {
+	cfg    Config
+	stores store.Stores
+	hub    *hub
+
+	mailer     mail.Mailer
+	oidc       *oidc.Provider
+	signingKey []byte
+	passwords  *passhash.Policy
+	blocklist  *PasswordBlocklist
+	// hashSlots holds one token per password hash or check in progress
+	hashSlots chan struct{}
+	// dummyHash is checked in place of a stored hash when no account matches a
+	// login; it is made on first use
+	dummyHash     string
+	dummyHashOnce sync.Once
+
+	mux     *http.ServeMux
+	handler http.Handler
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewServer builds a server and starts its chat hub and session sweeper,
+// which run until Close
+func NewServer(cfg Config, deps Deps) *Server {
+	s := &Server{
+		cfg:        cfg,
+		stores:     deps.Stores,
+		mailer:     deps.Mailer,
+		oidc:       deps.OIDCProvider,
+		signingKey: deps.SigningKey,
+		passwords:  deps.Passwords,
+		blocklist:  deps.Blocklist,
+		mux:        http.NewServeMux(),
+		done:       make(chan struct{}),
+	}
+	if s.stores == (store.Stores{}) {
+		s.stores = store.NewSQLite(deps.DB)
+	}
+	if s.passwords == nil {
+		s.passwords = PasswordPolicy(passhash.DefaultArgon2id)
+	}
+	if s.blocklist == nil {
+		s.blocklist = &PasswordBlocklist{}
+	}
+	s.hashSlots = make(chan struct{}, max(cfg.Passwords.HashConcurrency, 1))
+	s.hub = newHub(s.checkOrigin)
+
+	s.routes()
+	s.handler = s.APITokenMiddleware(s.CSRFMiddleware(s.mux))
+
+	go s.handleMessages()
+	go s.sweepExpiredSessions()
+	return s
+}
+
+func (s *Server) routes() {
+	s.mux.Handle("/static/", http.StripPrefix("/static", http.FileServer(http.Dir(s.cfg.StaticDir))))
+	s.mux.HandleFunc("/", s.HomePage)
+	s.mux.HandleFunc("/show_posts", s.ShowPosts)
+	s.mux.HandleFunc("/post_submit", s.PostSubmit)
+	s.mux.HandleFunc("/comment_submit", s.CommentSubmit)
+	s.mux.HandleFunc("/interact", s.HandleInteract)
+	s.mux.HandleFunc("/get_categories", s.GetCategories)
+	s.mux.HandleFunc("/delete_post", s.DeletePostHandler)
+	s.mux.HandleFunc("/delete_comment", s.DeleteCommentHandler)
+	s.mux.HandleFunc("/categories/create", s.CreateCategoryHandler)
+	s.mux.HandleFunc("/categories/delete", s.DeleteCategoryHandler)
+	s.mux.HandleFunc("/admin/set_role", s.SetRoleHandler)
+	s.mux.HandleFunc("/invites", s.InvitesHandler)
+	s.mux.HandleFunc("/invites/create", s.CreateInviteHandler)
+	s.mux.HandleFunc("/invites/revoke", s.RevokeInviteHandler)
+	s.mux.HandleFunc("/login", s.LoginHandler)
+	s.mux.HandleFunc("/login/2fa", s.LoginTwoFactorHandler)
+	s.mux.HandleFunc("/oauth/login", s.OIDCLoginHandler)
+	s.mux.HandleFunc("/oauth/callback", s.OIDCCallbackHandler)
+	s.mux.HandleFunc("/2fa/setup", s.TwoFactorSetupHandler)
+	s.mux.HandleFunc("/2fa/enable", s.TwoFactorEnableHandler)
+	s.mux.HandleFunc("/2fa/verify", s.TwoFactorVerifyHandler)
+	s.mux.HandleFunc("/2fa/recovery_codes", s.TwoFactorRecoveryCodesHandler)
+	s.mux.HandleFunc("/2fa/disable", s.TwoFactorDisableHandler)
+	s.mux.HandleFunc("/check-session", s.CheckSessionHandler)
+	s.mux.HandleFunc("/logout", s.LogoutHandler)
+	s.mux.HandleFunc("/logout_all", s.LogoutAllHandler)
+	s.mux.HandleFunc("/sessions", s.SessionsHandler)
+	s.mux.HandleFunc("/sessions/revoke", s.RevokeSessionHandler)
+	s.mux.HandleFunc("/register", s.RegisterHandler)
+	s.mux.HandleFunc("/forgot_password", s.ForgotPasswordHandler)
+	s.mux.HandleFunc("/reset_password", s.ResetPasswordHandler)
+	s.mux.HandleFunc("/verify_email", s.VerifyEmailHandler)
+	s.mux.HandleFunc("/resend_verification", s.ResendVerificationHandler)
+	s.mux.HandleFunc("/change_email", s.ChangeEmailHandler)
+	s.mux.HandleFunc("/change_password", s.ChangePasswordHandler)
+	s.mux.HandleFunc("/profile", s.ProfileHandler)
+	s.mux.HandleFunc("/tokens", s.APITokensHandler)
+	s.mux.HandleFunc("/tokens/create", s.CreateAPITokenHandler)
+	s.mux.HandleFunc("/tokens/revoke", s.RevokeAPITokenHandler)
+	s.mux.HandleFunc("/get_all_users", s.GetAllUsersHandler)
+	s.mux.HandleFunc("/fetch_messages", s.FetchMessagesHandler)
+	s.mux.HandleFunc("/ws", s.HandleConnections)
+	s.mux.HandleFunc("/mark-read", s.MarkNotificationsRead)
+	s.mux.HandleFunc("/get-notifications", s.GetNotifications)
+}
+
+// hashPassword hashes a new password with the current scheme, waiting for a
+// free hash slot first
+func (s *Server) hashPassword(password string) (string, error) {
+	s.hashSlots <- struct{}{}
+	defer func() { <-s.hashSlots }()
+	return s.passwords.Hash(password)
+}
+
+// verifyPassword checks a password against a stored hash, waiting for a free
+// hash slot first; see passhash.Policy.Verify
+func (s *Server) verifyPassword(password, encoded string) (match, rehash bool, err error) {
+	s.hashSlots <- struct{}{}
+	defer func() { <-s.hashSlots }()
+	return s.passwords.Verify(password, encoded)
+}
+
+// ServeHTTP routes a request through the API token and CSRF checks to its handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Close stops the chat hub and the session sweeper and disconnects every chat
+// client. The database is left open; it belongs to the caller.
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.disconnectClients("Server shutting down", func(*Client) bool { return true })
+	})
+}
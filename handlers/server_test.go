@@ -0,0 +1,442 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"forum/database"
+	"forum/mail"
+	"forum/passhash"
+	"forum/store"
+)
+
+const testPassword = "Correct horse Battery staple 9!"
+
+func TestMain(m *testing.M) {
+	// Every test server logs its database settings, migrations and requests
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// testMailer keeps sent messages so tests can follow the links in them
+type testMailer struct {
+	mu       sync.Mutex
+	messages []mail.Message
+}
+
+func (m *testMailer) Send(msg mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+var linkPattern = regexp.MustCompile(`https?://\S+`)
+
+// lastLink returns the path and query of the last link sent to the address whose path is path
+func (m *testMailer) lastLink(t *testing.T, to, path string) string {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To != to {
+			continue
+		}
+		for _, link := range linkPattern.FindAllString(m.messages[i].Body, -1) {
+			if u, err := url.Parse(link); err == nil && u.Path == path {
+				return u.RequestURI()
+			}
+		}
+	}
+	t.Fatalf("no %s link was sent to %s", path, to)
+	return ""
+}
+
+// testServer is a Server on a fresh in-memory database, listening on a local port
+type testServer struct {
+	*Server
+	URL    string
+	stores store.Stores
+	mailer *testMailer
+}
+
+// newTestServer starts a server with the default configuration, changed by configure if not nil
+func newTestServer(t *testing.T, configure func(*Config)) *testServer {
+	t.Helper()
+	return newTestServerWith(t, configure, nil)
+}
+
+// newTestServerWith is newTestServer with further dependencies, which extend fills in
+// once the server's base URL is known
+func newTestServerWith(t *testing.T, configure func(*Config), extend func(cfg Config, deps *Deps)) *testServer {
+	t.Helper()
+	db, err := database.InitDB(database.MemoryConfig())
+	if err != nil {
+		t.Fatalf("opening test database: %v", err)
+	}
+
+	httpServer := httptest.NewUnstartedServer(nil)
+	cfg := DefaultConfig()
+	cfg.BaseURL = "http://" + httpServer.Listener.Addr().String()
+	cfg.PagesDir = "../pages"
+	cfg.StaticDir = "../static"
+	if configure != nil {
+		configure(&cfg)
+	}
+
+	ts := &testServer{URL: cfg.BaseURL, stores: store.NewSQLite(db), mailer: &testMailer{}}
+	deps := Deps{
+		Stores:     ts.stores,
+		Mailer:     ts.mailer,
+		SigningKey: []byte("test signing key"),
+		// Cheap hashes keep the tests fast; the scheme is what matters here
+		Passwords: PasswordPolicy(passhash.Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}),
+	}
+	if extend != nil {
+		extend(cfg, &deps)
+	}
+	ts.Server = NewServer(cfg, deps)
+	httpServer.Config.Handler = ts.Server
+	httpServer.Start()
+
+	t.Cleanup(func() {
+		httpServer.Close()
+		ts.Server.Close()
+		db.Close()
+	})
+	return ts
+}
+
+// testClient is a browser: it keeps cookies and sends the CSRF token with every POST
+type testClient struct {
+	t      *testing.T
+	server *testServer
+	http   *http.Client
+	// bearer, if set, is sent as an API token instead of using cookies
+	bearer string
+}
+
+func (ts *testServer) client(t *testing.T) *testClient {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testClient{t: t, server: ts, http: &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}
+}
+
+func (c *testClient) do(req *http.Request) *http.Response {
+	c.t.Helper()
+	if c.bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+c.bearer)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		c.t.Fatalf("%s %s: %v", req.Method, req.URL.Path, err)
+	}
+	c.t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func (c *testClient) get(path string) *http.Response {
+	c.t.Helper()
+	req, err := http.NewRequest(http.MethodGet, c.server.URL+path, nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return c.do(req)
+}
+
+// postWithoutCSRF submits the form without a CSRF token
+func (c *testClient) postWithoutCSRF(path string, form url.Values) *http.Response {
+	c.t.Helper()
+	req, err := http.NewRequest(http.MethodPost, c.server.URL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.do(req)
+}
+
+func (c *testClient) post(path string, form url.Values) *http.Response {
+	c.t.Helper()
+	req, err := http.NewRequest(http.MethodPost, c.server.URL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if c.bearer == "" {
+		req.Header.Set(csrfHeaderName, c.csrfToken())
+	}
+	return c.do(req)
+}
+
+// checkSession returns what /check-session says about the client
+func (c *testClient) checkSession() map[string]interface{} {
+	c.t.Helper()
+	return decodeJSON(c.t, c.get("/check-session"))
+}
+
+func (c *testClient) csrfToken() string {
+	c.t.Helper()
+	token, _ := c.checkSession()["csrfToken"].(string)
+	if token == "" {
+		c.t.Fatal("check-session returned no CSRF token")
+	}
+	return token
+}
+
+func (c *testClient) loggedIn() bool {
+	c.t.Helper()
+	loggedIn, _ := c.checkSession()["loggedIn"].(bool)
+	return loggedIn
+}
+
+func decodeJSON(t *testing.T, resp *http.Response) map[string]interface{} {
+	t.Helper()
+	var body map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("%s: decoding response (status %d): %v", resp.Request.URL.Path, resp.StatusCode, err)
+	}
+	return body
+}
+
+func expectStatus(t *testing.T, resp *http.Response, want int) {
+	t.Helper()
+	if resp.StatusCode != want {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("%s %s: status %d, want %d: %s", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, want, body)
+	}
+}
+
+func registrationForm(nickname string) url.Values {
+	return url.Values{
+		"nickname":   {nickname},
+		"email":      {nickname + "@example.com"},
+		"password":   {testPassword},
+		"first_name": {"Test"},
+		"last_name":  {"User"},
+		"age":        {"30"},
+		"gender":     {"Prefer not to say"},
+	}
+}
+
+// signUp registers, verifies and logs in a new user, returning their client
+func (ts *testServer) signUp(t *testing.T, nickname string) *testClient {
+	t.Helper()
+	c := ts.client(t)
+	expectStatus(t, c.post("/register", registrationForm(nickname)), http.StatusOK)
+	expectStatus(t, c.get(ts.mailer.lastLink(t, nickname+"@example.com", "/verify_email")), http.StatusSeeOther)
+	c.login(nickname, testPassword)
+	return c
+}
+
+// signUpUserID registers and verifies a user and returns their ID
+func (ts *testServer) signUpUserID(t *testing.T, nickname string) int {
+	t.Helper()
+	ts.signUp(t, nickname)
+	user, err := ts.stores.Users.ByNickname(nickname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.ID
+}
+
+func (c *testClient) login(identifier, password string) map[string]interface{} {
+	c.t.Helper()
+	resp := c.post("/login", url.Values{"email": {identifier}, "password": {password}})
+	expectStatus(c.t, resp, http.StatusOK)
+	return decodeJSON(c.t, resp)
+}
+
+func TestStateChangingRoutesRefuseGET(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, nil)
+	c := ts.signUp(t, "alice")
+
+	for _, path := range []string{
+		"/post_submit", "/comment_submit", "/interact", "/delete_post", "/delete_comment",
+		"/categories/create", "/categories/delete", "/admin/set_role",
+		"/invites/create", "/invites/revoke",
+		"/login", "/login/2fa", "/logout", "/logout_all", "/sessions/revoke", "/register",
+		"/2fa/setup", "/2fa/enable", "/2fa/verify", "/2fa/recovery_codes", "/2fa/disable",
+		"/forgot_password", "/resend_verification", "/change_email", "/change_password",
+		"/tokens/create", "/tokens/revoke", "/mark-read",
+	} {
+		if resp := c.get(path); resp.StatusCode != http.StatusMethodNotAllowed {
+			t.Errorf("GET %s: status %d, want %d", path, resp.StatusCode, http.StatusMethodNotAllowed)
+		}
+	}
+	if !c.loggedIn() {
+		t.Error("a GET request logged the user out")
+	}
+}
+
+func TestPOSTNeedsCSRFToken(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, nil)
+	c := ts.signUp(t, "alice")
+
+	expectStatus(t, c.postWithoutCSRF("/logout", nil), http.StatusForbidden)
+	if !c.loggedIn() {
+		t.Fatal("logout without a CSRF token ended the session")
+	}
+	expectStatus(t, c.post("/logout", nil), http.StatusSeeOther)
+	if c.loggedIn() {
+		t.Fatal("still logged in after logout")
+	}
+}
+
+// signUpAs signs up a user and gives them the role
+func (ts *testServer) signUpAs(t *testing.T, nickname string, role Role) *testClient {
+	t.Helper()
+	c := ts.signUp(t, nickname)
+	user, err := ts.stores.Users.ByNickname(nickname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.stores.Users.SetRole(user.ID, string(role)); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestRolesGateRoutes(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, nil)
+	authorID := ts.signUpUserID(t, "author")
+	clients := map[Role]*testClient{
+		RoleMember:    ts.signUpAs(t, "member", RoleMember),
+		RoleModerator: ts.signUpAs(t, "moderator", RoleModerator),
+		RoleAdmin:     ts.signUpAs(t, "admin", RoleAdmin),
+	}
+
+	// Each attempt gets its own post, comment or category, so an allowed attempt
+	// does not change what the next one finds
+	newPost := func() url.Values {
+		id, err := ts.stores.Posts.Create(authorID, "Title", "Content", []string{"Technology"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return url.Values{"post_id": {strconv.FormatInt(id, 10)}}
+	}
+	newComment := func() url.Values {
+		postID, err := ts.stores.Posts.Create(authorID, "Title", "Content", []string{"Technology"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ts.stores.Comments.Create(authorID, int(postID), "Comment"); err != nil {
+			t.Fatal(err)
+		}
+		comments, err := ts.stores.Comments.ForPost(int(postID), authorID)
+		if err != nil || len(comments) != 1 {
+			t.Fatalf("reading back the comment: %v, %v", comments, err)
+		}
+		return url.Values{"comment_id": {strconv.Itoa(comments[0].CommentID)}}
+	}
+	categories := 0
+	newCategory := func() url.Values {
+		categories++
+		name := "Category " + strconv.Itoa(categories)
+		if _, err := ts.stores.Posts.CreateCategory(name); err != nil {
+			t.Fatal(err)
+		}
+		return url.Values{"name": {name}}
+	}
+	unusedName := func() url.Values {
+		categories++
+		return url.Values{"name": {"Category " + strconv.Itoa(categories)}}
+	}
+
+	tests := []struct {
+		path    string
+		form    func() url.Values
+		allowed []Role
+	}{
+		{"/delete_post", newPost, []Role{RoleModerator, RoleAdmin}},
+		{"/delete_comment", newComment, []Role{RoleModerator, RoleAdmin}},
+		{"/invites/create", func() url.Values { return nil }, []Role{RoleModerator, RoleAdmin}},
+		{"/categories/create", unusedName, []Role{RoleAdmin}},
+		{"/categories/delete", newCategory, []Role{RoleAdmin}},
+		{"/admin/set_role", func() url.Values { return url.Values{"nickname": {"author"}, "role": {"member"}} }, []Role{RoleAdmin}},
+	}
+	for _, tt := range tests {
+		for _, role := range []Role{RoleMember, RoleModerator, RoleAdmin} {
+			want := http.StatusForbidden
+			for _, allowed := range tt.allowed {
+				if role == allowed {
+					want = http.StatusOK
+				}
+			}
+			if resp := clients[role].post(tt.path, tt.form()); resp.StatusCode != want {
+				t.Errorf("%s as %s: status %d, want %d", tt.path, role, resp.StatusCode, want)
+			}
+		}
+	}
+
+	for role, want := range map[Role]int{RoleMember: http.StatusForbidden, RoleModerator: http.StatusOK, RoleAdmin: http.StatusOK} {
+		if resp := clients[role].get("/invites"); resp.StatusCode != want {
+			t.Errorf("/invites as %s: status %d, want %d", role, resp.StatusCode, want)
+		}
+	}
+
+	// Members still manage their own content
+	author := ts.client(t)
+	author.login("author", testPassword)
+	expectStatus(t, author.post("/delete_post", newPost()), http.StatusOK)
+	expectStatus(t, author.post("/delete_comment", newComment()), http.StatusOK)
+}
+
+func TestRoleChangesApplyToExistingSessions(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, nil)
+	admin := ts.signUpAs(t, "admin", RoleAdmin)
+	bob := ts.signUp(t, "bob")
+
+	capabilities := func(c *testClient) []interface{} {
+		t.Helper()
+		list, _ := c.checkSession()["capabilities"].([]interface{})
+		return list
+	}
+	if len(capabilities(bob)) != 0 {
+		t.Fatalf("member capabilities = %v, want none", capabilities(bob))
+	}
+	expectStatus(t, bob.post("/invites/create", nil), http.StatusForbidden)
+
+	expectStatus(t, admin.post("/admin/set_role", url.Values{"nickname": {"bob"}, "role": {"moderator"}}), http.StatusOK)
+	if got := capabilities(bob); len(got) != len(roleCapabilities[RoleModerator]) {
+		t.Errorf("moderator capabilities = %v, want %v", got, roleCapabilities[RoleModerator])
+	}
+	expectStatus(t, bob.post("/invites/create", nil), http.StatusOK)
+	expectStatus(t, bob.post("/admin/set_role", url.Values{"nickname": {"bob"}, "role": {"admin"}}), http.StatusForbidden)
+
+	// Unknown roles and demoting the last admin are refused
+	expectStatus(t, admin.post("/admin/set_role", url.Values{"nickname": {"bob"}, "role": {"owner"}}), http.StatusBadRequest)
+	expectStatus(t, admin.post("/admin/set_role", url.Values{"nickname": {"admin"}, "role": {"member"}}), http.StatusConflict)
+}
+
+func TestAnonymousUsersCannotModerate(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, nil)
+	guest := ts.client(t)
+	for _, path := range []string{"/delete_post", "/delete_comment", "/categories/create", "/categories/delete", "/admin/set_role", "/invites/create"} {
+		if resp := guest.post(path, nil); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s as a guest: status %d, want %d", path, resp.StatusCode, http.StatusUnauthorized)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// SessionInfo describes one of the user's logged-in devices
+type SessionInfo struct {
+	ID         string        `json:"id"`
+	CreatedAt  time.Time     `json:"created_at"`
+	LastSeenAt time.Time     `json:"last_seen_at"`
+	ExpiresAt  time.Time     `json:"expires_at"`
+	IPAddress  string        `json:"ip_address"`
+	UserAgent  string        `json:"user_agent"`
+	Device     UserAgentInfo `json:"device"`
+	Current    bool          `json:"current"`
+}
+
+// SessionsHandler lists where the logged-in user is signed in, marking the session making the request
+func (s *Server) SessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	current, ok := s.requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	sessions, err := s.listUserSessions(current.UserID)
+	if err != nil {
+		log.Printf("Error listing sessions: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	infos := []SessionInfo{}
+	for _, session := range sessions {
+		infos = append(infos, SessionInfo{
+			ID:         sessionID(session.TokenHash),
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			ExpiresAt:  session.idleDeadline(s.cfg.Sessions.IdleTimeout),
+			IPAddress:  session.IPAddress,
+			UserAgent:  session.UserAgent,
+			Device:     parseUserAgent(session.UserAgent),
+			Current:    session.TokenHash == current.TokenHash,
+		})
+	}
+
+	jsonResponse(w, map[string]interface{}{"sessions": infos})
+}
+
+// RevokeSessionHandler logs one of the user's sessions out and closes its chat connections.
+// Revoking the current session also clears its cookie.
+func (s *Server) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	current, ok := s.requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	sessions, err := s.listUserSessions(current.UserID)
+	if err != nil {
+		log.Printf("Error listing sessions: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	id := r.FormValue("id")
+	var target *Session
+	for _, session := range sessions {
+		if sessionID(session.TokenHash) == id {
+			target = session
+			break
+		}
+	}
+	if target == nil {
+		jsonError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+
+	if err := s.deleteSession(target.TokenHash); err != nil {
+		log.Printf("Error revoking session: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Failed to revoke session")
+		return
+	}
+	s.disconnectSession(target.TokenHash, "Session revoked")
+
+	if target.TokenHash == current.TokenHash {
+		s.clearSessionCookie(w)
+	}
+	jsonResponse(w, map[string]interface{}{
+		"message": "Session revoked",
+		"current": target.TokenHash == current.TokenHash,
+	})
+}
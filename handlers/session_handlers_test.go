@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// listSessions returns the sessions /sessions reports for the client
+func (c *testClient) listSessions() []map[string]interface{} {
+	c.t.Helper()
+	resp := c.get("/sessions")
+	expectStatus(c.t, resp, http.StatusOK)
+	list, _ := decodeJSON(c.t, resp)["sessions"].([]interface{})
+	sessions := make([]map[string]interface{}, 0, len(list))
+	for _, item := range list {
+		sessions = append(sessions, item.(map[string]interface{}))
+	}
+	return sessions
+}
+
+// otherSessionID returns the ID of the one session that is not the client's own
+func (c *testClient) otherSessionID() string {
+	c.t.Helper()
+	for _, session := range c.listSessions() {
+		if current, _ := session["current"].(bool); !current {
+			return session["id"].(string)
+		}
+	}
+	c.t.Fatal("no other session listed")
+	return ""
+}
+
+func TestSessionsListsEveryDevice(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, nil)
+	laptop := ts.signUp(t, "alice")
+	phone := ts.client(t)
+	phone.login("alice", testPassword)
+	ts.signUp(t, "bob")
+
+	sessions := laptop.listSessions()
+	if len(sessions) != 2 {
+		t.Fatalf("listed %d sessions, want 2: %v", len(sessions), sessions)
+	}
+	current := 0
+	for _, session := range sessions {
+		if id, _ := session["id"].(string); id == "" {
+			t.Errorf("session without an ID: %v", session)
+		}
+		if session["ip_address"] != "127.0.0.1" {
+			t.Errorf("ip_address = %v, want 127.0.0.1", session["ip_address"])
+		}
+		if _, ok := session["device"].(map[string]interface{}); !ok {
+			t.Errorf("session without device details: %v", session)
+		}
+		if isCurrent, _ := session["current"].(bool); isCurrent {
+			current++
+		}
+	}
+	if current != 1 {
+		t.Errorf("%d sessions marked current, want 1", current)
+	}
+
+	// The ID is only a prefix of the stored digest, so it cannot look the session up
+	for _, session := range sessions {
+		if _, err := ts.stores.Sessions.ByTokenHash(session["id"].(string)); err == nil {
+			t.Errorf("session ID %v is the stored token digest", session["id"])
+		}
+	}
+
+	expectStatus(t, ts.client(t).get("/sessions"), http.StatusUnauthorized)
+}
+
+func TestRevokeAnotherSession(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, nil)
+	laptop := ts.signUp(t, "alice")
+	phone := ts.client(t)
+	phone.login("alice", testPassword)
+
+	conn, err := dialChat(phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	resp := laptop.post("/sessions/revoke", url.Values{"id": {laptop.otherSessionID()}})
+	expectStatus(t, resp, http.StatusOK)
+	if body := decodeJSON(t, resp); body["current"] != false {
+		t.Errorf("revoking the phone reported current = %v, want false", body["current"])
+	}
+
+	if phone.loggedIn() {
+		t.Error("phone still logged in after its session was revoked")
+	}
+	if !laptop.loggedIn() {
+		t.Error("revoking the phone logged the laptop out")
+	}
+	if n := len(laptop.listSessions()); n != 1 {
+		t.Errorf("%d sessions left, want 1", n)
+	}
+
+	// The phone's chat connection is closed with the reason
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		closeErr, ok := err.(*websocket.CloseError)
+		if !ok || closeErr.Code != websocket.ClosePolicyViolation || closeErr.Text != "Session revoked" {
+			t.Errorf("chat connection ended with %v, want a policy violation close saying the session was revoked", err)
+		}
+		break
+	}
+}
+
+func TestRevokeCurrentSessionClearsCookie(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, nil)
+	c := ts.signUp(t, "alice")
+
+	var id string
+	for _, session := range c.listSessions() {
+		if current, _ := session["current"].(bool); current {
+			id = session["id"].(string)
+		}
+	}
+	resp := c.post("/sessions/revoke", url.Values{"id": {id}})
+	expectStatus(t, resp, http.StatusOK)
+	if body := decodeJSON(t, resp); body["current"] != true {
+		t.Errorf("revoking this session reported current = %v, want true", body["current"])
+	}
+	if c.loggedIn() {
+		t.Error("still logged in after revoking the current session")
+	}
+}
+
+func TestRevokeRefusesOtherUsersSessions(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, nil)
+	alice := ts.signUp(t, "alice")
+	alicePhone := ts.client(t)
+	alicePhone.login("alice", testPassword)
+	bob := ts.signUp(t, "bob")
+
+	for _, id := range []string{alice.otherSessionID(), "", "not-a-session"} {
+		expectStatus(t, bob.post("/sessions/revoke", url.Values{"id": {id}}), http.StatusNotFound)
+	}
+	if !alice.loggedIn() || !alicePhone.loggedIn() {
+		t.Error("bob's revoke attempt logged alice out")
+	}
+	if !bob.loggedIn() {
+		t.Error("a refused revoke logged bob out")
+	}
+}
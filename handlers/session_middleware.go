@@ -1,58 +1,120 @@
 package handlers
 
 import (
-    "database/sql"
-    "fmt"
-    "log"
-    "net/http"
-    "time"
-    
-    "forum/database"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
 )
 
-var sessionStore = make(map[string]string)
-func RequireLogin(w http.ResponseWriter, r *http.Request) (string, string, bool, error) {
-	cookie, _ := r.Cookie("session_token")
+func (s *Server) RequireLogin(w http.ResponseWriter, r *http.Request) (string, string, bool, error) {
+	session, err := s.currentSession(w, r)
+	if session == nil {
+		return "", "guest", false, err
+	}
+	return session.Nickname, session.Token, true, nil
+}
+
+// currentSession resolves and renews the session behind the request's cookie, or
+// the API token session set up by APITokenMiddleware.
+// It returns a nil session for guests; sql.ErrNoRows means the cookie named an
+// unknown or expired session, any other error has already been answered with a 500.
+func (s *Server) currentSession(w http.ResponseWriter, r *http.Request) (*Session, error) {
+	if session := apiTokenSession(r); session != nil {
+		return session, nil
+	}
+
+	cookie, _ := r.Cookie(sessionCookieName)
 	if cookie == nil {
-		return "", "guest", false, nil
+		return nil, nil
 	}
 
-	var nickname, sessionToken string
-	err := database.DB.QueryRow(
-		"SELECT nickname, session_token FROM users WHERE session_token = ?", 
-		cookie.Value,
-	).Scan(&nickname, &sessionToken)
-	
+	session, err := s.lookupSession(cookie.Value)
 	if err == sql.ErrNoRows {
-		for _, cookie := range r.Cookies() {
-			http.SetCookie(w, &http.Cookie{
-				Name:    cookie.Name,
-				Value:   "",
-				Expires: time.Now().Add(-1 * time.Hour),
-			})
-		}
-		return "", "guest", false, err
+		s.clearSessionCookie(w)
+		return nil, err
 	} else if err != nil {
 		log.Printf("Database error: %v", err)
 		http.Error(w, "Internal server error.", http.StatusInternalServerError)
-		return "", "guest", false, err
+		return nil, err
+	}
+
+	renewed, err := s.touchSession(session)
+	if err != nil {
+		log.Printf("Error renewing session: %v", err)
+	} else if renewed {
+		s.setSessionCookie(w, session)
+	}
+
+	return session, nil
+}
+
+// requireSession is like currentSession but writes a 401 response and returns
+// false when there is no valid session.
+func (s *Server) requireSession(w http.ResponseWriter, r *http.Request) (*Session, bool) {
+	session, err := s.currentSession(w, r)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, false
+	}
+	if session == nil {
+		http.Error(w, "Unauthorized: User is not logged in", http.StatusUnauthorized)
+		return nil, false
+	}
+	return session, true
+}
+
+// requireUser resolves the logged-in user for endpoints that act on their own data.
+// It writes a 401 response and returns false when there is no valid session.
+func (s *Server) requireUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	session, ok := s.requireSession(w, r)
+	if !ok {
+		return "", false
 	}
+	return session.Nickname, true
+}
 
-	return nickname, sessionToken, true, nil
+// actingAsOtherUser reports whether a client-supplied nickname names someone
+// other than the logged-in user. An empty value means the caller did not claim one.
+func actingAsOtherUser(claimed, nickname string) bool {
+	return claimed != "" && claimed != nickname
 }
 
-func CheckSessionHandler(w http.ResponseWriter, r *http.Request) {
-	_, _, loggedIn, err := RequireLogin(w, r)
+func (s *Server) CheckSessionHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := s.currentSession(w, r)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error checking session: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	emailVerified := false
+	role := Role("")
+	capabilities := []Capability{}
+	if session != nil {
+		role = session.Role
+		capabilities = append(capabilities, roleCapabilities[role]...)
+		emailVerified, err = s.isEmailVerified(session.Nickname)
+		if err != nil {
+			log.Printf("Error checking email verification: %v", err)
+			http.Error(w, "Internal server error.", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	csrfToken, err := s.csrfTokenFor(w, r)
 	if err != nil {
-		fmt.Println("Error in RequiredLogin:", err)
+		log.Printf("Error issuing CSRF token: %v", err)
+		http.Error(w, "Internal server error.", http.StatusInternalServerError)
 		return
 	}
+
 	w.Header().Set("Content-Type", "application/json")
-	if loggedIn {
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintln(w, `{"loggedIn": true}`)
-	} else {
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintln(w, `{"loggedIn": false}`)
-	}
-}
\ No newline at end of file
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"loggedIn":      session != nil,
+		"emailVerified": emailVerified,
+		"csrfToken":     csrfToken,
+		"role":          role,
+		"capabilities":  capabilities,
+	})
+}
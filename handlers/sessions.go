@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"forum/models"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+const sessionCookieName = "session_token"
+
+// SessionConfig controls how long sessions stay valid on the server.
+type SessionConfig struct {
+	// AbsoluteTimeout is the maximum lifetime of a session, regardless of activity
+	AbsoluteTimeout time.Duration
+	// IdleTimeout ends a session that has seen no requests for this long
+	IdleTimeout time.Duration
+	// RenewInterval limits how often activity is written back to the database
+	RenewInterval time.Duration
+	// SweepInterval is how often expired sessions are purged
+	SweepInterval time.Duration
+}
+
+// defaultSessions are the session timeouts used unless configured otherwise
+var defaultSessions = SessionConfig{
+	AbsoluteTimeout: 7 * 24 * time.Hour,
+	IdleTimeout:     1 * time.Hour,
+	RenewInterval:   1 * time.Minute,
+	SweepInterval:   10 * time.Minute,
+}
+
+// Session is one logged-in device of a user.
+type Session struct {
+	// Token is the secret held in the session cookie. Only its digest, TokenHash,
+	// is stored, so sessions loaded by user rather than by cookie have no Token.
+	Token      string
+	TokenHash  string
+	UserID     int
+	Nickname   string
+	Role       Role
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	LastSeenAt time.Time
+	UserAgent  string
+	IPAddress  string
+	CSRFToken  string
+	// MFAVerifiedAt is when the second factor was last presented on this session;
+	// zero if it never was
+	MFAVerifiedAt time.Time
+	// APITokenID is set when the request authenticated with a personal access
+	// token rather than a session cookie; Scopes then limits what it may do
+	APITokenID int64
+	Scopes     []TokenScope
+}
+
+// createSession stores a new session for the user and returns it
+func (s *Server) createSession(userID int, r *http.Request) (*Session, error) {
+	token, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	csrfToken, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	session := &Session{
+		Token:      token.String(),
+		TokenHash:  hashToken(token.String()),
+		UserID:     userID,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(s.cfg.Sessions.AbsoluteTimeout),
+		LastSeenAt: now,
+		UserAgent:  r.UserAgent(),
+		IPAddress:  clientIP(r),
+		CSRFToken:  csrfToken,
+	}
+
+	if err := s.stores.Sessions.Create(session.record()); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// lookupSession resolves a session token to its session and user.
+// Sessions past their absolute or idle timeout are deleted and reported as sql.ErrNoRows.
+func (s *Server) lookupSession(token string) (*Session, error) {
+	record, err := s.stores.Sessions.ByTokenHash(hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	session := sessionFromRecord(record)
+	session.Token = token
+
+	if session.expired(time.Now(), s.cfg.Sessions.IdleTimeout) {
+		if err := s.deleteSession(session.TokenHash); err != nil {
+			log.Printf("Error deleting expired session: %v", err)
+		}
+		return nil, sql.ErrNoRows
+	}
+
+	// Sessions created before CSRF protection existed get a token on first use
+	if session.CSRFToken == "" {
+		csrfToken, err := randomToken(32)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.stores.Sessions.SetCSRFToken(session.TokenHash, csrfToken); err != nil {
+			return nil, err
+		}
+		session.CSRFToken = csrfToken
+	}
+	return session, nil
+}
+
+// sessionFromRecord turns a stored session into one the handlers work with
+func sessionFromRecord(record *models.Session) *Session {
+	return &Session{
+		TokenHash:     record.TokenHash,
+		UserID:        record.UserID,
+		Nickname:      record.Nickname,
+		Role:          Role(record.Role),
+		CreatedAt:     record.CreatedAt,
+		ExpiresAt:     record.ExpiresAt,
+		LastSeenAt:    record.LastSeenAt,
+		UserAgent:     record.UserAgent,
+		IPAddress:     record.IPAddress,
+		CSRFToken:     record.CSRFToken,
+		MFAVerifiedAt: record.MFAVerifiedAt,
+	}
+}
+
+// record is the part of the session that is stored
+func (s *Session) record() *models.Session {
+	return &models.Session{
+		TokenHash:     s.TokenHash,
+		UserID:        s.UserID,
+		Nickname:      s.Nickname,
+		Role:          string(s.Role),
+		CreatedAt:     s.CreatedAt,
+		ExpiresAt:     s.ExpiresAt,
+		LastSeenAt:    s.LastSeenAt,
+		UserAgent:     s.UserAgent,
+		IPAddress:     s.IPAddress,
+		CSRFToken:     s.CSRFToken,
+		MFAVerifiedAt: s.MFAVerifiedAt,
+	}
+}
+
+func (s *Session) expired(now time.Time, idleTimeout time.Duration) bool {
+	return !now.Before(s.ExpiresAt) || !now.Before(s.LastSeenAt.Add(idleTimeout))
+}
+
+// idleDeadline is when the session ends if no further activity is seen,
+// capped by its absolute expiry
+func (s *Session) idleDeadline(idleTimeout time.Duration) time.Time {
+	deadline := s.LastSeenAt.Add(idleTimeout)
+	if deadline.After(s.ExpiresAt) {
+		return s.ExpiresAt
+	}
+	return deadline
+}
+
+// touchSession records activity on the session, sliding its idle deadline forward.
+// Writes are throttled to one per RenewInterval.
+func (s *Server) touchSession(session *Session) (bool, error) {
+	now := time.Now().UTC()
+	if now.Sub(session.LastSeenAt) < s.cfg.Sessions.RenewInterval {
+		return false, nil
+	}
+
+	if err := s.stores.Sessions.Touch(session.TokenHash, now); err != nil {
+		return false, err
+	}
+	session.LastSeenAt = now
+	return true, nil
+}
+
+// markSecondFactor records that the second factor was just presented on the session
+func (s *Server) markSecondFactor(session *Session) error {
+	now := time.Now().UTC()
+	if err := s.stores.Sessions.MarkSecondFactor(session.TokenHash, now); err != nil {
+		return err
+	}
+	session.MFAVerifiedAt = now
+	return nil
+}
+
+// deleteSession removes a single session, leaving the user's other devices logged in
+func (s *Server) deleteSession(tokenHash string) error {
+	return s.stores.Sessions.Delete(tokenHash)
+}
+
+// sessionID is the public identifier of a session. It is a prefix of the token
+// digest so that sessions can be listed and revoked without exposing the token.
+func sessionID(tokenHash string) string {
+	return tokenHash[:16]
+}
+
+// listUserSessions returns the user's sessions that have not yet expired, most recently active first
+func (s *Server) listUserSessions(userID int) ([]*Session, error) {
+	records, err := s.stores.Sessions.ListByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var sessions []*Session
+	for _, record := range records {
+		session := sessionFromRecord(record)
+		if !session.expired(now, s.cfg.Sessions.IdleTimeout) {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+// deleteUserSessions removes every session of a user, logging them out on all devices
+func (s *Server) deleteUserSessions(userID int) (int64, error) {
+	return s.stores.Sessions.DeleteByUser(userID)
+}
+
+// deleteExpiredSessions purges every session past its absolute or idle timeout
+func (s *Server) deleteExpiredSessions() (int64, error) {
+	now := time.Now().UTC()
+	return s.stores.Sessions.DeleteExpired(now, now.Add(-s.cfg.Sessions.IdleTimeout))
+}
+
+// sweepExpiredSessions periodically removes expired sessions from the database
+// until the server is closed
+func (s *Server) sweepExpiredSessions() {
+	ticker := time.NewTicker(s.cfg.Sessions.SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		purged, err := s.deleteExpiredSessions()
+		if err != nil {
+			log.Printf("Error sweeping expired sessions: %v", err)
+			continue
+		}
+		if purged > 0 {
+			log.Printf("Purged %d expired sessions", purged)
+		}
+	}
+}
+
+func (s *Server) setSessionCookie(w http.ResponseWriter, session *Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    session.Token,
+		Path:     "/",
+		Expires:  session.idleDeadline(s.cfg.Sessions.IdleTimeout),
+		HttpOnly: true,
+		Secure:   s.cfg.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (s *Server) clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Now().Add(-1 * time.Hour),
+		HttpOnly: true,
+		Secure:   s.cfg.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// clientIP returns the remote address of the request without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
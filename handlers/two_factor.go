@@ -0,0 +1,414 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"forum/totp"
+)
+
+// TwoFactorConfig controls TOTP enrolment and the second login step.
+type TwoFactorConfig struct {
+	// Issuer is the account label shown in authenticator apps
+	Issuer string
+	// ChallengeTTL is how long a user has to enter their code after the password step
+	ChallengeTTL time.Duration
+	// MaxChallengeAttempts is how many wrong codes a single challenge accepts
+	MaxChallengeAttempts int
+	// FreshWindow is how recently the second factor must have been presented for sensitive actions
+	FreshWindow time.Duration
+	// RecoveryCodes is how many one-time recovery codes are issued
+	RecoveryCodes int
+}
+
+// defaultTwoFactor are the two-factor settings used unless configured otherwise
+var defaultTwoFactor = TwoFactorConfig{
+	Issuer:               "Forum",
+	ChallengeTTL:         5 * time.Minute,
+	MaxChallengeAttempts: 5,
+	FreshWindow:          10 * time.Minute,
+	RecoveryCodes:        10,
+}
+
+// createMFAChallenge records that the user passed the password step and returns
+// the token that must accompany their second factor
+func (s *Server) createMFAChallenge(userID int) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	// Stale challenges are dropped so the table does not grow with abandoned logins
+	now := time.Now().UTC()
+	if err := s.stores.TwoFactor.CreateChallenge(hashToken(token), userID, now, now.Add(s.cfg.TwoFactor.ChallengeTTL)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// verifySecondFactor checks a TOTP code or, failing that, a one-time recovery code.
+// Accepted codes are consumed so they cannot be replayed.
+func (s *Server) verifySecondFactor(userID int, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		secret, lastStep, err := s.stores.Users.TOTP(userID)
+		if err != nil {
+			return false, err
+		}
+
+		step, ok := totp.Validate(secret, code, time.Now())
+		if !ok || step <= lastStep {
+			return false, nil
+		}
+
+		return s.stores.Users.AdvanceTOTPStep(userID, step)
+	}
+
+	if recoveryCode != "" {
+		return s.stores.TwoFactor.UseRecoveryCode(userID, hashToken(normalizeRecoveryCode(recoveryCode)), time.Now().UTC())
+	}
+
+	return false, nil
+}
+
+// secondFactorAllowed returns the throttle keys for a code entered on a signed-in
+// session, or writes 429 and returns false while the user or client is locked out.
+// Wrong codes count as failed logins, so guessing them is throttled like passwords.
+func (s *Server) secondFactorAllowed(w http.ResponseWriter, r *http.Request, nickname string) (string, string, bool) {
+	identifierKey := identifierThrottleKey(nickname)
+	ipKey := ipThrottleKey(clientIP(r))
+	wait, err := s.loginRetryAfter(identifierKey, ipKey)
+	if err != nil {
+		log.Printf("Error checking login throttle: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return "", "", false
+	}
+	if wait > 0 {
+		writeRetryAfter(w, wait)
+		return "", "", false
+	}
+	return identifierKey, ipKey, true
+}
+
+// newRecoveryCodes replaces the user's recovery codes and returns the new plain-text codes
+func (s *Server) newRecoveryCodes(userID int) ([]string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, 0, s.cfg.TwoFactor.RecoveryCodes)
+	codeHashes := make([]string, 0, s.cfg.TwoFactor.RecoveryCodes)
+	for i := 0; i < s.cfg.TwoFactor.RecoveryCodes; i++ {
+		buf := make([]byte, 7)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(encoding.EncodeToString(buf))[:10]
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		codeHashes = append(codeHashes, hashToken(raw))
+	}
+
+	if err := s.stores.TwoFactor.ReplaceRecoveryCodes(userID, codeHashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode accepts codes typed with any case, spaces or dashes
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// requireFreshSecondFactor guards sensitive actions: users with two-factor enabled must
+// have presented their second factor on this session within FreshWindow. It writes a
+// 403 response and returns false otherwise.
+func (s *Server) requireFreshSecondFactor(w http.ResponseWriter, session *Session) bool {
+	user, err := s.stores.Users.ByID(session.UserID)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return false
+	}
+	if !user.TwoFactorEnabled || time.Since(session.MFAVerifiedAt) <= s.cfg.TwoFactor.FreshWindow {
+		return true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":                "Please confirm this action with your authenticator code",
+		"secondFactorRequired": true,
+	})
+	return false
+}
+
+// LoginTwoFactorHandler completes a login that is waiting for the second factor
+func (s *Server) LoginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		jsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	challengeHash := hashToken(r.FormValue("challenge"))
+
+	challenge, err := s.stores.TwoFactor.Challenge(challengeHash)
+	if err == sql.ErrNoRows || (err == nil && !time.Now().Before(challenge.ExpiresAt)) {
+		jsonError(w, http.StatusUnauthorized, "Your login has expired. Please enter your password again.")
+		return
+	} else if err != nil {
+		log.Printf("Database error: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	userID, nickname := challenge.UserID, challenge.Nickname
+	identifierKey := identifierThrottleKey(nickname)
+	ipKey := ipThrottleKey(clientIP(r))
+	wait, err := s.loginRetryAfter(identifierKey, ipKey)
+	if err != nil {
+		log.Printf("Error checking login throttle: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if wait > 0 {
+		writeRetryAfter(w, wait)
+		return
+	}
+
+	ok, err := s.verifySecondFactor(userID, r.FormValue("code"), r.FormValue("recovery_code"))
+	if err != nil {
+		log.Printf("Error verifying second factor: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if !ok {
+		s.recordFailedLogin(identifierKey, ipKey)
+		if challenge.Attempts+1 >= s.cfg.TwoFactor.MaxChallengeAttempts {
+			s.stores.TwoFactor.DeleteChallenge(challengeHash)
+		} else {
+			s.stores.TwoFactor.AddChallengeAttempt(challengeHash)
+		}
+		jsonError(w, http.StatusUnauthorized, "Invalid authentication code")
+		return
+	}
+
+	if err := s.stores.TwoFactor.DeleteChallenge(challengeHash); err != nil {
+		log.Printf("Error deleting two-factor challenge: %v", err)
+	}
+
+	s.completeLogin(w, r, userID, nickname, true)
+}
+
+// TwoFactorSetupHandler generates a new TOTP secret for the logged-in user.
+// Two-factor stays off until the user proves their app works via TwoFactorEnableHandler.
+func (s *Server) TwoFactorSetupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := s.requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := s.stores.Users.ByID(session.UserID)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if user.TwoFactorEnabled {
+		jsonError(w, http.StatusBadRequest, "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		log.Printf("Error generating totp secret: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if err := s.stores.Users.SetTOTPSecret(session.UserID, secret); err != nil {
+		log.Printf("Error storing totp secret: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	jsonResponse(w, map[string]string{
+		"secret": secret,
+		"uri":    totp.URI(s.cfg.TwoFactor.Issuer, session.Nickname, secret),
+	})
+}
+
+// TwoFactorEnableHandler turns two-factor on once the user submits a valid code for
+// the secret from TwoFactorSetupHandler, and returns their recovery codes
+func (s *Server) TwoFactorEnableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := s.requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := s.stores.Users.ByID(session.UserID)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if user.TwoFactorEnabled {
+		jsonError(w, http.StatusBadRequest, "Two-factor authentication is already enabled")
+		return
+	}
+	if secret, _, err := s.stores.Users.TOTP(session.UserID); err != nil {
+		log.Printf("Database error: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	} else if secret == "" {
+		jsonError(w, http.StatusBadRequest, "Start two-factor setup first")
+		return
+	}
+
+	identifierKey, ipKey, allowed := s.secondFactorAllowed(w, r, session.Nickname)
+	if !allowed {
+		return
+	}
+
+	valid, err := s.verifySecondFactor(session.UserID, r.FormValue("code"), "")
+	if err != nil {
+		log.Printf("Error verifying second factor: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if !valid {
+		s.recordFailedLogin(identifierKey, ipKey)
+		jsonError(w, http.StatusBadRequest, "Invalid authentication code")
+		return
+	}
+
+	codes, err := s.newRecoveryCodes(session.UserID)
+	if err != nil {
+		log.Printf("Error generating recovery codes: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if err := s.stores.Users.EnableTwoFactor(session.UserID); err != nil {
+		log.Printf("Error enabling two-factor: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if err := s.markSecondFactor(session); err != nil {
+		log.Printf("Error updating session: %v", err)
+	}
+
+	jsonResponse(w, map[string]interface{}{
+		"message":       "Two-factor authentication enabled. Store these recovery codes somewhere safe; each works once.",
+		"recoveryCodes": codes,
+	})
+}
+
+// TwoFactorVerifyHandler accepts the second factor on an existing session, so the
+// user can go on with an action guarded by requireFreshSecondFactor
+func (s *Server) TwoFactorVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := s.requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	identifierKey, ipKey, allowed := s.secondFactorAllowed(w, r, session.Nickname)
+	if !allowed {
+		return
+	}
+
+	valid, err := s.verifySecondFactor(session.UserID, r.FormValue("code"), r.FormValue("recovery_code"))
+	if err != nil {
+		log.Printf("Error verifying second factor: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if !valid {
+		s.recordFailedLogin(identifierKey, ipKey)
+		jsonError(w, http.StatusUnauthorized, "Invalid authentication code")
+		return
+	}
+
+	if err := s.markSecondFactor(session); err != nil {
+		log.Printf("Error updating session: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	jsonResponse(w, map[string]string{"message": "Authentication code accepted"})
+}
+
+// TwoFactorRecoveryCodesHandler replaces the user's recovery codes with a fresh set
+func (s *Server) TwoFactorRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := s.requireSession(w, r)
+	if !ok || !s.requireFreshSecondFactor(w, session) {
+		return
+	}
+
+	codes, err := s.newRecoveryCodes(session.UserID)
+	if err != nil {
+		log.Printf("Error generating recovery codes: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	jsonResponse(w, map[string]interface{}{"recoveryCodes": codes})
+}
+
+// TwoFactorDisableHandler turns two-factor off; it needs the password and a fresh second factor
+func (s *Server) TwoFactorDisableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	session, ok := s.requireSession(w, r)
+	if !ok || !s.requireFreshSecondFactor(w, session) {
+		return
+	}
+
+	valid, err := s.checkPassword(session.UserID, r.FormValue("password"))
+	if err != nil {
+		log.Printf("Error checking password: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if !valid {
+		jsonError(w, http.StatusUnauthorized, "Incorrect password")
+		return
+	}
+
+	err = s.stores.Users.DisableTwoFactor(session.UserID)
+	if err == nil {
+		err = s.stores.TwoFactor.DeleteRecoveryCodes(session.UserID)
+	}
+	if err != nil {
+		log.Printf("Error disabling two-factor: %v", err)
+		jsonError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	jsonResponse(w, map[string]string{"message": "Two-factor authentication disabled"})
+}
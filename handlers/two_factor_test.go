@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"forum/totp"
+)
+
+// enableTwoFactor turns two-factor on for the client's user and returns the
+// TOTP secret, the step of the code used and the recovery codes
+func enableTwoFactor(t *testing.T, c *testClient) (string, int64, []string) {
+	t.Helper()
+	resp := c.post("/2fa/setup", nil)
+	expectStatus(t, resp, http.StatusOK)
+	secret, _ := decodeJSON(t, resp)["secret"].(string)
+
+	step := totp.Step(time.Now())
+	code, err := totp.Code(secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = c.post("/2fa/enable", url.Values{"code": {code}})
+	expectStatus(t, resp, http.StatusOK)
+
+	var recoveryCodes []string
+	for _, code := range decodeJSON(t, resp)["recoveryCodes"].([]interface{}) {
+		recoveryCodes = append(recoveryCodes, code.(string))
+	}
+	return secret, step, recoveryCodes
+}
+
+// startTwoFactorLogin passes the password step and returns the challenge
+func startTwoFactorLogin(t *testing.T, c *testClient, nickname string) string {
+	t.Helper()
+	body := c.login(nickname, testPassword)
+	challenge, _ := body["challenge"].(string)
+	if body["twoFactorRequired"] != true || challenge == "" {
+		t.Fatalf("login with two-factor enabled = %v, want a challenge", body)
+	}
+	if c.loggedIn() {
+		t.Fatal("the password alone started a session")
+	}
+	return challenge
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, nil)
+	secret, step, _ := enableTwoFactor(t, ts.signUp(t, "alice"))
+
+	c := ts.client(t)
+	challenge := startTwoFactorLogin(t, c, "alice")
+
+	// The code that enabled two-factor cannot be used again
+	used, _ := totp.Code(secret, step)
+	expectStatus(t, c.post("/login/2fa", url.Values{"challenge": {challenge}, "code": {used}}), http.StatusUnauthorized)
+
+	next, _ := totp.Code(secret, step+1)
+	expectStatus(t, c.post("/login/2fa", url.Values{"challenge": {challenge}, "code": {next}}), http.StatusOK)
+	if !c.loggedIn() {
+		t.Fatal("not logged in after the second factor")
+	}
+
+	// The challenge is used up with the login
+	other := ts.client(t)
+	expectStatus(t, other.post("/login/2fa", url.Values{"challenge": {challenge}, "code": {next}}), http.StatusUnauthorized)
+}
+
+func TestRecoveryCodesWorkOnce(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, nil)
+	_, _, recoveryCodes := enableTwoFactor(t, ts.signUp(t, "alice"))
+	if len(recoveryCodes) != ts.cfg.TwoFactor.RecoveryCodes {
+		t.Fatalf("got %d recovery codes, want %d", len(recoveryCodes), ts.cfg.TwoFactor.RecoveryCodes)
+	}
+
+	c := ts.client(t)
+	challenge := startTwoFactorLogin(t, c, "alice")
+	expectStatus(t, c.post("/login/2fa", url.Values{"challenge": {challenge}, "recovery_code": {recoveryCodes[0]}}), http.StatusOK)
+
+	c = ts.client(t)
+	challenge = startTwoFactorLogin(t, c, "alice")
+	expectStatus(t, c.post("/login/2fa", url.Values{"challenge": {challenge}, "recovery_code": {recoveryCodes[0]}}), http.StatusUnauthorized)
+}
+
+func TestChallengeEndsAfterTooManyWrongCodes(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, func(cfg *Config) {
+		cfg.TwoFactor.MaxChallengeAttempts = 2
+		// Keep the throttle out of the way
+		cfg.LoginThrottle.FreeAttempts = 100
+		cfg.LoginThrottle.LockoutThreshold = 100
+	})
+	secret, step, _ := enableTwoFactor(t, ts.signUp(t, "alice"))
+
+	c := ts.client(t)
+	challenge := startTwoFactorLogin(t, c, "alice")
+	for i := 0; i < 2; i++ {
+		expectStatus(t, c.post("/login/2fa", url.Values{"challenge": {challenge}, "code": {"000000"}}), http.StatusUnauthorized)
+	}
+	next, _ := totp.Code(secret, step+1)
+	expectStatus(t, c.post("/login/2fa", url.Values{"challenge": {challenge}, "code": {next}}), http.StatusUnauthorized)
+}
+
+func TestSecondFactorOnSessionIsThrottled(t *testing.T) {
+	t.Parallel()
+	ts := newTestServer(t, func(cfg *Config) {
+		cfg.LoginThrottle.FreeAttempts = 2
+		cfg.LoginThrottle.LockoutThreshold = 2
+	})
+
+	// Someone with a stolen password session guessing codes for a fresh second factor
+	alice := ts.signUp(t, "alice")
+	secret, step, _ := enableTwoFactor(t, alice)
+	for i := 0; i < 2; i++ {
+		expectStatus(t, alice.post("/2fa/verify", url.Values{"code": {"000000"}}), http.StatusUnauthorized)
+	}
+	next, _ := totp.Code(secret, step+1)
+	resp := alice.post("/2fa/verify", url.Values{"code": {next}})
+	expectStatus(t, resp, http.StatusTooManyRequests)
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("429 without Retry-After")
+	}
+
+	// Enrolment checks codes the same way
+	bob := ts.signUp(t, "bob")
+	resp = bob.post("/2fa/setup", nil)
+	expectStatus(t, resp, http.StatusOK)
+	secret, _ = decodeJSON(t, resp)["secret"].(string)
+	for i := 0; i < 2; i++ {
+		expectStatus(t, bob.post("/2fa/enable", url.Values{"code": {"000000"}}), http.StatusBadRequest)
+	}
+	code, _ := totp.Code(secret, totp.Step(time.Now()))
+	expectStatus(t, bob.post("/2fa/enable", url.Values{"code": {code}}), http.StatusTooManyRequests)
+}
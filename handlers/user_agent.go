@@ -0,0 +1,81 @@
+package handlers
+
+import "strings"
+
+// UserAgentInfo is a coarse, human-readable summary of a User-Agent header
+type UserAgentInfo struct {
+	Browser string `json:"browser"`
+	OS      string `json:"os"`
+	Device  string `json:"device"`
+}
+
+// userAgentBrowsers is checked in order: most browsers also claim to be
+// Chrome and Safari, so the more specific tokens must come first
+var userAgentBrowsers = []struct {
+	token, name string
+}{
+	{"Edg/", "Edge"},
+	{"OPR/", "Opera"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"Firefox/", "Firefox"},
+	{"FxiOS/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"Version/", "Safari"},
+	{"curl/", "curl"},
+	{"python-requests/", "Python requests"},
+	{"Go-http-client/", "Go HTTP client"},
+}
+
+// parseUserAgent recognises the common browsers and operating systems; anything
+// else is reported as "Unknown" rather than guessed at
+func parseUserAgent(ua string) UserAgentInfo {
+	info := UserAgentInfo{Browser: "Unknown", OS: "Unknown", Device: "Desktop"}
+
+	for _, browser := range userAgentBrowsers {
+		if i := strings.Index(ua, browser.token); i >= 0 {
+			info.Browser = browser.name
+			if version := majorVersion(ua[i+len(browser.token):]); version != "" {
+				info.Browser += " " + version
+			}
+			break
+		}
+	}
+
+	switch {
+	case strings.Contains(ua, "Windows"):
+		info.OS = "Windows"
+	case strings.Contains(ua, "iPhone"):
+		info.OS = "iOS"
+	case strings.Contains(ua, "iPad"):
+		info.OS = "iPadOS"
+	case strings.Contains(ua, "Android"):
+		info.OS = "Android"
+	case strings.Contains(ua, "CrOS"):
+		info.OS = "ChromeOS"
+	case strings.Contains(ua, "Mac OS X"), strings.Contains(ua, "Macintosh"):
+		info.OS = "macOS"
+	case strings.Contains(ua, "Linux"):
+		info.OS = "Linux"
+	}
+
+	switch {
+	case ua == "" || !strings.Contains(ua, "Mozilla/"):
+		info.Device = "Other"
+	case strings.Contains(ua, "iPad"), strings.Contains(ua, "Tablet"),
+		info.OS == "Android" && !strings.Contains(ua, "Mobile"):
+		info.Device = "Tablet"
+	case strings.Contains(ua, "Mobile"), strings.Contains(ua, "iPhone"):
+		info.Device = "Mobile"
+	}
+	return info
+}
+
+// majorVersion returns the leading number of a version string such as "120.0.1"
+func majorVersion(version string) string {
+	end := 0
+	for end < len(version) && version[end] >= '0' && version[end] <= '9' {
+		end++
+	}
+	return version[:end]
+}
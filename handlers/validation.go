@@ -1,87 +1,149 @@
 package handlers
 
 import (
-    "fmt"
-    "log"
-    "regexp"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"unicode/utf8"
 )
 
-func ValidateInput(nickname, email, password, firstName, lastName string, age int, gender string) (map[string]string, bool) {
+func (s *Server) ValidateInput(nickname, email, password, firstName, lastName string, age *int, gender string) (map[string]string, bool) {
 	errors := make(map[string]string)
 	const maxNickname = 50
-	const maxEmail = 100
-	const maxPassword = 100
-	const maxFirstName = 50
-	const maxLastName = 50
 
 	// Nickname validation
 	if len(nickname) == 0 {
 		errors["nickname"] = "Nickname cannot be empty"
-	} else if len(nickname) > maxNickname {
+	} else if utf8.RuneCountInString(nickname) > maxNickname {
 		errors["nickname"] = fmt.Sprintf("Nickname cannot be longer than %d characters", maxNickname)
 	}
 
 	// Email validation
-	emailRegex := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
-	if len(email) == 0 {
-		errors["email"] = "Email cannot be empty"
-	} else if len(email) > maxEmail {
-		errors["email"] = fmt.Sprintf("Email cannot be longer than %d characters", maxEmail)
-	} else if !emailRegex.MatchString(email) {
-		errors["email"] = "Invalid email format"
+	if msg := validateEmail(email); msg != "" {
+		errors["email"] = msg
 	}
 
 	// Password validation
-	if len(password) < 8 {
-		errors["password"] = "Password must be at least 8 characters long"
-	} else if len(password) > maxPassword {
-		errors["password"] = fmt.Sprintf("Password cannot be longer than %d characters", maxPassword)
-	} else {
-		hasUpper := regexp.MustCompile(`[A-Z]`).MatchString(password)
-		hasLower := regexp.MustCompile(`[a-z]`).MatchString(password)
-		hasDigit := regexp.MustCompile(`[0-9]`).MatchString(password)
-		hasSpecial := regexp.MustCompile(`[\W_]`).MatchString(password)
-
-		if !hasUpper {
-			errors["password"] = "Password must include at least one uppercase letter"
-		}
-		if !hasLower {
-			errors["password"] = "Password must include at least one lowercase letter"
-		}
-		if !hasDigit {
-			errors["password"] = "Password must include at least one digit"
-		}
-		if !hasSpecial {
-			errors["password"] = "Password must include at least one special character"
-		}
+	if msg := s.validatePassword(password, nickname, email, firstName, lastName); msg != "" {
+		errors["password"] = msg
+	}
+
+	// Name, age and gender validation
+	for field, msg := range s.validateProfile(firstName, lastName, age, gender) {
+		errors[field] = msg
 	}
 
+	if len(errors) > 0 {
+		log.Println("Validation errors:", errors)
+		return errors, false
+	}
+	return nil, true
+}
+
+// validateProfile checks the personal details shared by registration and profile edits.
+// It returns the error message of every invalid field, keyed by form field name.
+func (s *Server) validateProfile(firstName, lastName string, age *int, gender string) map[string]string {
+	errors := make(map[string]string)
+	const maxFirstName = 50
+	const maxLastName = 50
+
 	// Name validation
 	if len(firstName) == 0 {
 		errors["first_name"] = "First name cannot be empty"
-	} else if len(firstName) > maxFirstName {
+	} else if utf8.RuneCountInString(firstName) > maxFirstName {
 		errors["first_name"] = fmt.Sprintf("First name cannot be longer than %d characters", maxFirstName)
 	}
 
 	if len(lastName) == 0 {
 		errors["last_name"] = "Last name cannot be empty"
-	} else if len(lastName) > maxLastName {
+	} else if utf8.RuneCountInString(lastName) > maxLastName {
 		errors["last_name"] = fmt.Sprintf("Last name cannot be longer than %d characters", maxLastName)
 	}
 
 	// Age validation
-	if age < 0 || age > 150 {
-		errors["age"] = "Age must be between 0 and 150"
+	if age == nil {
+		if s.cfg.Demographics.RequireAge {
+			errors["age"] = "Age cannot be empty"
+		}
+	} else if *age < s.cfg.Demographics.MinAge || *age > s.cfg.Demographics.MaxAge {
+		errors["age"] = fmt.Sprintf("Age must be between %d and %d", s.cfg.Demographics.MinAge, s.cfg.Demographics.MaxAge)
 	}
 
 	// Gender validation
-	if gender != "Male" && gender != "Female" {
-		errors["gender"] = "Gender must be either 'Male' or 'Female'"
+	if !s.isGenderOption(gender) {
+		if !s.cfg.Demographics.AllowCustomGender {
+			errors["gender"] = "Please choose one of the listed options"
+		} else if len(gender) == 0 {
+			errors["gender"] = "Please choose an option or describe your gender"
+		} else if utf8.RuneCountInString(gender) > s.cfg.Demographics.MaxCustomGender {
+			errors["gender"] = fmt.Sprintf("Gender cannot be longer than %d characters", s.cfg.Demographics.MaxCustomGender)
+		}
 	}
 
-	if len(errors) > 0 {
-		log.Println("Validation errors:", errors)
-		return errors, false
+	return errors
+}
+
+// validateEmail checks the address format shared by registration and email changes.
+// It returns an error message, or an empty string when the address is acceptable.
+func validateEmail(email string) string {
+	const maxEmail = 100
+	emailRegex := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+
+	if len(email) == 0 {
+		return "Email cannot be empty"
+	} else if utf8.RuneCountInString(email) > maxEmail {
+		return fmt.Sprintf("Email cannot be longer than %d characters", maxEmail)
+	} else if !emailRegex.MatchString(email) {
+		return "Invalid email format"
 	}
-	return nil, true
-}
\ No newline at end of file
+	return ""
+}
+
+// validatePassword checks a new password against the password rules, the blocklist and the
+// strength estimate. userInputs are the account's own details, which make a password
+// easier to guess. It returns every problem found, one per line, or an empty string
+// when the password is acceptable.
+func (s *Server) validatePassword(password string, userInputs ...string) string {
+	var problems []string
+
+	if len(password) < s.cfg.Passwords.MinLength {
+		problems = append(problems, fmt.Sprintf("Password must be at least %d characters long", s.cfg.Passwords.MinLength))
+	} else if len(password) > s.cfg.Passwords.MaxLength {
+		problems = append(problems, fmt.Sprintf("Password cannot be longer than %d characters", s.cfg.Passwords.MaxLength))
+	}
+
+	var missing []string
+	if !regexp.MustCompile(`[A-Z]`).MatchString(password) {
+		missing = append(missing, "an uppercase letter")
+	}
+	if !regexp.MustCompile(`[a-z]`).MatchString(password) {
+		missing = append(missing, "a lowercase letter")
+	}
+	if !regexp.MustCompile(`[0-9]`).MatchString(password) {
+		missing = append(missing, "a digit")
+	}
+	if !regexp.MustCompile(`[\W_]`).MatchString(password) {
+		missing = append(missing, "a special character")
+	}
+	if len(missing) > 0 {
+		problems = append(problems, "Password must include "+joinWithAnd(missing))
+	}
+
+	if s.blocklist.contains(password) {
+		problems = append(problems, "This password appears in a list of common or breached passwords; choose a different one")
+	} else if strength := estimatePasswordStrength(password, userInputs, s.blocklist.words); strength.Score < s.cfg.Passwords.MinScore {
+		advice := append(strength.Weaknesses, "make it longer, for example with a few unrelated words")
+		problems = append(problems, fmt.Sprintf("Password is too easy to guess (strength %d of 4): %s", strength.Score, strings.Join(advice, "; ")))
+	}
+
+	return strings.Join(problems, "\n")
+}
+
+// joinWithAnd joins items as "a, b and c"
+func joinWithAnd(items []string) string {
+	if len(items) == 1 {
+		return items[0]
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " and " + items[len(items)-1]
+}
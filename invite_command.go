@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"forum/config"
+	"forum/database"
+	"forum/handlers"
+)
+
+const inviteUsage = `usage: forum [flags] invite [uses]
+
+Creates an invite code that registers up to uses accounts, one by default. It
+is how the first users join a forum whose registration-mode is invite; promote
+one of them with -make-admin to let them invite the rest from the forum.`
+
+// runInviteCommand mints an invite that belongs to no user and prints it
+func runInviteCommand(cfg *config.Config, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("unexpected arguments %q\n%s", strings.Join(args[1:], " "), inviteUsage)
+	}
+	uses := 1
+	if len(args) == 1 {
+		parsed, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid number of uses %q\n%s", args[0], inviteUsage)
+		}
+		uses = parsed
+	}
+
+	db, err := database.InitDB(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	srv := handlers.NewServer(cfg.Handlers, handlers.Deps{DB: db})
+	defer srv.Close()
+
+	invite, code, err := srv.MintInvite(uses)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Invite code: %s\n", code)
+	fmt.Printf("Link:        %s/?invite=%s\n", strings.TrimSuffix(cfg.Handlers.BaseURL, "/"), code)
+	fmt.Printf("Registers up to %d accounts until %s\n", invite.MaxUses, invite.ExpiresAt.Local().Format("2006-01-02 15:04"))
+	if cfg.Handlers.Registration.Mode != handlers.RegistrationInvite {
+		fmt.Printf("Note: registration-mode is %s, so the code is only needed once it is invite\n", cfg.Handlers.Registration.Mode)
+	}
+	return nil
+}
@@ -1,38 +1,98 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"log"
 	"net/http"
+	"os"
+	"strings"
 
+	"forum/config"
 	"forum/database"
 	"forum/handlers"
+	"forum/mail"
+	"forum/oidc"
+	"forum/utils"
 )
 
 func main() {
-	if err := database.InitDB(); err != nil {
+	cfg, args, err := config.Load(os.Args[1:], os.Environ())
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			if err := runMigrateCommand(cfg.Database, args[1:]); err != nil {
+				log.Fatalf("Migration failed: %v", err)
+			}
+		case "invite":
+			if err := runInviteCommand(cfg, args[1:]); err != nil {
+				log.Fatalf("Creating invite failed: %v", err)
+			}
+		default:
+			log.Fatalf("Unknown command %q (want migrate or invite)", args[0])
+		}
+		return
+	}
+
+	blocklist := &handlers.PasswordBlocklist{}
+	if cfg.PasswordBlocklist != "" {
+		blocklist, err = handlers.LoadPasswordBlocklist(cfg.PasswordBlocklist)
+		if err != nil {
+			log.Fatalf("Password blocklist could not be loaded (set password-blocklist to \"\" to run without one): %v", err)
+		}
+		log.Printf("Loaded %d blocked passwords from %s", blocklist.Len(), cfg.PasswordBlocklist)
+	}
+
+	secret, err := utils.LoadOrCreateSecret(cfg.SecretFile)
+	if err != nil {
+		log.Fatalf("Signing key initialization failed: %v", err)
+	}
+
+	outbox, err := mail.NewFileOutbox(cfg.Mail.OutboxDir, cfg.Mail.From)
+	if err != nil {
+		log.Fatalf("Mail outbox initialization failed: %v", err)
+	}
+
+	var provider *oidc.Provider
+	if cfg.OIDC.Issuer != "" {
+		provider = oidc.NewProvider(oidc.Config{
+			Issuer:       cfg.OIDC.Issuer,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  strings.TrimSuffix(cfg.Handlers.BaseURL, "/") + "/oauth/callback",
+			Scopes:       []string{"email", "profile"},
+		})
+	}
+
+	db, err := database.InitDB(cfg.Database)
+	if err != nil {
 		log.Fatalf("Database initialization failed: %v", err)
 	}
-	defer database.DB.Close()
-
-	http.Handle("/static/", http.StripPrefix("/static", http.FileServer(http.Dir("./static"))))
-	http.HandleFunc("/", handlers.HomePage)
-	http.HandleFunc("/show_posts", handlers.ShowPosts)
-	http.HandleFunc("/post_submit", handlers.PostSubmit)
-	http.HandleFunc("/comment_submit", handlers.CommentSubmit)
-	http.HandleFunc("/interact", handlers.HandleInteract)
-	http.HandleFunc("/get_categories", handlers.GetCategories)
-	http.HandleFunc("/login", handlers.LoginHandler)
-	http.HandleFunc("/check-session", handlers.CheckSessionHandler)
-	http.HandleFunc("/logout", handlers.LogoutHandler)
-	http.HandleFunc("/register", handlers.RegisterHandler)
-	http.HandleFunc("/get_all_users", handlers.GetAllUsersHandler)
-	http.HandleFunc("/fetch_messages", handlers.FetchMessagesHandler)
-	http.HandleFunc("/ws", handlers.HandleConnections)
-	http.HandleFunc("/mark-read", handlers.MarkNotificationsRead)
-	http.HandleFunc("/get-notifications", handlers.GetNotifications)
-
-	go handlers.HandleMessages()
-
-	log.Println("http://localhost:4422/")
-	log.Fatal(http.ListenAndServe(":4422", nil))
+	defer db.Close()
+
+	srv := handlers.NewServer(cfg.Handlers, handlers.Deps{
+		DB:           db,
+		Mailer:       outbox,
+		OIDCProvider: provider,
+		SigningKey:   secret,
+		Passwords:    handlers.PasswordPolicy(cfg.Argon2),
+		Blocklist:    blocklist,
+	})
+	defer srv.Close()
+
+	if cfg.MakeAdmin != "" {
+		if err := srv.BootstrapAdmin(cfg.MakeAdmin); err != nil {
+			log.Fatalf("Admin bootstrap failed: %v", err)
+		}
+	}
+	srv.WarnIfNoAdmin()
+
+	log.Printf("Listening on %s, serving %s", cfg.Addr, cfg.Handlers.BaseURL)
+	log.Fatal(http.ListenAndServe(cfg.Addr, srv))
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"forum/database"
+)
+
+const migrateUsage = `usage: forum [flags] migrate <command>
+
+commands:
+  status            list every migration and whether it is applied
+  up                apply all pending migrations
+  to <version>      apply or revert migrations until the schema is at version
+  rollback [steps]  revert the most recent migrations, one by default`
+
+// runMigrateCommand manages the database schema instead of starting the server
+func runMigrateCommand(cfg database.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing migrate command\n%s", migrateUsage)
+	}
+	db, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch command := args[0]; {
+	case command == "status" && len(args) == 1:
+		return printMigrationStatus(db)
+	case command == "up" && len(args) == 1:
+		latest, err := database.LatestVersion()
+		if err != nil {
+			return err
+		}
+		return database.MigrateTo(db, latest)
+	case command == "to" && len(args) == 2:
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		return database.MigrateTo(db, version)
+	case command == "rollback" && len(args) <= 2:
+		steps := 1
+		if len(args) == 2 {
+			parsed, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+			steps = parsed
+		}
+		return database.Rollback(db, steps)
+	}
+	return fmt.Errorf("unknown migrate command %q\n%s", strings.Join(args, " "), migrateUsage)
+}
+
+func printMigrationStatus(db *sql.DB) error {
+	statuses, err := database.Status(db)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, status := range statuses {
+		applied := "pending"
+		if status.AppliedAt != nil {
+			applied = status.AppliedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		if !status.Known {
+			applied += " (not in this build)"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, applied)
+	}
+	return w.Flush()
+}
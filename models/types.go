@@ -32,3 +32,109 @@ type CommentWithLike struct {
 	LikeCount    int
 	DislikeCount int
 }
+
+// User is a forum account as stored in the users table
+type User struct {
+	ID               int
+	Nickname         string
+	Email            string
+	PasswordHash     string
+	FirstName        string
+	LastName         string
+	Age              *int
+	Gender           string
+	Role             string
+	EmailVerified    bool
+	TwoFactorEnabled bool
+}
+
+// ChatUser is an entry of the chat's user list
+type ChatUser struct {
+	ID        int    `json:"id"`
+	Nickname  string `json:"nickname"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	IsOnline  bool   `json:"isOnline"`
+	LastSeen  string `json:"lastSeen,omitempty"`
+}
+
+type Message struct {
+	Sender          string `json:"sender"`
+	Receiver        string `json:"receiver"`
+	Content         string `json:"content"`
+	Timestamp       string `json:"timestamp"`
+	SenderFirstName string `json:"firstName"`
+	SenderLastName  string `json:"lastName"`
+}
+
+// Notification tells a user that someone sent them a private message
+type Notification struct {
+	ID     int
+	Sender string
+}
+
+// Session is a logged-in device as stored in the sessions table. The cookie
+// token itself is never stored, only its digest.
+type Session struct {
+	TokenHash  string
+	UserID     int
+	Nickname   string
+	Role       string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	LastSeenAt time.Time
+	UserAgent  string
+	IPAddress  string
+	CSRFToken  string
+	// MFAVerifiedAt is zero if the second factor was never presented
+	MFAVerifiedAt time.Time
+}
+
+// APIToken is a personal access token as stored in the api_tokens table. Only
+// the digest of the token itself is kept.
+type APIToken struct {
+	ID     int64
+	UserID int
+	// Nickname and Role describe the owner; they are filled in by lookups by hash
+	Nickname   string
+	Role       string
+	Name       string
+	Scopes     []string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+// MFAChallenge is a login that passed the password step and waits for the
+// second factor
+type MFAChallenge struct {
+	UserID    int
+	Nickname  string
+	ExpiresAt time.Time
+	// Attempts counts the wrong codes entered so far
+	Attempts int
+}
+
+// Invite is an invite code as stored in the invites table. Only the digest of
+// the code is kept.
+type Invite struct {
+	ID int64
+	// CreatedBy is zero for invites minted with the invite command
+	CreatedBy int
+	MaxUses   int
+	Uses      int
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+	// Invitees are the nicknames of the accounts registered with the invite
+	Invitees []string
+}
+
+// PasswordReset is a password reset link as stored in the password_resets
+// table. Only the digest of the link's token is kept.
+type PasswordReset struct {
+	UserID    int
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	// UsedAt is nil until the link is used
+	UsedAt *time.Time
+}
@@ -0,0 +1,278 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"forum/oidc/oidctest"
+)
+
+const testClientID = "forum-client"
+
+func newTestProvider(t *testing.T) (*oidctest.Server, *Provider) {
+	t.Helper()
+	idp := oidctest.NewServer(testClientID)
+	t.Cleanup(idp.Close)
+	provider := NewProvider(Config{
+		Issuer:      idp.URL + "/",
+		ClientID:    testClientID,
+		RedirectURL: "http://forum.test/oauth/callback",
+		HTTPClient:  idp.Client(),
+	})
+	return idp, provider
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	idp, provider := newTestProvider(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		token   func(claims map[string]interface{}) string
+		wantErr string
+	}{
+		{"valid", idp.Sign, ""},
+		{"bad signature", func(claims map[string]interface{}) string {
+			return oidctest.Sign(otherKey, idp.KeyID, claims)
+		}, "invalid signature"},
+		{"tampered claims", func(claims map[string]interface{}) string {
+			parts := strings.Split(idp.Sign(claims), ".")
+			claims["sub"] = "someone-else"
+			payload, _ := json.Marshal(claims)
+			parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+			return strings.Join(parts, ".")
+		}, "invalid signature"},
+		{"unsigned", func(claims map[string]interface{}) string {
+			header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+			payload, _ := json.Marshal(claims)
+			return header + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+		}, `unsupported signing algorithm "none"`},
+		{"unknown key", func(claims map[string]interface{}) string {
+			return oidctest.Sign(idp.Key, "retired-key", claims)
+		}, `unknown signing key "retired-key"`},
+		{"wrong issuer", func(claims map[string]interface{}) string {
+			claims["iss"] = "https://evil.example"
+			return idp.Sign(claims)
+		}, "unexpected issuer"},
+		{"wrong audience", func(claims map[string]interface{}) string {
+			claims["aud"] = "another-client"
+			return idp.Sign(claims)
+		}, "not issued for this client"},
+		{"audience list", func(claims map[string]interface{}) string {
+			claims["aud"] = []string{"another-client", testClientID}
+			claims["azp"] = testClientID
+			return idp.Sign(claims)
+		}, ""},
+		{"audience list for another party", func(claims map[string]interface{}) string {
+			claims["aud"] = []string{"another-client", testClientID}
+			claims["azp"] = "another-client"
+			return idp.Sign(claims)
+		}, "unexpected authorized party"},
+		{"missing subject", func(claims map[string]interface{}) string {
+			delete(claims, "sub")
+			return idp.Sign(claims)
+		}, "missing subject"},
+		{"expired", func(claims map[string]interface{}) string {
+			claims["exp"] = now.Add(-2 * time.Minute).Unix()
+			return idp.Sign(claims)
+		}, "expired"},
+		{"expired within leeway", func(claims map[string]interface{}) string {
+			claims["exp"] = now.Add(-30 * time.Second).Unix()
+			return idp.Sign(claims)
+		}, ""},
+		{"issued in the future", func(claims map[string]interface{}) string {
+			claims["iat"] = now.Add(time.Hour).Unix()
+			return idp.Sign(claims)
+		}, "issued in the future"},
+		{"wrong nonce", func(claims map[string]interface{}) string {
+			claims["nonce"] = "another-nonce"
+			return idp.Sign(claims)
+		}, "nonce mismatch"},
+		{"missing nonce", func(claims map[string]interface{}) string {
+			delete(claims, "nonce")
+			return idp.Sign(claims)
+		}, "nonce mismatch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := provider.VerifyIDToken(context.Background(), tt.token(idp.IDTokenClaims("the-nonce")), "the-nonce")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("VerifyIDToken: %v", err)
+				}
+				if claims.Subject != "subject-1" {
+					t.Errorf("subject = %q, want subject-1", claims.Subject)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("VerifyIDToken error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenPicksUpRotatedKeys(t *testing.T) {
+	idp, provider := newTestProvider(t)
+	ctx := context.Background()
+
+	if _, err := provider.VerifyIDToken(ctx, idp.Sign(idp.IDTokenClaims("n")), "n"); err != nil {
+		t.Fatalf("before rotation: %v", err)
+	}
+	idp.RotateKey("next-key")
+	if _, err := provider.VerifyIDToken(ctx, idp.Sign(idp.IDTokenClaims("n")), "n"); err != nil {
+		t.Fatalf("after rotation: %v", err)
+	}
+}
+
+func TestEmailVerifiedAcceptsStrings(t *testing.T) {
+	idp, provider := newTestProvider(t)
+	for value, want := range map[interface{}]bool{true: true, "true": true, "false": false, false: false} {
+		claims := idp.IDTokenClaims("n")
+		claims["email_verified"] = value
+		got, err := provider.VerifyIDToken(context.Background(), idp.Sign(claims), "n")
+		if err != nil {
+			t.Fatalf("email_verified %#v: %v", value, err)
+		}
+		if bool(got.EmailVerified) != want {
+			t.Errorf("email_verified %#v = %v, want %v", value, got.EmailVerified, want)
+		}
+	}
+}
+
+func TestDiscoveryRejectsAnotherIssuer(t *testing.T) {
+	idp := oidctest.NewServer(testClientID)
+	defer idp.Close()
+	// The document is served from a URL the provider does not call itself by
+	provider := NewProvider(Config{Issuer: idp.URL + "/tenant", ClientID: testClientID, HTTPClient: idp.Client()})
+	idp.Config.Handler = http.StripPrefix("/tenant", idp.Config.Handler)
+
+	_, err := provider.Discover(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("Discover error = %v, want an issuer mismatch", err)
+	}
+}
+
+// authorize follows the authorization URL as a browser would and returns the callback's query
+func authorize(t *testing.T, idp *oidctest.Server, authURL string) url.Values {
+	t.Helper()
+	client := idp.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorization endpoint: status %d", resp.StatusCode)
+	}
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return callback.Query()
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	idp, provider := newTestProvider(t)
+	idp.SetIdentity(map[string]interface{}{"sub": "subject-1", "email": "ada@example.com", "email_verified": true})
+	ctx := context.Background()
+
+	req, err := NewAuthRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := provider.AuthCodeURL(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, _ := url.Parse(authURL)
+	for name, want := range map[string]string{
+		"client_id":             testClientID,
+		"redirect_uri":          "http://forum.test/oauth/callback",
+		"state":                 req.State,
+		"nonce":                 req.Nonce,
+		"code_challenge":        CodeChallenge(req.CodeVerifier),
+		"code_challenge_method": "S256",
+	} {
+		if got := query.Query().Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if strings.Contains(authURL, req.CodeVerifier) {
+		t.Error("authorization URL reveals the code verifier")
+	}
+
+	callback := authorize(t, idp, authURL)
+	if callback.Get("state") != req.State {
+		t.Fatalf("callback state = %q, want %q", callback.Get("state"), req.State)
+	}
+	claims, err := provider.Exchange(ctx, callback.Get("code"), req)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if claims.Subject != "subject-1" || claims.Email != "ada@example.com" || !claims.EmailVerified {
+		t.Errorf("claims = %+v", claims)
+	}
+
+	// Codes are single-use
+	if _, err := provider.Exchange(ctx, callback.Get("code"), req); err == nil {
+		t.Error("a code was exchanged twice")
+	}
+}
+
+func TestExchangeNeedsTheCodeVerifier(t *testing.T) {
+	idp, provider := newTestProvider(t)
+	ctx := context.Background()
+
+	req, err := NewAuthRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := provider.AuthCodeURL(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := authorize(t, idp, authURL).Get("code")
+
+	// Someone who intercepted the code does not know the verifier
+	stolen := req
+	stolen.CodeVerifier = "guessed-verifier"
+	if _, err := provider.Exchange(ctx, code, stolen); err == nil {
+		t.Fatal("Exchange succeeded with the wrong code verifier")
+	}
+}
+
+func TestExchangeChecksTheNonce(t *testing.T) {
+	idp, provider := newTestProvider(t)
+	ctx := context.Background()
+
+	req, err := NewAuthRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := provider.AuthCodeURL(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := authorize(t, idp, authURL).Get("code")
+
+	other := req
+	other.Nonce = "another-login"
+	_, err = provider.Exchange(ctx, code, other)
+	if err == nil || !strings.Contains(err.Error(), "nonce mismatch") {
+		t.Fatalf("Exchange error = %v, want a nonce mismatch", err)
+	}
+}
@@ -0,0 +1,239 @@
+// Package oidctest runs an OpenID provider for tests. It serves discovery, the
+// authorization and token endpoints with PKCE, and its RS256 signing key, and
+// logs every visitor in as its Identity without asking.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Server is an OpenID provider listening on a local port; its URL is the issuer
+type Server struct {
+	*httptest.Server
+	ClientID string
+	KeyID    string
+	Key      *rsa.PrivateKey
+
+	mu sync.Mutex
+	// Identity holds the claims describing the user who logs in, such as sub and email
+	Identity map[string]interface{}
+	// IDToken, if set, encodes the claims of each ID token in place of Sign, so
+	// tests can change the claims or sign with another key
+	IDToken func(claims map[string]interface{}) string
+	grants  map[string]grant
+}
+
+// grant is an authorization code waiting to be exchanged
+type grant struct {
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+}
+
+// NewServer starts a provider for the client
+func NewServer(clientID string) *Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic("oidctest: generating key: " + err.Error())
+	}
+	s := &Server{
+		ClientID: clientID,
+		KeyID:    "test-key",
+		Key:      key,
+		Identity: map[string]interface{}{"sub": "subject-1"},
+		grants:   make(map[string]grant),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+	mux.HandleFunc("/jwks", s.jwks)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// SetIdentity replaces the claims of the user who logs in
+func (s *Server) SetIdentity(claims map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Identity = claims
+}
+
+// SetIDToken replaces the IDToken hook; nil restores plain signing
+func (s *Server) SetIDToken(encode func(claims map[string]interface{}) string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.IDToken = encode
+}
+
+// IDTokenClaims returns valid ID token claims for the Identity: issued now for
+// the client, expiring in five minutes and carrying the nonce
+func (s *Server) IDTokenClaims(nonce string) map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":   s.URL,
+		"aud":   s.ClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+		"nonce": nonce,
+	}
+	for name, value := range s.Identity {
+		claims[name] = value
+	}
+	return claims
+}
+
+// Sign encodes the claims as an ID token signed with the provider's key
+func (s *Server) Sign(claims map[string]interface{}) string {
+	s.mu.Lock()
+	key, kid := s.Key, s.KeyID
+	s.mu.Unlock()
+	return Sign(key, kid, claims)
+}
+
+// Sign encodes the claims as an RS256 JWT signed with key, naming kid in its header
+func Sign(key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		panic("oidctest: encoding claims: " + err.Error())
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic("oidctest: signing: " + err.Error())
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 s.URL,
+		"authorization_endpoint": s.URL + "/authorize",
+		"token_endpoint":         s.URL + "/token",
+		"jwks_uri":               s.URL + "/jwks",
+	})
+}
+
+// authorize logs the visitor in at once and sends them back with a code
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != s.ClientID || query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirect.Host == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+	s.mu.Lock()
+	s.grants[code] = grant{
+		redirectURI:   query.Get("redirect_uri"),
+		nonce:         query.Get("nonce"),
+		codeChallenge: query.Get("code_challenge"),
+	}
+	s.mu.Unlock()
+
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token exchanges a code once, checking the PKCE verifier against its challenge
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	code := r.PostFormValue("code")
+	s.mu.Lock()
+	g, ok := s.grants[code]
+	delete(s.grants, code)
+	s.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	switch {
+	case r.PostFormValue("grant_type") != "authorization_code" || !ok:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	case r.PostFormValue("client_id") != s.ClientID || r.PostFormValue("redirect_uri") != g.redirectURI:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	case base64.RawURLEncoding.EncodeToString(sum[:]) != g.codeChallenge:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	s.mu.Lock()
+	encode := s.IDToken
+	s.mu.Unlock()
+	if encode == nil {
+		encode = s.Sign
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"id_token":     encode(s.IDTokenClaims(g.nonce)),
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	key, kid := s.Key.PublicKey, s.KeyID
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": kid,
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+}
+
+// RotateKey replaces the signing key, as providers do from time to time
+func (s *Server) RotateKey(kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic("oidctest: generating key: " + err.Error())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Key, s.KeyID = key, kid
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic("oidctest: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
@@ -0,0 +1,133 @@
+package passhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2id hashes passwords with argon2id, encoded in the PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+type Argon2id struct {
+	// Memory is the memory cost in KiB
+	Memory uint32
+	// Iterations is the number of passes over the memory
+	Iterations uint32
+	// Parallelism is the number of lanes
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2id follows the RFC 9106 recommendation for memory-constrained
+// servers: 64 MiB of memory and three passes
+var DefaultArgon2id = Argon2id{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+const argon2idPrefix = "$argon2id$"
+
+// Validate reports settings argon2id cannot work with
+func (a Argon2id) Validate() error {
+	switch {
+	case a.Iterations < 1:
+		return errors.New("argon2id: at least one iteration is required")
+	case a.Parallelism < 1:
+		return errors.New("argon2id: parallelism must be at least 1")
+	case a.Memory < 8*uint32(a.Parallelism):
+		return fmt.Errorf("argon2id: memory must be at least %d KiB for parallelism %d", 8*uint32(a.Parallelism), a.Parallelism)
+	case a.SaltLength < 8:
+		return errors.New("argon2id: salt must be at least 8 bytes")
+	case a.KeyLength < 16:
+		return errors.New("argon2id: key must be at least 16 bytes")
+	}
+	return nil
+}
+
+func (a Argon2id) Hash(password string) (string, error) {
+	if err := a.Validate(); err != nil {
+		return "", err
+	}
+	salt := make([]byte, a.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version, a.Memory, a.Iterations, a.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (a Argon2id) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2idPrefix)
+}
+
+func (a Argon2id) Verify(password, encoded string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	computed := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(computed, key) == 1, nil
+}
+
+func (a Argon2id) Weaker(encoded string) bool {
+	params, _, _, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Memory < a.Memory ||
+		params.Iterations < a.Iterations ||
+		params.Parallelism < a.Parallelism ||
+		params.SaltLength < a.SaltLength ||
+		params.KeyLength < a.KeyLength
+}
+
+// decodeArgon2id parses a PHC string into its settings, salt and key
+func decodeArgon2id(encoded string) (Argon2id, []byte, []byte, error) {
+	var params Argon2id
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownFormat
+	}
+
+	// Sscanf stops at the last verb, so each field must also print back exactly
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || parts[2] != fmt.Sprintf("v=%d", version) {
+		return params, nil, nil, fmt.Errorf("argon2id: malformed version %q", parts[2])
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("argon2id: unsupported version %d", version)
+	}
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil || parts[3] != fmt.Sprintf("m=%d,t=%d,p=%d", params.Memory, params.Iterations, params.Parallelism) {
+		return params, nil, nil, fmt.Errorf("argon2id: malformed parameters %q", parts[3])
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("argon2id: malformed salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("argon2id: malformed key: %w", err)
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	if err := params.Validate(); err != nil {
+		return params, nil, nil, err
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,49 @@
+// Package passhash hashes passwords into self-describing strings and checks
+// them, telling the caller when a stored hash should be upgraded.
+package passhash
+
+import "errors"
+
+// ErrUnknownFormat is returned for stored hashes no configured hasher recognises
+var ErrUnknownFormat = errors.New("passhash: unrecognised hash format")
+
+// Hasher is one password hashing scheme
+type Hasher interface {
+	// Hash returns an encoded hash that embeds the scheme, its settings and the salt
+	Hash(password string) (string, error)
+	// Recognizes reports whether the encoded hash belongs to this scheme
+	Recognizes(encoded string) bool
+	// Verify reports whether the password matches a hash this scheme recognises
+	Verify(password, encoded string) (bool, error)
+	// Weaker reports whether the hash was made with weaker settings than the hasher's own
+	Weaker(encoded string) bool
+}
+
+// Policy hashes new passwords with Current and still accepts hashes made by
+// the Accepted schemes, so that they can be upgraded as users log in
+type Policy struct {
+	Current  Hasher
+	Accepted []Hasher
+}
+
+// Hash hashes a password with the current scheme
+func (p *Policy) Hash(password string) (string, error) {
+	return p.Current.Hash(password)
+}
+
+// Verify checks a password against a stored hash. rehash is true when the
+// password matched but the hash uses another scheme or weaker settings, in
+// which case the caller should store a fresh Hash of the password.
+func (p *Policy) Verify(password, encoded string) (match, rehash bool, err error) {
+	if p.Current.Recognizes(encoded) {
+		match, err = p.Current.Verify(password, encoded)
+		return match, match && p.Current.Weaker(encoded), err
+	}
+	for _, hasher := range p.Accepted {
+		if hasher.Recognizes(encoded) {
+			match, err = hasher.Verify(password, encoded)
+			return match, match, err
+		}
+	}
+	return false, false, ErrUnknownFormat
+}
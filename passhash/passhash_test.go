@@ -0,0 +1,216 @@
+package passhash
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheap keeps the tests fast; the encoding is what is under test
+var cheap = Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	encoded, err := cheap.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("encoded = %q, want the PHC format with its settings", encoded)
+	}
+	if !cheap.Recognizes(encoded) || (Bcrypt{}).Recognizes(encoded) {
+		t.Error("the hash is not recognised as argon2id only")
+	}
+
+	for password, want := range map[string]bool{"correct horse": true, "correct horse ": false, "Correct horse": false, "": false} {
+		if ok, err := cheap.Verify(password, encoded); err != nil || ok != want {
+			t.Errorf("Verify(%q) = %t, %v; want %t", password, ok, err, want)
+		}
+	}
+
+	// Verification reads the settings from the hash, not from the hasher
+	if ok, err := DefaultArgon2id.Verify("correct horse", encoded); err != nil || !ok {
+		t.Errorf("Verify with other settings = %t, %v; want true", ok, err)
+	}
+
+	// Salts are random
+	again, _ := cheap.Hash("correct horse")
+	if again == encoded {
+		t.Error("two hashes of one password are equal")
+	}
+}
+
+func TestArgon2idMalformedHashes(t *testing.T) {
+	valid, err := cheap.Hash("pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(valid, "$")
+	with := func(i int, value string) string {
+		changed := append([]string{}, parts...)
+		changed[i] = value
+		return strings.Join(changed, "$")
+	}
+
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"empty", ""},
+		{"missing key", strings.Join(parts[:5], "$")},
+		{"extra field", valid + "$x"},
+		{"other algorithm", with(1, "argon2i")},
+		{"no version", with(2, "19")},
+		{"old version", with(2, "v=16")},
+		{"trailing version text", with(2, "v=19x")},
+		{"leading zeros", with(3, "m=064,t=1,p=1")},
+		{"missing parameter", with(3, "m=64,t=1")},
+		{"parameters out of order", with(3, "t=1,m=64,p=1")},
+		{"trailing parameter text", with(3, "m=64,t=1,p=1,x=2")},
+		{"negative memory", with(3, "m=-64,t=1,p=1")},
+		{"parallelism overflow", with(3, "m=64,t=1,p=300")},
+		{"zero iterations", with(3, "m=64,t=0,p=1")},
+		{"memory too small for lanes", with(3, "m=8,t=1,p=2")},
+		{"salt not base64", with(4, "!!!")},
+		{"salt too short", with(4, "AAAA")},
+		{"key not base64", with(5, "!!!")},
+		{"key too short", with(5, "AAAA")},
+	}
+	for _, tt := range tests {
+		ok, err := cheap.Verify("pw", tt.encoded)
+		if err == nil || ok {
+			t.Errorf("%s: Verify(%q) = %t, %v; want an error", tt.name, tt.encoded, ok, err)
+		}
+		if !cheap.Weaker(tt.encoded) {
+			t.Errorf("%s: a malformed hash is not reported as weaker", tt.name)
+		}
+	}
+
+	if _, err := cheap.Verify("pw", "$2a$10$abc"); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("bcrypt hash: err = %v, want ErrUnknownFormat", err)
+	}
+}
+
+func TestArgon2idWeaker(t *testing.T) {
+	encoded, err := cheap.Hash("pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name   string
+		hasher Argon2id
+		want   bool
+	}{
+		{"same settings", cheap, false},
+		{"less memory wanted", Argon2id{Memory: 32, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}, false},
+		{"more memory", Argon2id{Memory: 128, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}, true},
+		{"more iterations", Argon2id{Memory: 64, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}, true},
+		{"more lanes", Argon2id{Memory: 64, Iterations: 1, Parallelism: 2, SaltLength: 16, KeyLength: 32}, true},
+		{"longer salt", Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 32, KeyLength: 32}, true},
+		{"longer key", Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 64}, true},
+	} {
+		if got := tt.hasher.Weaker(encoded); got != tt.want {
+			t.Errorf("%s: Weaker = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestArgon2idValidate(t *testing.T) {
+	if err := DefaultArgon2id.Validate(); err != nil {
+		t.Errorf("default settings: %v", err)
+	}
+	for _, bad := range []Argon2id{
+		{Memory: 64, Iterations: 0, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		{Memory: 64, Iterations: 1, Parallelism: 0, SaltLength: 16, KeyLength: 32},
+		{Memory: 15, Iterations: 1, Parallelism: 2, SaltLength: 16, KeyLength: 32},
+		{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 7, KeyLength: 32},
+		{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 15},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("Validate(%+v) accepted invalid settings", bad)
+		}
+		if _, err := bad.Hash("pw"); err == nil {
+			t.Errorf("Hash with %+v succeeded", bad)
+		}
+	}
+}
+
+func TestBcrypt(t *testing.T) {
+	b := Bcrypt{Cost: bcrypt.MinCost}
+	encoded, err := b.Hash("pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.Recognizes(encoded) || cheap.Recognizes(encoded) {
+		t.Error("the hash is not recognised as bcrypt only")
+	}
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if !b.Recognizes(prefix + "10$abc") {
+			t.Errorf("%s hashes are not recognised", prefix)
+		}
+	}
+
+	if ok, err := b.Verify("pw", encoded); err != nil || !ok {
+		t.Errorf("Verify(right) = %t, %v; want true", ok, err)
+	}
+	if ok, err := b.Verify("wrong", encoded); err != nil || ok {
+		t.Errorf("Verify(wrong) = %t, %v; want false without error", ok, err)
+	}
+	if ok, err := b.Verify("pw", "$2a$10$short"); err == nil || ok {
+		t.Errorf("Verify(malformed) = %t, %v; want an error", ok, err)
+	}
+
+	if (Bcrypt{Cost: bcrypt.MinCost}).Weaker(encoded) {
+		t.Error("a hash is weaker than its own cost")
+	}
+	if !(Bcrypt{Cost: bcrypt.MinCost + 1}).Weaker(encoded) {
+		t.Error("a cheaper hash is not weaker")
+	}
+}
+
+func TestPolicyVerify(t *testing.T) {
+	policy := &Policy{Current: cheap, Accepted: []Hasher{Bcrypt{}}}
+	current, err := policy.Hash("pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	weaker, err := Argon2id{Memory: 32, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}.Hash("pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := Bcrypt{Cost: bcrypt.MinCost}.Hash("pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		password      string
+		encoded       string
+		match, rehash bool
+		err           error
+	}{
+		{"current hash", "pw", current, true, false, nil},
+		{"current hash, wrong password", "nope", current, false, false, nil},
+		// Upgrades happen only once the password is known to be right
+		{"weaker argon2id", "pw", weaker, true, true, nil},
+		{"weaker argon2id, wrong password", "nope", weaker, false, false, nil},
+		{"accepted scheme", "pw", legacy, true, true, nil},
+		{"accepted scheme, wrong password", "nope", legacy, false, false, nil},
+		{"unknown scheme", "pw", "$scrypt$whatever", false, false, ErrUnknownFormat},
+		{"plain text", "pw", "pw", false, false, ErrUnknownFormat},
+	}
+	for _, tt := range tests {
+		match, rehash, err := policy.Verify(tt.password, tt.encoded)
+		if match != tt.match || rehash != tt.rehash || !errors.Is(err, tt.err) {
+			t.Errorf("%s: Verify = %t, %t, %v; want %t, %t, %v", tt.name, match, rehash, err, tt.match, tt.rehash, tt.err)
+		}
+	}
+
+	// Without bcrypt accepted, legacy hashes are unknown
+	strict := &Policy{Current: cheap}
+	if _, _, err := strict.Verify("pw", legacy); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("bcrypt hash under an argon2id-only policy: err = %v, want ErrUnknownFormat", err)
+	}
+}
@@ -18,24 +18,11 @@ document.getElementById("loginForm").addEventListener("submit", function (event)
     body: formData,
   })
     .then(handleResponse)
+    .then(completeTwoFactor)
     .then(data => {
-      if (data.message) {
+      if (data && data.message) {
         this.reset();
-        // Store nickname immediately
-        localStorage.setItem("nickname", data.nickname);
-        console.log("Login successful, nickname:", data.nickname);
-        
-        // Hide login form and show chat interface
-        document.getElementById("loginContainer").style.display = "none";
-        document.getElementById("chatContainer").style.display = "block";
-        
-        // Initialize chat system programmatically
-        initializeChatSystem(data.nickname);
-        
-        // Call any other success handlers
-        if (window.handleAuthSuccess) {
-          window.handleAuthSuccess();
-        }
+        finishLogin(data);
       }
     })
     .catch(error => {
@@ -43,7 +30,89 @@ document.getElementById("loginForm").addEventListener("submit", function (event)
     });
 });
 
-document.getElementById("registerForm").addEventListener("submit", function (event) {
+function finishLogin(data) {
+  window.setCSRFToken(data.csrfToken);
+  // Store nickname immediately
+  localStorage.setItem("nickname", data.nickname);
+  console.log("Login successful, nickname:", data.nickname);
+
+  // Hide login form and show chat interface
+  document.getElementById("loginContainer").style.display = "none";
+  document.getElementById("chatContainer").style.display = "block";
+
+  // Initialize chat system programmatically
+  initializeChatSystem(data.nickname);
+
+  // Call any other success handlers
+  if (window.handleAuthSuccess) {
+    window.handleAuthSuccess();
+  }
+}
+
+// Provider sign-in comes back with either an error to show or a pending second factor
+(function handleProviderRedirect() {
+  const params = new URLSearchParams(window.location.search);
+  const loginError = params.get("login_error");
+  const challenge = params.get("two_factor");
+  if (!loginError && !challenge) return;
+  history.replaceState(null, "", "/");
+
+  const errorElement = document.getElementById("loginMessage");
+  if (loginError) {
+    showError(errorElement, loginError);
+    return;
+  }
+  Promise.resolve({ twoFactorRequired: true, challenge })
+    .then(completeTwoFactor)
+    .then(finishLogin)
+    .catch(error => showError(errorElement, error.error || "Login failed."));
+})();
+
+// Invite links open the registration form with the code filled in
+(function handleInviteLink() {
+  const code = new URLSearchParams(window.location.search).get("invite");
+  const inviteInput = document.getElementById("registerInviteCode");
+  if (!code) return;
+  history.replaceState(null, "", "/");
+  if (!inviteInput) return;
+
+  inviteInput.value = code;
+  // The tab buttons are wired up once the page has loaded
+  document.addEventListener("DOMContentLoaded", () => {
+    document.querySelector('.auth-tabs button[data-form="register"]').click();
+  });
+})();
+
+document.getElementById("forgotPasswordLink").addEventListener("click", function (event) {
+  event.preventDefault();
+  const messageElement = document.getElementById("loginMessage");
+  const email = prompt("Enter the email address of your account:");
+  if (!email) return;
+
+  fetch("/forgot_password", {
+    method: "POST",
+    body: new URLSearchParams({ email }),
+  })
+    .then(handleResponse)
+    .then(data => showError(messageElement, data.message))
+    .catch(error => showError(messageElement, error.error || "Could not send the reset email."));
+});
+
+// Show the free-text gender field only when the user chooses to describe it
+const genderSelect = document.getElementById("registerGender");
+const genderCustom = document.getElementById("registerGenderCustom");
+if (genderCustom) {
+  const toggleGenderCustom = () => {
+    const custom = genderSelect.value === "custom";
+    genderCustom.style.display = custom ? "block" : "none";
+    genderCustom.required = custom;
+  };
+  genderSelect.addEventListener("change", toggleGenderCustom);
+  toggleGenderCustom();
+}
+
+// The form is left out of the page when registration is closed
+document.getElementById("registerForm")?.addEventListener("submit", function (event) {
   event.preventDefault();
   clearErrors();
 
@@ -83,7 +152,7 @@ document.getElementById("registerForm").addEventListener("submit", function (eve
       return;
     }
 
-    if (!value?.trim()) {
+    if (inputElement.required && !value?.trim()) {
       showError(errorElement, "This field is required");
       isValid = false;
     }
@@ -114,6 +183,7 @@ document.getElementById("registerForm").addEventListener("submit", function (eve
     })
     .then(loginData => {
       if (loginData && loginData.message) {
+        window.setCSRFToken(loginData.csrfToken);
         // Store nickname immediately
         localStorage.setItem("nickname", loginData.nickname);
         console.log("Login successful, nickname:", loginData.nickname);
@@ -149,6 +219,24 @@ document.getElementById("registerForm").addEventListener("submit", function (eve
     });
 });
 
+// When the account has two-factor enabled, the password step returns a challenge
+// that must be answered with an authenticator or recovery code
+function completeTwoFactor(data) {
+  if (!data.twoFactorRequired) return data;
+
+  const code = prompt("Enter the code from your authenticator app (or a recovery code):");
+  if (!code) throw { error: "Login cancelled." };
+
+  const body = new URLSearchParams({ challenge: data.challenge });
+  if (/^\d{6}$/.test(code.trim())) {
+    body.set("code", code.trim());
+  } else {
+    body.set("recovery_code", code.trim());
+  }
+
+  return fetch("/login/2fa", { method: "POST", body }).then(handleResponse);
+}
+
 function handleResponse(response) {
   return response.json().then(data => {
     if (!response.ok) throw data;
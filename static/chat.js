@@ -41,7 +41,7 @@ function initializeChatSystem(nickname = localStorage.getItem("nickname")) {
     // Define all the helper functions that were in your DOMContentLoaded
     function fetchAllUsers(nickname) {
       return new Promise((resolve, reject) => {
-        fetch(`/get_all_users?nickname=${encodeURIComponent(nickname)}`)
+        fetch('/get_all_users')
           .then(response => {
             if (!response.ok) {
               throw new Error(`Server responded with ${response.status}`);
@@ -60,10 +60,11 @@ function initializeChatSystem(nickname = localStorage.getItem("nickname")) {
     }
     
     function initializeWebSocket(nickname) {
-      socket = new WebSocket(`ws://localhost:4422/ws?nickname=${nickname}`);
+      const wsProtocol = window.location.protocol === "https:" ? "wss" : "ws";
+      socket = new WebSocket(`${wsProtocol}://${window.location.host}/ws`);
 
       // Fetch notifications when page loads
-      fetch(`/get-notifications?nickname=${nickname}`)
+      fetch('/get-notifications')
       .then(response => response.json())
       .then(notifications => {
           notifications.forEach(notif => {
@@ -159,7 +160,7 @@ function initializeChatSystem(nickname = localStorage.getItem("nickname")) {
         userActivity[sender] = Date.now();
         
         // Find the user element
-        const userElement = document.querySelector(`.online-user[data-nickname="${sender}"]`);
+        const userElement = document.querySelector(`.online-user[data-nickname="${CSS.escape(sender)}"]`);
         
         if (userElement) {
             // Highlight the user
@@ -212,7 +213,7 @@ function initializeChatSystem(nickname = localStorage.getItem("nickname")) {
 
         isLoading = true;
 
-        fetch(`/fetch_messages?nickname=${encodeURIComponent(nickname)}&otherUser=${encodeURIComponent(otherNickname)}&offset=${offset}&limit=${limit}`)
+        fetch(`/fetch_messages?otherUser=${encodeURIComponent(otherNickname)}&offset=${offset}&limit=${limit}`)
         .then(response => response.json())
         .then(data => {     
             console.log(data);
@@ -253,7 +254,7 @@ function displayMessages(messages, messageList, append) {
         const msgElement = document.createElement('li');
         msgElement.className = msg.sender === nickname ? 'sent-message' : 'received-message';
         const displayName = msg.sender === nickname ? 'You' : `${msg.firstName} ${msg.lastName}`;
-        msgElement.innerHTML = `[${msg.timestamp}] ${displayName}: ${msg.content}`;
+        msgElement.innerHTML = `[${escapeHTML(msg.timestamp)}] ${escapeHTML(displayName)}: ${escapeHTML(msg.content)}`;
         return msgElement;
     });
 
@@ -311,8 +312,7 @@ window.openPrivateChat = async (nickname, firstName, lastName) => {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
             body: JSON.stringify({
-                receiver: receiverOfNoti, 
-                sender: nickname              
+                sender: nickname
             })
         });
         
@@ -347,7 +347,7 @@ window.openPrivateChat = async (nickname, firstName, lastName) => {
         }
 
         // Set up typing detection
-        const messageInput = chatBox.querySelector(`#input-${nickname}`);
+        const messageInput = chatBox.querySelector(`#input-${CSS.escape(nickname)}`);
         let typingTimeout;
         
         const handleTypingInput = () => {
@@ -396,18 +396,18 @@ window.openPrivateChat = async (nickname, firstName, lastName) => {
         chatBox.className = "private-chat";
         chatBox.innerHTML = `
             <div class="chat-header">
-              <h4>Chat with ${firstName} ${lastName}</h4>
+              <h4>Chat with ${escapeHTML(firstName)} ${escapeHTML(lastName)}</h4>
               <button class="close-chat">×</button>
             </div>
             <div class="typing-container"></div>
-            <ul class="chat-messages" id="messages-${nickname}"></ul>
+            <ul class="chat-messages" id="messages-${escapeHTML(nickname)}"></ul>
             <div class="chat-input-container"> <!-- New container div -->
-              <input type="text" id="input-${nickname}" placeholder="Type a message...">
+              <input type="text" id="input-${escapeHTML(nickname)}" placeholder="Type a message...">
               <button class="send-message">Send</button>
             </div>
         `;
         
-        const input = chatBox.querySelector(`#input-${nickname}`);
+        const input = chatBox.querySelector(`#input-${CSS.escape(nickname)}`);
         const sendButton = chatBox.querySelector('.send-message');
         const closeButton = chatBox.querySelector('.close-chat');
         
@@ -563,8 +563,8 @@ function createUserElement(user) {
     const nameContainer = document.createElement('div');
     nameContainer.className = 'user-name-container';
     nameContainer.innerHTML = `
-        <span class="user-first-name">${user.firstName}</span>
-        <span class="user-last-name">${user.lastName}</span>
+        <span class="user-first-name">${escapeHTML(user.firstName)}</span>
+        <span class="user-last-name">${escapeHTML(user.lastName)}</span>
     `;
 
     // Unread badge
@@ -606,7 +606,7 @@ const displayPrivateMessage = (data) => {
         
         messageList.innerHTML += `
             <li class="${data.sender === nickname ? "sent-message" : "received-message"}">
-                [${data.timestamp || 'No timestamp'}] ${displayName}: ${data.content || ''}
+                [${escapeHTML(data.timestamp || 'No timestamp')}] ${escapeHTML(displayName)}: ${escapeHTML(data.content)}
             </li>`;
         messageList.scrollTop = messageList.scrollHeight;
     }
@@ -643,7 +643,7 @@ const displayPrivateMessage = (data) => {
     
     const resetUnreadCount = (nickname) => {
         unreadCounts[nickname] = 0; // Set to 0 instead of deleting to maintain the key
-        const userElement = document.querySelector(`.online-user[data-nickname="${nickname}"]`);
+        const userElement = document.querySelector(`.online-user[data-nickname="${CSS.escape(nickname)}"]`);
         if (userElement) {
             const badge = userElement.querySelector(".unread-badge");
             if (badge) badge.remove();
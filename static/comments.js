@@ -24,7 +24,7 @@ async function submitComment(event, postID) {
     result = result.reverse();
 
     let curPost = document.getElementsByClassName(`post${postID}`)[0];
-    let comments = result[postID - 1].Comments;
+    let comments = result.find(post => post.PostID === postID).Comments;
 
     // Update comment count with icon
     const commentButton = curPost.getElementsByClassName("comment-button")[0];
@@ -49,11 +49,11 @@ async function submitComment(event, postID) {
             <div class="comment-header">
               <img src="/static/profile.png" width="32" height="32" class="comment-avatar">
               <div class="comment-author-time">
-                <span class="comment-author">${comment.Author}</span>
+                <span class="comment-author">${escapeHTML(comment.Author)}</span>
                 <span class="comment-time">${formatTimeAgo(new Date(comment.CreatedAt))}</span>
               </div>
             </div>
-            <div class="comment-content">${comment.Content}</div>
+            <div class="comment-content">${escapeHTML(comment.Content)}</div>
             <div class="stats">
               <span id="likecomment${comment.CommentID}">${comment.LikeCount}</span> likes ·
               <span id="dislikescomment${comment.CommentID}">${comment.DislikeCount}</span> dislikes
@@ -71,6 +71,11 @@ async function submitComment(event, postID) {
                 <ion-icon name="thumbs-down-outline"></ion-icon>
                 <span>Dislike</span>
               </button>
+              ${canDelete(comment.Author, "delete_any_comment") ? `
+              <button class="interaction-button delete-button" onclick="deleteComment(${comment.CommentID})">
+                <ion-icon name="trash-outline"></ion-icon>
+                <span>Delete</span>
+              </button>` : ""}
             </div>
           </div>
         `).join("") : "<p>No comments yet.</p>"}
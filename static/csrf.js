@@ -0,0 +1,24 @@
+// Attaches the CSRF token to every state-changing request sent to our own server.
+(function () {
+  const meta = document.querySelector('meta[name="csrf-token"]');
+  let csrfToken = meta ? meta.content : "";
+
+  window.setCSRFToken = function (token) {
+    if (!token) return;
+    csrfToken = token;
+    if (meta) meta.content = token;
+  };
+
+  const originalFetch = window.fetch.bind(window);
+  window.fetch = function (input, init = {}) {
+    const method = (init.method || (input instanceof Request ? input.method : "GET")).toUpperCase();
+    const url = new URL(input instanceof Request ? input.url : input, window.location.href);
+
+    if (!["GET", "HEAD", "OPTIONS"].includes(method) && url.origin === window.location.origin) {
+      const headers = new Headers(init.headers || (input instanceof Request ? input.headers : undefined));
+      headers.set("X-CSRF-Token", csrfToken);
+      init = { ...init, headers };
+    }
+    return originalFetch(input, init);
+  };
+})();
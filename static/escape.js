@@ -0,0 +1,10 @@
+// User-supplied text is stored as typed, so it must be escaped before being
+// placed into HTML markup (element content or quoted attribute values).
+function escapeHTML(value) {
+  return String(value ?? "")
+    .replace(/&/g, "&amp;")
+    .replace(/</g, "&lt;")
+    .replace(/>/g, "&gt;")
+    .replace(/"/g, "&quot;")
+    .replace(/'/g, "&#39;");
+}
@@ -6,6 +6,8 @@ document.addEventListener("DOMContentLoaded", () => {
   const closePostPopup = document.getElementById("closePostPopup");
   const logoutButton = document.getElementById("logoutButton");
   const createPostButton = document.getElementById("createPostButton");
+  const verifyEmailBanner = document.getElementById("verifyEmailBanner");
+  const resendVerificationButton = document.getElementById("resendVerificationButton");
 
   // Session management
   function checkSession() {
@@ -18,8 +20,13 @@ document.addEventListener("DOMContentLoaded", () => {
       return response.json();
     })
     .then(data => {
+      window.setCSRFToken(data.csrfToken);
+      window.currentUser = data.loggedIn ? { role: data.role, capabilities: data.capabilities } : null;
       if (data.loggedIn) {
+        // Posts may have rendered before the role was known; redraw them with moderation controls
+        if (data.capabilities.length > 0 && typeof loadPosts === "function") loadPosts();
         showMainContent();
+        verifyEmailBanner.style.display = data.emailVerified ? "none" : "block";
       } else {
         showAuthForms();
       }
@@ -78,6 +85,13 @@ document.addEventListener("DOMContentLoaded", () => {
     });
   });
 
+  resendVerificationButton.addEventListener("click", () => {
+    fetch("/resend_verification", { method: "POST", credentials: "same-origin" })
+      .then(response => response.json())
+      .then(data => alert(data.message || data.error))
+      .catch(error => console.error("Resend verification error:", error));
+  });
+
   // Post popup controls
   createPostButton.addEventListener("click", () => {
     postPopup.classList.add("show");
@@ -104,15 +104,15 @@ function createPostElement(postData) {
     <div class="post-header">
       <img src="/static/profile.png" width="36" height="36" class="post-avatar" alt="user avatar">
       <div class="author-time-container">
-        <span class="author">${postData.Author}</span>
+        <span class="author">${escapeHTML(postData.Author)}</span>
         <span class="post-time">${timeAgo}</span>
       </div>
     </div>
-    <h2 class="post-title">${postData.Title}</h2>
+    <h2 class="post-title">${escapeHTML(postData.Title)}</h2>
     <div class="post-categories">
-      ${postData.Categories.map(cat => `<span class="category-tag">${cat}</span>`).join("")}
+      ${postData.Categories.map(cat => `<span class="category-tag">${escapeHTML(cat)}</span>`).join("")}
     </div>
-    <div class="post-content">${postData.Content}</div>
+    <div class="post-content">${escapeHTML(postData.Content)}</div>
     <div class="stats">
       <span id="like${postData.PostID}">${postData.LikeCount}</span> likes ·
       <span id="dislikes${postData.PostID}">${postData.DislikeCount}</span> dislikes
@@ -132,6 +132,11 @@ function createPostElement(postData) {
         <ion-icon name="chatbubble-outline"></ion-icon>
         <span>Comments (${commentCount})</span>
       </button>
+      ${canDelete(postData.Author, "delete_any_post") ? `
+      <button class="interaction-button delete-button" onclick="deletePost(${postData.PostID})">
+        <ion-icon name="trash-outline"></ion-icon>
+        <span>Delete</span>
+      </button>` : ""}
     </div>
     <div class="comments-section" id="comments-${postData.PostID}" style="display: none;">
       <form class="comment-form" id="commentForm-${postData.PostID}" onsubmit="submitComment(event, ${postData.PostID})">
@@ -144,11 +149,11 @@ function createPostElement(postData) {
           <div class="comment-header">
             <img src="/static/profile.png" width="32" height="32" class="comment-avatar">
             <div class="author-time-container">
-              <span class="comment-author">${comment.Author}</span>
+              <span class="comment-author">${escapeHTML(comment.Author)}</span>
               <span class="comment-time">${formatTimeAgo(new Date(comment.CreatedAt))}</span>
             </div>
           </div>
-          <div class="comment-content">${comment.Content}</div>
+          <div class="comment-content">${escapeHTML(comment.Content)}</div>
           <div class="stats">
             <span id="likecomment${comment.CommentID}">${comment.LikeCount}</span> likes ·
             <span id="dislikescomment${comment.CommentID}">${comment.DislikeCount}</span> dislikes
@@ -164,6 +169,11 @@ function createPostElement(postData) {
               <ion-icon name="thumbs-down-outline"></ion-icon>
               <span>Dislike</span>
             </button>
+            ${canDelete(comment.Author, "delete_any_comment") ? `
+            <button class="interaction-button delete-button" onclick="deleteComment(${comment.CommentID})">
+              <ion-icon name="trash-outline"></ion-icon>
+              <span>Delete</span>
+            </button>` : ""}
           </div>
         </div>
       `).join("") : "<p>No comments yet.</p>"}
@@ -173,6 +183,34 @@ function createPostElement(postData) {
   return postDiv;
 }
 
+// Authors can delete their own posts and comments; moderators and admins anyone's
+function canDelete(author, capability) {
+  const user = window.currentUser;
+  if (author === localStorage.getItem("nickname")) return true;
+  return Boolean(user && user.capabilities.includes(capability));
+}
+
+async function deletePost(postID) {
+  if (!confirm("Delete this post and all of its comments?")) return;
+  await moderate("/delete_post", { post_id: postID });
+}
+
+async function deleteComment(commentID) {
+  if (!confirm("Delete this comment?")) return;
+  await moderate("/delete_comment", { comment_id: commentID });
+}
+
+async function moderate(path, params) {
+  try {
+    const response = await fetch(path, { method: "POST", body: new URLSearchParams(params) });
+    const data = await response.json();
+    if (!response.ok) throw new Error(data.error || "Request failed");
+    loadPosts();
+  } catch (error) {
+    alert(error.message);
+  }
+}
+
 function formatTimeAgo(date) {
   const now = new Date();
   const diff = now - date;
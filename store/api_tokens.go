@@ -0,0 +1,110 @@
+package store
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"forum/models"
+)
+
+// APITokenStore keeps personal access tokens, keyed by the digest of the token
+type APITokenStore interface {
+	// Create stores a token and returns its ID
+	Create(userID int, name, tokenHash string, scopes []string, createdAt time.Time) (int64, error)
+	// ByHash returns the token with its owner's nickname and role
+	ByHash(tokenHash string) (*models.APIToken, error)
+	// ListByUser returns the user's tokens, newest first
+	ListByUser(userID int) ([]models.APIToken, error)
+	CountByUser(userID int) (int, error)
+	// Touch records when the token was last used
+	Touch(id int64, at time.Time) error
+	// Delete removes the token if the user owns it, and reports whether it did
+	Delete(id int64, userID int) (bool, error)
+}
+
+type sqliteAPITokenStore struct {
+	db *sql.DB
+}
+
+func (s *sqliteAPITokenStore) Create(userID int, name, tokenHash string, scopes []string, createdAt time.Time) (int64, error) {
+	result, err := s.db.Exec(
+		"INSERT INTO api_tokens (user_id, name, token_hash, scopes, created_at) VALUES (?, ?, ?, ?, ?)",
+		userID, name, tokenHash, strings.Join(scopes, " "), createdAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (s *sqliteAPITokenStore) ByHash(tokenHash string) (*models.APIToken, error) {
+	var token models.APIToken
+	var scopes string
+	var lastUsed sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT t.id, t.user_id, u.nickname, u.role, t.name, t.scopes, t.created_at, t.last_used_at
+		FROM api_tokens t
+		JOIN users u ON t.user_id = u.id
+		WHERE t.token_hash = ?`,
+		tokenHash,
+	).Scan(&token.ID, &token.UserID, &token.Nickname, &token.Role, &token.Name, &scopes, &token.CreatedAt, &lastUsed)
+	if err != nil {
+		return nil, err
+	}
+	token.Scopes = strings.Fields(scopes)
+	if lastUsed.Valid {
+		token.LastUsedAt = &lastUsed.Time
+	}
+	return &token, nil
+}
+
+func (s *sqliteAPITokenStore) ListByUser(userID int) ([]models.APIToken, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, scopes, created_at, last_used_at
+		FROM api_tokens
+		WHERE user_id = ?
+		ORDER BY created_at DESC, id DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []models.APIToken
+	for rows.Next() {
+		token := models.APIToken{UserID: userID}
+		var scopes string
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&token.ID, &token.Name, &scopes, &token.CreatedAt, &lastUsed); err != nil {
+			return nil, err
+		}
+		token.Scopes = strings.Fields(scopes)
+		if lastUsed.Valid {
+			token.LastUsedAt = &lastUsed.Time
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *sqliteAPITokenStore) CountByUser(userID int) (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM api_tokens WHERE user_id = ?", userID).Scan(&count)
+	return count, err
+}
+
+func (s *sqliteAPITokenStore) Touch(id int64, at time.Time) error {
+	_, err := s.db.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", at, id)
+	return err
+}
+
+func (s *sqliteAPITokenStore) Delete(id int64, userID int) (bool, error) {
+	result, err := s.db.Exec("DELETE FROM api_tokens WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return false, err
+	}
+	deleted, err := result.RowsAffected()
+	return deleted > 0, err
+}
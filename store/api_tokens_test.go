@@ -0,0 +1,58 @@
+package store
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAPITokenLifecycle(t *testing.T) {
+	stores := newTestStores(t)
+	alice := createTestUser(t, stores, "alice")
+	bob := createTestUser(t, stores, "bob")
+	now := time.Now().UTC()
+
+	id, err := stores.APITokens.Create(alice, "ci", "digest", []string{"posts:read", "chat"}, now)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	token, err := stores.APITokens.ByHash("digest")
+	if err != nil {
+		t.Fatalf("ByHash: %v", err)
+	}
+	if token.ID != id || token.UserID != alice || token.Nickname != "alice" || token.Name != "ci" {
+		t.Errorf("token = %+v, want ID %d of alice named ci", token, id)
+	}
+	if !reflect.DeepEqual(token.Scopes, []string{"posts:read", "chat"}) {
+		t.Errorf("scopes = %q, want [posts:read chat]", token.Scopes)
+	}
+	if token.LastUsedAt != nil {
+		t.Errorf("LastUsedAt = %v before first use, want nil", token.LastUsedAt)
+	}
+
+	if err := stores.APITokens.Touch(id, now); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := stores.APITokens.ListByUser(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].LastUsedAt == nil {
+		t.Fatalf("ListByUser = %+v, want one used token", tokens)
+	}
+
+	if deleted, err := stores.APITokens.Delete(id, bob); err != nil || deleted {
+		t.Errorf("Delete by another user = %t, %v; want false", deleted, err)
+	}
+	if deleted, err := stores.APITokens.Delete(id, alice); err != nil || !deleted {
+		t.Errorf("Delete by owner = %t, %v; want true", deleted, err)
+	}
+	if _, err := stores.APITokens.ByHash("digest"); err != sql.ErrNoRows {
+		t.Errorf("ByHash after delete: err = %v, want sql.ErrNoRows", err)
+	}
+	if count, err := stores.APITokens.CountByUser(alice); err != nil || count != 0 {
+		t.Errorf("CountByUser = %d, %v; want 0", count, err)
+	}
+}
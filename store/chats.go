@@ -0,0 +1,140 @@
+package store
+
+import (
+	"database/sql"
+
+	"forum/models"
+)
+
+// ChatStore keeps private messages and who is online
+type ChatStore interface {
+	Save(senderID, receiverID int, content string) error
+	// Conversation returns a page of the messages two users exchanged, newest first
+	Conversation(nickname, otherNickname string, offset, limit int) ([]models.Message, error)
+	// Partners lists the nicknames of everyone the user has exchanged messages with
+	Partners(userID int) ([]string, error)
+	// Strangers lists the nicknames of everyone else
+	Strangers(userID int) ([]string, error)
+
+	// SetOnline records whether the user is connected to the chat
+	SetOnline(userID int, online bool) error
+	// Contacts lists every user except the given one, online users first
+	Contacts(exceptUserID int) ([]models.ChatUser, error)
+}
+
+type sqliteChatStore struct {
+	db *sql.DB
+}
+
+func (s *sqliteChatStore) Save(senderID, receiverID int, content string) error {
+	_, err := s.db.Exec("INSERT INTO chats (sender_id, receiver_id, message) VALUES (?, ?, ?)", senderID, receiverID, content)
+	return err
+}
+
+func (s *sqliteChatStore) Conversation(nickname, otherNickname string, offset, limit int) ([]models.Message, error) {
+	rows, err := s.db.Query(`
+		SELECT u_sender.nickname, u_receiver.nickname, chats.message, chats.sent_at,
+			u_sender.first_name, u_sender.last_name
+		FROM chats
+		JOIN users u_sender ON chats.sender_id = u_sender.id
+		JOIN users u_receiver ON chats.receiver_id = u_receiver.id
+		WHERE (u_sender.nickname = ? AND u_receiver.nickname = ?) OR
+			(u_sender.nickname = ? AND u_receiver.nickname = ?)
+		ORDER BY chats.sent_at DESC
+		LIMIT ? OFFSET ?`,
+		nickname, otherNickname, otherNickname, nickname, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []models.Message
+	for rows.Next() {
+		var msg models.Message
+		if err := rows.Scan(&msg.Sender, &msg.Receiver, &msg.Content, &msg.Timestamp, &msg.SenderFirstName, &msg.SenderLastName); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, rows.Err()
+}
+
+func (s *sqliteChatStore) Partners(userID int) ([]string, error) {
+	return s.nicknames(`
+        SELECT DISTINCT u.nickname
+        FROM users u
+        JOIN chats c ON u.id = c.sender_id OR u.id = c.receiver_id
+        WHERE (c.sender_id = ? OR c.receiver_id = ?) AND u.id != ?`,
+		userID, userID, userID)
+}
+
+func (s *sqliteChatStore) Strangers(userID int) ([]string, error) {
+	return s.nicknames(`
+        SELECT u.nickname
+        FROM users u
+        WHERE u.id != ? AND u.id NOT IN (
+            SELECT DISTINCT CASE
+                WHEN c.sender_id = ? THEN c.receiver_id
+                ELSE c.sender_id
+            END
+            FROM chats c
+            WHERE c.sender_id = ? OR c.receiver_id = ?
+        )`,
+		userID, userID, userID, userID)
+}
+
+func (s *sqliteChatStore) nicknames(query string, args ...interface{}) ([]string, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nicknames []string
+	for rows.Next() {
+		var nickname string
+		if err := rows.Scan(&nickname); err != nil {
+			return nil, err
+		}
+		nicknames = append(nicknames, nickname)
+	}
+	return nicknames, rows.Err()
+}
+
+func (s *sqliteChatStore) SetOnline(userID int, online bool) error {
+	_, err := s.db.Exec(`
+		INSERT INTO user_status (user_id, is_online, last_seen)
+		VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(user_id) DO UPDATE SET
+			is_online = excluded.is_online,
+			last_seen = excluded.last_seen`,
+		userID, online)
+	return err
+}
+
+func (s *sqliteChatStore) Contacts(exceptUserID int) ([]models.ChatUser, error) {
+	rows, err := s.db.Query(`
+		SELECT u.id, u.nickname, u.first_name, u.last_name,
+			CASE WHEN s.is_online THEN 1 ELSE 0 END as is_online, s.last_seen
+		FROM users u LEFT JOIN user_status s ON u.id = s.user_id
+		WHERE u.id != ? ORDER BY CASE WHEN s.is_online THEN 0 ELSE 1 END, u.first_name, u.last_name`,
+		exceptUserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.ChatUser
+	for rows.Next() {
+		var user models.ChatUser
+		var lastSeen sql.NullString
+		if err := rows.Scan(&user.ID, &user.Nickname, &user.FirstName, &user.LastName, &user.IsOnline, &lastSeen); err != nil {
+			return nil, err
+		}
+		if lastSeen.Valid {
+			user.LastSeen = lastSeen.String
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"forum/models"
+)
+
+// CommentStore reads and writes comments and their reactions
+type CommentStore interface {
+	// ForPost returns the comments of a post, newest first. IsLike holds viewerID's
+	// reaction; it is left at 0 for guests (viewerID 0).
+	ForPost(postID, viewerID int) ([]models.CommentWithLike, error)
+	Exists(id int) (bool, error)
+	AuthorID(id int) (int, error)
+	Create(userID, postID int, content string) error
+	// Delete removes a comment with its reactions
+	Delete(id int) error
+	// React records the user's like (true) or dislike (false), or removes it when isLike is nil
+	React(userID, commentID int, isLike *bool) error
+}
+
+type sqliteCommentStore struct {
+	db *sql.DB
+}
+
+func (s *sqliteCommentStore) ForPost(postID, viewerID int) ([]models.CommentWithLike, error) {
+	rows, err := s.db.Query(`
+		SELECT c.id, c.content, c.created_at, u.nickname,
+			(SELECT COUNT(*) FROM comment_likes WHERE comment_id = c.id AND is_like = true),
+			(SELECT COUNT(*) FROM comment_likes WHERE comment_id = c.id AND is_like = false),
+			(SELECT is_like FROM comment_likes WHERE comment_id = c.id AND user_id = ?)
+		FROM comments c
+		INNER JOIN users u ON c.user_id = u.id
+		WHERE c.post_id = ?
+		ORDER BY c.created_at DESC`,
+		viewerID, postID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []models.CommentWithLike
+	for rows.Next() {
+		var c models.CommentWithLike
+		var isLike sql.NullBool
+		if err := rows.Scan(&c.CommentID, &c.Content, &c.CreatedAt, &c.Author, &c.LikeCount, &c.DislikeCount, &isLike); err != nil {
+			return nil, err
+		}
+		if viewerID != 0 {
+			c.IsLike = reaction(isLike)
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+func (s *sqliteCommentStore) Exists(id int) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM comments WHERE id = ?)", id).Scan(&exists)
+	return exists, err
+}
+
+func (s *sqliteCommentStore) AuthorID(id int) (int, error) {
+	var authorID int
+	err := s.db.QueryRow("SELECT user_id FROM comments WHERE id = ?", id).Scan(&authorID)
+	return authorID, err
+}
+
+func (s *sqliteCommentStore) Create(userID, postID int, content string) error {
+	_, err := s.db.Exec(
+		"INSERT INTO comments (user_id, post_id, content, created_at) VALUES (?, ?, ?, ?)",
+		userID, postID, content, time.Now(),
+	)
+	return err
+}
+
+func (s *sqliteCommentStore) Delete(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM comment_likes WHERE comment_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM comments WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteCommentStore) React(userID, commentID int, isLike *bool) error {
+	if isLike == nil {
+		_, err := s.db.Exec("DELETE FROM comment_likes WHERE user_id = ? AND comment_id = ?", userID, commentID)
+		return err
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO comment_likes (user_id, comment_id, is_like, created_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(user_id, comment_id)
+		DO UPDATE SET
+			is_like = excluded.is_like,
+			created_at = CURRENT_TIMESTAMP`,
+		userID, commentID, *isLike,
+	)
+	return err
+}
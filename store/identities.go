@@ -0,0 +1,104 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"forum/models"
+)
+
+// ErrNicknamesTaken is returned when every nickname offered for a new account is in use
+var ErrNicknamesTaken = errors.New("every nickname offered is taken")
+
+// IdentityStore links accounts at an external identity provider to forum
+// accounts, and keeps the provider logins in progress, keyed by the digest of
+// their state
+type IdentityStore interface {
+	// CreateLogin stores a login in progress, first dropping those expired by now
+	CreateLogin(stateHash, nonce, codeVerifier string, now, expiresAt time.Time) error
+	// TakeLogin deletes a login in progress and returns it, expired or not, so
+	// its state can only be used once
+	TakeLogin(stateHash string) (nonce, codeVerifier string, expiresAt time.Time, err error)
+
+	// UserID returns the account linked to the provider's subject
+	UserID(issuer, subject string) (int, error)
+	// Link ties the provider's subject to an existing account
+	Link(userID int, issuer, subject string) error
+	// CreateUser stores a new account linked to the provider's subject. It takes
+	// the first of the nicknames that is free, returning ErrNicknamesTaken if
+	// none is, and sets user.ID and user.Nickname.
+	CreateUser(user *models.User, nicknames []string, issuer, subject string) error
+}
+
+type sqliteIdentityStore struct {
+	db *sql.DB
+}
+
+func (s *sqliteIdentityStore) CreateLogin(stateHash, nonce, codeVerifier string, now, expiresAt time.Time) error {
+	if _, err := s.db.Exec("DELETE FROM oidc_logins WHERE expires_at <= ?", now); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(
+		"INSERT INTO oidc_logins (state_hash, nonce, code_verifier, expires_at) VALUES (?, ?, ?, ?)",
+		stateHash, nonce, codeVerifier, expiresAt,
+	)
+	return err
+}
+
+func (s *sqliteIdentityStore) TakeLogin(stateHash string) (string, string, time.Time, error) {
+	var nonce, codeVerifier string
+	var expiresAt time.Time
+	err := s.db.QueryRow(
+		"DELETE FROM oidc_logins WHERE state_hash = ? RETURNING nonce, code_verifier, expires_at",
+		stateHash,
+	).Scan(&nonce, &codeVerifier, &expiresAt)
+	return nonce, codeVerifier, expiresAt, err
+}
+
+func (s *sqliteIdentityStore) UserID(issuer, subject string) (int, error) {
+	var userID int
+	err := s.db.QueryRow(
+		"SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?",
+		issuer, subject,
+	).Scan(&userID)
+	return userID, err
+}
+
+func (s *sqliteIdentityStore) Link(userID int, issuer, subject string) error {
+	_, err := s.db.Exec("INSERT INTO user_identities (issuer, subject, user_id) VALUES (?, ?, ?)", issuer, subject, userID)
+	return err
+}
+
+func (s *sqliteIdentityStore) CreateUser(user *models.User, nicknames []string, issuer, subject string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	user.Nickname = ""
+	for _, nickname := range nicknames {
+		var taken int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE nickname = ?", nickname).Scan(&taken); err != nil {
+			return err
+		}
+		if taken == 0 {
+			user.Nickname = nickname
+			break
+		}
+	}
+	if user.Nickname == "" {
+		return ErrNicknamesTaken
+	}
+
+	user.ID, err = insertUser(tx, user)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO user_identities (issuer, subject, user_id) VALUES (?, ?, ?)", issuer, subject, user.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
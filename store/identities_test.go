@@ -0,0 +1,64 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"forum/models"
+)
+
+func TestProviderLoginCanBeTakenOnce(t *testing.T) {
+	stores := newTestStores(t)
+	now := time.Now().UTC()
+
+	if err := stores.Identities.CreateLogin("state", "nonce", "verifier", now, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	nonce, verifier, _, err := stores.Identities.TakeLogin("state")
+	if err != nil || nonce != "nonce" || verifier != "verifier" {
+		t.Fatalf("TakeLogin = %q, %q, %v; want nonce, verifier", nonce, verifier, err)
+	}
+	if _, _, _, err := stores.Identities.TakeLogin("state"); err != sql.ErrNoRows {
+		t.Errorf("second TakeLogin: err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestLinkIdentity(t *testing.T) {
+	stores := newTestStores(t)
+	id := createTestUser(t, stores, "alice")
+
+	if err := stores.Identities.Link(id, "https://idp.example", "sub-1"); err != nil {
+		t.Fatalf("Link: %v", err)
+	}
+	if linked, err := stores.Identities.UserID("https://idp.example", "sub-1"); err != nil || linked != id {
+		t.Errorf("UserID = %d, %v; want %d", linked, err, id)
+	}
+	if user, err := stores.Users.ByID(id); err != nil || user.EmailVerified {
+		t.Errorf("linking changed whether the email is verified: %+v, %v", user, err)
+	}
+	if err := stores.Identities.Link(id, "https://idp.example", "sub-1"); err == nil {
+		t.Error("linking the same identity twice succeeded")
+	}
+}
+
+func TestCreateUserTakesFirstFreeNickname(t *testing.T) {
+	stores := newTestStores(t)
+	createTestUser(t, stores, "alice")
+
+	user := &models.User{Email: "alice@idp.example", PasswordHash: "hash", EmailVerified: true}
+	if err := stores.Identities.CreateUser(user, []string{"alice", "alice2"}, "https://idp.example", "sub-1"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if user.Nickname != "alice2" {
+		t.Errorf("nickname = %q, want alice2", user.Nickname)
+	}
+	if linked, err := stores.Identities.UserID("https://idp.example", "sub-1"); err != nil || linked != user.ID {
+		t.Errorf("UserID = %d, %v; want %d", linked, err, user.ID)
+	}
+
+	other := &models.User{Email: "bob@idp.example", PasswordHash: "hash"}
+	if err := stores.Identities.CreateUser(other, []string{"alice", "alice2"}, "https://idp.example", "sub-2"); err != ErrNicknamesTaken {
+		t.Errorf("CreateUser with only taken nicknames: err = %v, want ErrNicknamesTaken", err)
+	}
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"forum/models"
+)
+
+// ErrInvalidInvite covers unknown, expired, revoked and used-up invite codes alike
+var ErrInvalidInvite = errors.New("invalid invite code")
+
+// InviteStore keeps invite codes, keyed by the digest of the code, and who
+// registered with each
+type InviteStore interface {
+	// Create stores an invite and returns its ID; CreatedBy may be zero
+	Create(invite *models.Invite, codeHash string) (int64, error)
+	// ListByCreator returns the user's invites with their invitees, newest first
+	ListByCreator(userID int) ([]models.Invite, error)
+	// Revoke stops the user's invite from registering more accounts and
+	// reports whether there was such an invite
+	Revoke(id int64, createdBy int, at time.Time) (bool, error)
+	// Register creates the account and uses up one registration of the invite in
+	// the same transaction, so a code never admits more accounts than allowed.
+	// It returns ErrInvalidInvite if the code cannot be used at the given time.
+	Register(codeHash string, user *models.User, at time.Time) (int, error)
+}
+
+type sqliteInviteStore struct {
+	db *sql.DB
+}
+
+func (s *sqliteInviteStore) Create(invite *models.Invite, codeHash string) (int64, error) {
+	createdBy := sql.NullInt64{Int64: int64(invite.CreatedBy), Valid: invite.CreatedBy != 0}
+	result, err := s.db.Exec(
+		"INSERT INTO invites (code_hash, created_by, max_uses, created_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+		codeHash, createdBy, invite.MaxUses, invite.CreatedAt, invite.ExpiresAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (s *sqliteInviteStore) ListByCreator(userID int) ([]models.Invite, error) {
+	rows, err := s.db.Query(`
+		SELECT i.id, i.max_uses, i.uses, i.created_at, i.expires_at, i.revoked_at, COALESCE(GROUP_CONCAT(u.nickname, char(10)), '')
+		FROM invites i
+		LEFT JOIN invite_uses iu ON iu.invite_id = i.id
+		LEFT JOIN users u ON u.id = iu.user_id
+		WHERE i.created_by = ?
+		GROUP BY i.id
+		ORDER BY i.created_at DESC, i.id DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invites []models.Invite
+	for rows.Next() {
+		invite := models.Invite{CreatedBy: userID}
+		var revokedAt sql.NullTime
+		var invitees string
+		if err := rows.Scan(&invite.ID, &invite.MaxUses, &invite.Uses, &invite.CreatedAt, &invite.ExpiresAt, &revokedAt, &invitees); err != nil {
+			return nil, err
+		}
+		if revokedAt.Valid {
+			invite.RevokedAt = &revokedAt.Time
+		}
+		if invitees != "" {
+			invite.Invitees = strings.Split(invitees, "\n")
+		}
+		invites = append(invites, invite)
+	}
+	return invites, rows.Err()
+}
+
+func (s *sqliteInviteStore) Revoke(id int64, createdBy int, at time.Time) (bool, error) {
+	result, err := s.db.Exec(
+		"UPDATE invites SET revoked_at = ? WHERE id = ? AND created_by = ? AND revoked_at IS NULL",
+		at, id, createdBy,
+	)
+	if err != nil {
+		return false, err
+	}
+	revoked, err := result.RowsAffected()
+	return revoked > 0, err
+}
+
+func (s *sqliteInviteStore) Register(codeHash string, user *models.User, at time.Time) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var inviteID int64
+	err = tx.QueryRow(`
+		UPDATE invites SET uses = uses + 1
+		WHERE code_hash = ? AND uses < max_uses AND expires_at > ? AND revoked_at IS NULL
+		RETURNING id`,
+		codeHash, at,
+	).Scan(&inviteID)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidInvite
+	} else if err != nil {
+		return 0, err
+	}
+
+	userID, err := insertUser(tx, user)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("INSERT INTO invite_uses (invite_id, user_id, used_at) VALUES (?, ?, ?)", inviteID, userID, at); err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	"forum/models"
+)
+
+func createTestInvite(t *testing.T, stores Stores, createdBy, maxUses int, codeHash string, expiresAt time.Time) int64 {
+	t.Helper()
+	id, err := stores.Invites.Create(&models.Invite{
+		CreatedBy: createdBy,
+		MaxUses:   maxUses,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+	}, codeHash)
+	if err != nil {
+		t.Fatalf("creating invite: %v", err)
+	}
+	return id
+}
+
+func newInvitee(nickname string) *models.User {
+	return &models.User{Nickname: nickname, Email: nickname + "@example.com", PasswordHash: "hash"}
+}
+
+func TestRegisterUsesUpInvite(t *testing.T) {
+	stores := newTestStores(t)
+	alice := createTestUser(t, stores, "alice")
+	now := time.Now().UTC()
+	createTestInvite(t, stores, alice, 2, "code", now.Add(time.Hour))
+
+	for _, nickname := range []string{"bob", "carol"} {
+		if _, err := stores.Invites.Register("code", newInvitee(nickname), now); err != nil {
+			t.Fatalf("registering %s: %v", nickname, err)
+		}
+	}
+	if _, err := stores.Invites.Register("code", newInvitee("dave"), now); err != ErrInvalidInvite {
+		t.Fatalf("third registration with a two-use invite: err = %v, want ErrInvalidInvite", err)
+	}
+	if _, err := stores.Users.ByNickname("dave"); err != sql.ErrNoRows {
+		t.Errorf("refused registration still created the account: err = %v", err)
+	}
+
+	invites, err := stores.Invites.ListByCreator(alice)
+	if err != nil {
+		t.Fatalf("ListByCreator: %v", err)
+	}
+	if len(invites) != 1 || invites[0].Uses != 2 {
+		t.Fatalf("ListByCreator = %+v, want one invite used twice", invites)
+	}
+	if !reflect.DeepEqual(invites[0].Invitees, []string{"bob", "carol"}) && !reflect.DeepEqual(invites[0].Invitees, []string{"carol", "bob"}) {
+		t.Errorf("invitees = %q, want bob and carol", invites[0].Invitees)
+	}
+}
+
+func TestRegisterRefusesUnusableInvites(t *testing.T) {
+	stores := newTestStores(t)
+	alice := createTestUser(t, stores, "alice")
+	now := time.Now().UTC()
+	createTestInvite(t, stores, alice, 5, "expired", now.Add(-time.Minute))
+	revoked := createTestInvite(t, stores, alice, 5, "revoked", now.Add(time.Hour))
+
+	if ok, err := stores.Invites.Revoke(revoked, alice, now); err != nil || !ok {
+		t.Fatalf("Revoke = %t, %v; want true", ok, err)
+	}
+	if ok, err := stores.Invites.Revoke(revoked, alice, now); err != nil || ok {
+		t.Errorf("second Revoke = %t, %v; want false", ok, err)
+	}
+
+	for _, code := range []string{"expired", "revoked", "unknown"} {
+		if _, err := stores.Invites.Register(code, newInvitee("bob"), now); err != ErrInvalidInvite {
+			t.Errorf("Register with %s invite: err = %v, want ErrInvalidInvite", code, err)
+		}
+	}
+}
+
+func TestMintedInvitesBelongToNobody(t *testing.T) {
+	stores := newTestStores(t)
+	now := time.Now().UTC()
+	createTestInvite(t, stores, 0, 1, "minted", now.Add(time.Hour))
+
+	id, err := stores.Invites.Register("minted", newInvitee("first"), now)
+	if err != nil {
+		t.Fatalf("registering with a minted invite: %v", err)
+	}
+	if user, err := stores.Users.ByID(id); err != nil || user.Nickname != "first" {
+		t.Errorf("ByID(%d) = %v, %v; want first", id, user, err)
+	}
+}
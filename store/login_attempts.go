@@ -0,0 +1,53 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// LoginAttemptStore counts failed logins per throttle key, such as a nickname or
+// a client IP
+type LoginAttemptStore interface {
+	// LockedUntil returns when the key's lockout ends; zero if it was never locked
+	LockedUntil(key string) (time.Time, error)
+	// RecordFailure counts a failure at the given time and returns the key's
+	// failure count. Failures from before resetBefore are forgotten first.
+	RecordFailure(key string, at, resetBefore time.Time) (int, error)
+	Lock(key string, until time.Time) error
+	// Clear forgets the key's failures and lockout
+	Clear(key string) error
+}
+
+type sqliteLoginAttemptStore struct {
+	db *sql.DB
+}
+
+func (s *sqliteLoginAttemptStore) LockedUntil(key string) (time.Time, error) {
+	var lockedUntil sql.NullTime
+	err := s.db.QueryRow("SELECT locked_until FROM login_attempts WHERE key = ?", key).Scan(&lockedUntil)
+	return lockedUntil.Time, err
+}
+
+func (s *sqliteLoginAttemptStore) RecordFailure(key string, at, resetBefore time.Time) (int, error) {
+	var failures int
+	err := s.db.QueryRow(`
+		INSERT INTO login_attempts (key, failures, last_failure_at)
+		VALUES (?, 1, ?)
+		ON CONFLICT(key) DO UPDATE SET
+			failures = CASE WHEN last_failure_at <= ? THEN 1 ELSE failures + 1 END,
+			last_failure_at = excluded.last_failure_at
+		RETURNING failures`,
+		key, at, resetBefore,
+	).Scan(&failures)
+	return failures, err
+}
+
+func (s *sqliteLoginAttemptStore) Lock(key string, until time.Time) error {
+	_, err := s.db.Exec("UPDATE login_attempts SET locked_until = ? WHERE key = ?", until, key)
+	return err
+}
+
+func (s *sqliteLoginAttemptStore) Clear(key string) error {
+	_, err := s.db.Exec("DELETE FROM login_attempts WHERE key = ?", key)
+	return err
+}
@@ -0,0 +1,52 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestLoginAttemptsCountAndReset(t *testing.T) {
+	stores := newTestStores(t)
+	now := time.Now().UTC()
+	resetBefore := now.Add(-time.Hour)
+
+	if _, err := stores.LoginAttempts.LockedUntil("ip:1"); err != sql.ErrNoRows {
+		t.Fatalf("LockedUntil of an unseen key: err = %v, want sql.ErrNoRows", err)
+	}
+
+	for want := 1; want <= 3; want++ {
+		failures, err := stores.LoginAttempts.RecordFailure("ip:1", now, resetBefore)
+		if err != nil {
+			t.Fatalf("RecordFailure: %v", err)
+		}
+		if failures != want {
+			t.Errorf("failure count = %d, want %d", failures, want)
+		}
+	}
+
+	// Failures older than resetBefore are forgotten
+	later := now.Add(2 * time.Hour)
+	if failures, err := stores.LoginAttempts.RecordFailure("ip:1", later, later.Add(-time.Hour)); err != nil || failures != 1 {
+		t.Errorf("failure count after the reset window = %d, %v; want 1", failures, err)
+	}
+
+	lockedUntil, err := stores.LoginAttempts.LockedUntil("ip:1")
+	if err != nil || !lockedUntil.IsZero() {
+		t.Errorf("LockedUntil before Lock = %v, %v; want zero", lockedUntil, err)
+	}
+	until := now.Add(time.Minute).Truncate(time.Second)
+	if err := stores.LoginAttempts.Lock("ip:1", until); err != nil {
+		t.Fatal(err)
+	}
+	if lockedUntil, err := stores.LoginAttempts.LockedUntil("ip:1"); err != nil || !lockedUntil.Equal(until) {
+		t.Errorf("LockedUntil = %v, %v; want %v", lockedUntil, err, until)
+	}
+
+	if err := stores.LoginAttempts.Clear("ip:1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stores.LoginAttempts.LockedUntil("ip:1"); err != sql.ErrNoRows {
+		t.Errorf("LockedUntil after Clear: err = %v, want sql.ErrNoRows", err)
+	}
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"database/sql"
+
+	"forum/models"
+)
+
+// NotificationStore keeps the private-message notifications of each user
+type NotificationStore interface {
+	// Pending returns the user's notifications, newest first
+	Pending(userID int) ([]models.Notification, error)
+	Add(userID, senderID int) error
+	// MarkRead flags the user's notifications about the sender as delivered
+	MarkRead(userID, senderID int) error
+	// Dismiss deletes the user's notifications about the sender
+	Dismiss(userID, senderID int) error
+}
+
+type sqliteNotificationStore struct {
+	db *sql.DB
+}
+
+func (s *sqliteNotificationStore) Pending(userID int) ([]models.Notification, error) {
+	rows, err := s.db.Query(`
+        SELECT n.id, u.nickname
+        FROM notifications n
+        JOIN users u ON n.sender_id = u.id
+        WHERE n.user_id = ?
+        ORDER BY n.created_at DESC`,
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []models.Notification
+	for rows.Next() {
+		var n models.Notification
+		if err := rows.Scan(&n.ID, &n.Sender); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+func (s *sqliteNotificationStore) Add(userID, senderID int) error {
+	_, err := s.db.Exec("INSERT INTO notifications (user_id, sender_id) VALUES (?, ?)", userID, senderID)
+	return err
+}
+
+func (s *sqliteNotificationStore) MarkRead(userID, senderID int) error {
+	_, err := s.db.Exec("UPDATE notifications SET is_read = true WHERE user_id = ? AND sender_id = ?", userID, senderID)
+	return err
+}
+
+func (s *sqliteNotificationStore) Dismiss(userID, senderID int) error {
+	_, err := s.db.Exec("DELETE FROM notifications WHERE user_id = ? AND sender_id = ?", userID, senderID)
+	return err
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"forum/models"
+)
+
+// PasswordResetStore keeps password reset links, keyed by the digest of their token
+type PasswordResetStore interface {
+	// Replace stores a new reset for the user and drops the user's earlier ones,
+	// so only the most recent link works
+	Replace(tokenHash string, reset *models.PasswordReset) error
+	// ByTokenHash returns the reset, used or expired or not
+	ByTokenHash(tokenHash string) (*models.PasswordReset, error)
+	// Redeem marks an unused reset as used, sets the user's new password and logs
+	// out every session of the user. It reports false if the reset was already used.
+	Redeem(tokenHash string, userID int, passwordHash string, at time.Time) (bool, error)
+}
+
+type sqlitePasswordResetStore struct {
+	db *sql.DB
+}
+
+func (s *sqlitePasswordResetStore) Replace(tokenHash string, reset *models.PasswordReset) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM password_resets WHERE user_id = ?", reset.UserID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO password_resets (token_hash, user_id, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		tokenHash, reset.UserID, reset.CreatedAt, reset.ExpiresAt,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlitePasswordResetStore) ByTokenHash(tokenHash string) (*models.PasswordReset, error) {
+	var reset models.PasswordReset
+	var usedAt sql.NullTime
+	err := s.db.QueryRow(
+		"SELECT user_id, created_at, expires_at, used_at FROM password_resets WHERE token_hash = ?",
+		tokenHash,
+	).Scan(&reset.UserID, &reset.CreatedAt, &reset.ExpiresAt, &usedAt)
+	if err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		reset.UsedAt = &usedAt.Time
+	}
+	return &reset, nil
+}
+
+func (s *sqlitePasswordResetStore) Redeem(tokenHash string, userID int, passwordHash string, at time.Time) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE password_resets SET used_at = ? WHERE token_hash = ? AND used_at IS NULL", at, tokenHash)
+	if err != nil {
+		return false, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return false, err
+	}
+
+	if _, err := tx.Exec("UPDATE users SET password = ? WHERE id = ?", passwordHash, userID); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec("DELETE FROM sessions WHERE user_id = ?", userID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"forum/models"
+)
+
+func TestPasswordResetReplacesEarlierLinks(t *testing.T) {
+	stores := newTestStores(t)
+	id := createTestUser(t, stores, "alice")
+	now := time.Now().UTC()
+
+	for _, tokenHash := range []string{"first", "second"} {
+		err := stores.PasswordResets.Replace(tokenHash, &models.PasswordReset{UserID: id, CreatedAt: now, ExpiresAt: now.Add(time.Hour)})
+		if err != nil {
+			t.Fatalf("Replace: %v", err)
+		}
+	}
+
+	if _, err := stores.PasswordResets.ByTokenHash("first"); err != sql.ErrNoRows {
+		t.Errorf("earlier link: err = %v, want sql.ErrNoRows", err)
+	}
+	reset, err := stores.PasswordResets.ByTokenHash("second")
+	if err != nil {
+		t.Fatalf("ByTokenHash: %v", err)
+	}
+	if reset.UserID != id || reset.UsedAt != nil {
+		t.Errorf("reset = %+v, want unused reset of user %d", reset, id)
+	}
+}
+
+func TestPasswordResetRedeemsOnce(t *testing.T) {
+	stores := newTestStores(t)
+	id := createTestUser(t, stores, "alice")
+	createTestSession(t, stores, id, "session")
+	now := time.Now().UTC()
+	if err := stores.PasswordResets.Replace("reset", &models.PasswordReset{UserID: id, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := stores.PasswordResets.Redeem("reset", id, "new hash", now); err != nil || !ok {
+		t.Fatalf("Redeem = %t, %v; want true", ok, err)
+	}
+	if ok, err := stores.PasswordResets.Redeem("reset", id, "other hash", now); err != nil || ok {
+		t.Fatalf("second Redeem = %t, %v; want false", ok, err)
+	}
+
+	user, err := stores.Users.ByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.PasswordHash != "new hash" {
+		t.Errorf("password hash = %q, want %q", user.PasswordHash, "new hash")
+	}
+	if _, err := stores.Sessions.ByTokenHash("session"); err != sql.ErrNoRows {
+		t.Errorf("session survived the reset: err = %v", err)
+	}
+}
@@ -0,0 +1,265 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"forum/models"
+)
+
+// UnknownCategoryError names a category a new post was filed under that does not exist
+type UnknownCategoryError string
+
+func (e UnknownCategoryError) Error() string {
+	return fmt.Sprintf("category %q not found", string(e))
+}
+
+// PostStore reads and writes posts, their categories and reactions
+type PostStore interface {
+	// List returns the posts filed under category, or all posts when it is empty,
+	// newest first. IsLike holds viewerID's reaction; it is left at 0 for guests (viewerID 0).
+	// Comments are not filled in.
+	List(category string, viewerID int) ([]models.PostWithLike, error)
+	Exists(id int) (bool, error)
+	AuthorID(id int) (int, error)
+	// LastPostTime is when the user last posted, sql.ErrNoRows if they never did
+	LastPostTime(userID int) (time.Time, error)
+	// Create stores a post under the named categories and returns its ID. It fails
+	// with an UnknownCategoryError, storing nothing, if one of them does not exist.
+	Create(userID int, title, content string, categories []string) (int64, error)
+	// Delete removes a post with its comments and reactions
+	Delete(id int) error
+	// React records the user's like (true) or dislike (false), or removes it when isLike is nil
+	React(userID, postID int, isLike *bool) error
+
+	Categories() ([]string, error)
+	// CreateCategory adds a category and reports false if it already existed
+	CreateCategory(name string) (bool, error)
+	// CategoryUsage returns the ID of the named category and how many posts are filed under it
+	CategoryUsage(name string) (id int, posts int, err error)
+	DeleteCategory(id int) error
+}
+
+type sqlitePostStore struct {
+	db *sql.DB
+}
+
+func (s *sqlitePostStore) List(category string, viewerID int) ([]models.PostWithLike, error) {
+	query := `
+		SELECT p.id, p.title, p.content, p.created_at, u.nickname,
+			(SELECT COUNT(*) FROM post_likes WHERE post_id = p.id AND is_like = true),
+			(SELECT COUNT(*) FROM post_likes WHERE post_id = p.id AND is_like = false),
+			(SELECT is_like FROM post_likes WHERE post_id = p.id AND user_id = ?)
+		FROM posts p
+		JOIN users u ON p.user_id = u.id`
+	args := []interface{}{viewerID}
+	if category != "" {
+		query += `
+		WHERE p.id IN (
+			SELECT pc.post_id
+			FROM post_categories pc
+			INNER JOIN categories c ON pc.category_id = c.id
+			WHERE c.name = ?
+		)`
+		args = append(args, category)
+	}
+	query += " ORDER BY p.created_at DESC"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []models.PostWithLike
+	for rows.Next() {
+		var p models.PostWithLike
+		var isLike sql.NullBool
+		if err := rows.Scan(&p.PostID, &p.Title, &p.Content, &p.CreatedAt, &p.Author, &p.LikeCount, &p.DislikeCount, &isLike); err != nil {
+			return nil, err
+		}
+		if viewerID != 0 {
+			p.IsLike = reaction(isLike)
+		}
+		posts = append(posts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range posts {
+		if posts[i].Categories, err = s.postCategories(posts[i].PostID); err != nil {
+			return nil, err
+		}
+	}
+	return posts, nil
+}
+
+func (s *sqlitePostStore) postCategories(postID int) ([]string, error) {
+	rows, err := s.db.Query(`
+		SELECT c.name
+		FROM categories c
+		INNER JOIN post_categories pc ON c.id = pc.category_id
+		WHERE pc.post_id = ?`,
+		postID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []string
+	for rows.Next() {
+		var category string
+		if err := rows.Scan(&category); err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+	return categories, rows.Err()
+}
+
+func (s *sqlitePostStore) Exists(id int) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM posts WHERE id = ?)", id).Scan(&exists)
+	return exists, err
+}
+
+func (s *sqlitePostStore) AuthorID(id int) (int, error) {
+	var authorID int
+	err := s.db.QueryRow("SELECT user_id FROM posts WHERE id = ?", id).Scan(&authorID)
+	return authorID, err
+}
+
+func (s *sqlitePostStore) LastPostTime(userID int) (time.Time, error) {
+	var lastPostTime time.Time
+	err := s.db.QueryRow(`
+		SELECT created_at
+		FROM posts
+		WHERE user_id = ?
+		ORDER BY created_at DESC
+		LIMIT 1`,
+		userID,
+	).Scan(&lastPostTime)
+	return lastPostTime, err
+}
+
+func (s *sqlitePostStore) Create(userID int, title, content string, categories []string) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"INSERT INTO posts (user_id, title, content, created_at) VALUES (?, ?, ?, ?)",
+		userID, title, content, time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	postID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, name := range categories {
+		var categoryID int
+		err := tx.QueryRow("SELECT id FROM categories WHERE name = ?", name).Scan(&categoryID)
+		if err == sql.ErrNoRows {
+			return 0, UnknownCategoryError(name)
+		} else if err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec("INSERT INTO post_categories (post_id, category_id) VALUES (?, ?)", postID, categoryID); err != nil {
+			return 0, err
+		}
+	}
+	return postID, tx.Commit()
+}
+
+func (s *sqlitePostStore) Delete(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		"DELETE FROM comment_likes WHERE comment_id IN (SELECT id FROM comments WHERE post_id = ?)",
+		"DELETE FROM comments WHERE post_id = ?",
+		"DELETE FROM post_likes WHERE post_id = ?",
+		"DELETE FROM post_categories WHERE post_id = ?",
+		"DELETE FROM posts WHERE id = ?",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlitePostStore) React(userID, postID int, isLike *bool) error {
+	if isLike == nil {
+		_, err := s.db.Exec("DELETE FROM post_likes WHERE user_id = ? AND post_id = ?", userID, postID)
+		return err
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO post_likes (user_id, post_id, is_like, created_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(user_id, post_id)
+		DO UPDATE SET
+			is_like = excluded.is_like,
+			created_at = CURRENT_TIMESTAMP`,
+		userID, postID, *isLike,
+	)
+	return err
+}
+
+func (s *sqlitePostStore) Categories() ([]string, error) {
+	rows, err := s.db.Query("SELECT name FROM categories")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []string
+	for rows.Next() {
+		var category string
+		if err := rows.Scan(&category); err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+	return categories, rows.Err()
+}
+
+func (s *sqlitePostStore) CreateCategory(name string) (bool, error) {
+	result, err := s.db.Exec("INSERT OR IGNORE INTO categories (name) VALUES (?)", name)
+	if err != nil {
+		return false, err
+	}
+	created, err := result.RowsAffected()
+	return created > 0, err
+}
+
+func (s *sqlitePostStore) CategoryUsage(name string) (int, int, error) {
+	var id, posts int
+	err := s.db.QueryRow(`
+		SELECT c.id, COUNT(pc.post_id)
+		FROM categories c
+		LEFT JOIN post_categories pc ON c.id = pc.category_id
+		WHERE c.name = ?
+		GROUP BY c.id`,
+		name,
+	).Scan(&id, &posts)
+	return id, posts, err
+}
+
+func (s *sqlitePostStore) DeleteCategory(id int) error {
+	_, err := s.db.Exec("DELETE FROM categories WHERE id = ?", id)
+	return err
+}
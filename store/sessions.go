@@ -0,0 +1,140 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"forum/models"
+)
+
+// SessionStore keeps the logged-in devices of each user, keyed by the digest
+// of their cookie token
+type SessionStore interface {
+	// Create stores a new session; Nickname and Role are ignored
+	Create(session *models.Session) error
+	// ByTokenHash returns the session with the user's nickname and role, expired or not
+	ByTokenHash(tokenHash string) (*models.Session, error)
+	// ListByUser returns the user's sessions, most recently active first
+	ListByUser(userID int) ([]*models.Session, error)
+	SetCSRFToken(tokenHash, csrfToken string) error
+	Touch(tokenHash string, at time.Time) error
+	MarkSecondFactor(tokenHash string, at time.Time) error
+	Delete(tokenHash string) error
+	// DeleteByUser removes every session of the user and reports how many there were
+	DeleteByUser(userID int) (int64, error)
+	// DeleteExpired removes the sessions that expired by now or have been idle
+	// since idleSince, and reports how many there were
+	DeleteExpired(now, idleSince time.Time) (int64, error)
+}
+
+type sqliteSessionStore struct {
+	db *sql.DB
+}
+
+func (s *sqliteSessionStore) Create(session *models.Session) error {
+	_, err := s.db.Exec(
+		"INSERT INTO sessions (token_hash, user_id, created_at, expires_at, last_seen_at, user_agent, ip_address, csrf_token) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		session.TokenHash,
+		session.UserID,
+		session.CreatedAt,
+		session.ExpiresAt,
+		session.LastSeenAt,
+		session.UserAgent,
+		session.IPAddress,
+		session.CSRFToken,
+	)
+	return err
+}
+
+func (s *sqliteSessionStore) ByTokenHash(tokenHash string) (*models.Session, error) {
+	session := &models.Session{TokenHash: tokenHash}
+	var lastSeen, mfaVerified sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT s.user_id, u.nickname, u.role, s.created_at, s.expires_at, s.last_seen_at, s.user_agent, s.ip_address, s.csrf_token, s.mfa_verified_at
+		FROM sessions s
+		JOIN users u ON s.user_id = u.id
+		WHERE s.token_hash = ?`,
+		tokenHash,
+	).Scan(&session.UserID, &session.Nickname, &session.Role, &session.CreatedAt, &session.ExpiresAt, &lastSeen, &session.UserAgent, &session.IPAddress, &session.CSRFToken, &mfaVerified)
+	if err != nil {
+		return nil, err
+	}
+
+	session.LastSeenAt = session.CreatedAt
+	if lastSeen.Valid {
+		session.LastSeenAt = lastSeen.Time
+	}
+	if mfaVerified.Valid {
+		session.MFAVerifiedAt = mfaVerified.Time
+	}
+	return session, nil
+}
+
+func (s *sqliteSessionStore) ListByUser(userID int) ([]*models.Session, error) {
+	rows, err := s.db.Query(`
+		SELECT token_hash, created_at, expires_at, last_seen_at, user_agent, ip_address
+		FROM sessions
+		WHERE user_id = ?
+		ORDER BY COALESCE(last_seen_at, created_at) DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*models.Session
+	for rows.Next() {
+		session := &models.Session{UserID: userID}
+		var lastSeen sql.NullTime
+		if err := rows.Scan(&session.TokenHash, &session.CreatedAt, &session.ExpiresAt, &lastSeen, &session.UserAgent, &session.IPAddress); err != nil {
+			return nil, err
+		}
+		session.LastSeenAt = session.CreatedAt
+		if lastSeen.Valid {
+			session.LastSeenAt = lastSeen.Time
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *sqliteSessionStore) SetCSRFToken(tokenHash, csrfToken string) error {
+	_, err := s.db.Exec("UPDATE sessions SET csrf_token = ? WHERE token_hash = ?", csrfToken, tokenHash)
+	return err
+}
+
+func (s *sqliteSessionStore) Touch(tokenHash string, at time.Time) error {
+	_, err := s.db.Exec("UPDATE sessions SET last_seen_at = ? WHERE token_hash = ?", at, tokenHash)
+	return err
+}
+
+func (s *sqliteSessionStore) MarkSecondFactor(tokenHash string, at time.Time) error {
+	_, err := s.db.Exec("UPDATE sessions SET mfa_verified_at = ? WHERE token_hash = ?", at, tokenHash)
+	return err
+}
+
+func (s *sqliteSessionStore) Delete(tokenHash string) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE token_hash = ?", tokenHash)
+	return err
+}
+
+func (s *sqliteSessionStore) DeleteByUser(userID int) (int64, error) {
+	result, err := s.db.Exec("DELETE FROM sessions WHERE user_id = ?", userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *sqliteSessionStore) DeleteExpired(now, idleSince time.Time) (int64, error) {
+	result, err := s.db.Exec(
+		"DELETE FROM sessions WHERE expires_at <= ? OR COALESCE(last_seen_at, created_at) <= ?",
+		now,
+		idleSince,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"forum/models"
+)
+
+func TestSessionRoundTrip(t *testing.T) {
+	stores := newTestStores(t)
+	id := createTestUser(t, stores, "alice")
+	createTestSession(t, stores, id, "digest")
+
+	session, err := stores.Sessions.ByTokenHash("digest")
+	if err != nil {
+		t.Fatalf("ByTokenHash: %v", err)
+	}
+	if session.UserID != id || session.Nickname != "alice" || session.Role != "member" {
+		t.Errorf("session = user %d %q role %q, want user %d \"alice\" role \"member\"", session.UserID, session.Nickname, session.Role, id)
+	}
+	if !session.MFAVerifiedAt.IsZero() {
+		t.Errorf("MFAVerifiedAt = %v, want zero", session.MFAVerifiedAt)
+	}
+
+	verifiedAt := time.Now().UTC().Truncate(time.Second)
+	if err := stores.Sessions.MarkSecondFactor("digest", verifiedAt); err != nil {
+		t.Fatal(err)
+	}
+	if err := stores.Sessions.SetCSRFToken("digest", "csrf"); err != nil {
+		t.Fatal(err)
+	}
+	session, err = stores.Sessions.ByTokenHash("digest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !session.MFAVerifiedAt.Equal(verifiedAt) || session.CSRFToken != "csrf" {
+		t.Errorf("session = MFA %v CSRF %q, want MFA %v CSRF \"csrf\"", session.MFAVerifiedAt, session.CSRFToken, verifiedAt)
+	}
+
+	if err := stores.Sessions.Delete("digest"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stores.Sessions.ByTokenHash("digest"); err != sql.ErrNoRows {
+		t.Errorf("deleted session: err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestListSessionsMostRecentlyActiveFirst(t *testing.T) {
+	stores := newTestStores(t)
+	id := createTestUser(t, stores, "alice")
+	createTestSession(t, stores, id, "older")
+	createTestSession(t, stores, id, "newer")
+	if err := stores.Sessions.Touch("older", time.Now().UTC().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := stores.Sessions.ListByUser(id)
+	if err != nil {
+		t.Fatalf("ListByUser: %v", err)
+	}
+	if len(sessions) != 2 || sessions[0].TokenHash != "older" || sessions[1].TokenHash != "newer" {
+		t.Fatalf("ListByUser returned %d sessions, want older (touched last) then newer", len(sessions))
+	}
+}
+
+func TestDeleteExpiredSessions(t *testing.T) {
+	stores := newTestStores(t)
+	id := createTestUser(t, stores, "alice")
+	now := time.Now().UTC()
+
+	for _, session := range []*models.Session{
+		{TokenHash: "live", CreatedAt: now, ExpiresAt: now.Add(time.Hour), LastSeenAt: now},
+		{TokenHash: "expired", CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour), LastSeenAt: now},
+		{TokenHash: "idle", CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(time.Hour), LastSeenAt: now.Add(-2 * time.Hour)},
+	} {
+		session.UserID = id
+		if err := stores.Sessions.Create(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	purged, err := stores.Sessions.DeleteExpired(now, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("DeleteExpired: %v", err)
+	}
+	if purged != 2 {
+		t.Errorf("purged %d sessions, want 2", purged)
+	}
+	if _, err := stores.Sessions.ByTokenHash("live"); err != nil {
+		t.Errorf("live session was purged: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+// Package store gives the handlers typed access to forum data. Each store is an
+// interface so handlers can be tested against any implementation; NewSQLite
+// builds the ones backed by the forum's SQLite database.
+//
+// Lookups of a single row report a missing row as sql.ErrNoRows, like database/sql.
+package store
+
+import "database/sql"
+
+// Stores bundles every store the handlers depend on
+type Stores struct {
+	Users         UserStore
+	Posts         PostStore
+	Comments      CommentStore
+	Chats         ChatStore
+	Notifications NotificationStore
+
+	Sessions       SessionStore
+	APITokens      APITokenStore
+	TwoFactor      TwoFactorStore
+	LoginAttempts  LoginAttemptStore
+	Invites        InviteStore
+	PasswordResets PasswordResetStore
+	Identities     IdentityStore
+}
+
+// NewSQLite returns stores that read and write the given database, which must
+// already be migrated to the latest schema
+func NewSQLite(db *sql.DB) Stores {
+	return Stores{
+		Users:         &sqliteUserStore{db},
+		Posts:         &sqlitePostStore{db},
+		Comments:      &sqliteCommentStore{db},
+		Chats:         &sqliteChatStore{db},
+		Notifications: &sqliteNotificationStore{db},
+
+		Sessions:       &sqliteSessionStore{db},
+		APITokens:      &sqliteAPITokenStore{db},
+		TwoFactor:      &sqliteTwoFactorStore{db},
+		LoginAttempts:  &sqliteLoginAttemptStore{db},
+		Invites:        &sqliteInviteStore{db},
+		PasswordResets: &sqlitePasswordResetStore{db},
+		Identities:     &sqliteIdentityStore{db},
+	}
+}
+
+// reaction turns a stored like (true), dislike (false) or no reaction (NULL)
+// into the IsLike code the front end expects: 1, 2 and -1
+func reaction(isLike sql.NullBool) int {
+	if !isLike.Valid {
+		return -1
+	}
+	if isLike.Bool {
+		return 1
+	}
+	return 2
+}
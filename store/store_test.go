@@ -0,0 +1,75 @@
+package store
+
+import (
+	"database/sql"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"forum/database"
+	"forum/models"
+)
+
+func TestMain(m *testing.M) {
+	// Every test database logs its settings and migrations
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// newTestStores returns stores on a fresh in-memory database migrated to the latest schema
+func newTestStores(t *testing.T) Stores {
+	t.Helper()
+	db, err := database.InitDB(database.MemoryConfig())
+	if err != nil {
+		t.Fatalf("opening test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return NewSQLite(db)
+}
+
+// createTestUser stores an account with the given nickname and returns its ID
+func createTestUser(t *testing.T, stores Stores, nickname string) int {
+	t.Helper()
+	id, err := stores.Users.Create(&models.User{
+		Nickname:     nickname,
+		Email:        nickname + "@example.com",
+		PasswordHash: "hash",
+		FirstName:    "Test",
+		LastName:     "User",
+	})
+	if err != nil {
+		t.Fatalf("creating user %s: %v", nickname, err)
+	}
+	return id
+}
+
+// createTestSession stores a session of the user that expires in an hour
+func createTestSession(t *testing.T, stores Stores, userID int, tokenHash string) {
+	t.Helper()
+	now := time.Now().UTC()
+	err := stores.Sessions.Create(&models.Session{
+		TokenHash:  tokenHash,
+		UserID:     userID,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(time.Hour),
+		LastSeenAt: now,
+	})
+	if err != nil {
+		t.Fatalf("creating session: %v", err)
+	}
+}
+
+func TestMemoryDatabasesAreSeparate(t *testing.T) {
+	first := newTestStores(t)
+	second := newTestStores(t)
+	createTestUser(t, first, "alice")
+
+	if _, err := second.Users.ByNickname("alice"); err != sql.ErrNoRows {
+		t.Fatalf("second database sees the first one's user: err = %v", err)
+	}
+	if _, err := first.Users.ByNickname("alice"); err != nil {
+		t.Fatalf("first database lost its user: %v", err)
+	}
+}
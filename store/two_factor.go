@@ -0,0 +1,100 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"forum/models"
+)
+
+// TwoFactorStore keeps pending second-factor challenges and the recovery codes
+// of each user, both keyed by digest
+type TwoFactorStore interface {
+	// CreateChallenge stores a challenge, first dropping those expired by now
+	CreateChallenge(tokenHash string, userID int, now, expiresAt time.Time) error
+	// Challenge returns a challenge with the user's nickname, expired or not
+	Challenge(tokenHash string) (*models.MFAChallenge, error)
+	AddChallengeAttempt(tokenHash string) error
+	DeleteChallenge(tokenHash string) error
+
+	// ReplaceRecoveryCodes swaps all of the user's recovery codes for new ones
+	ReplaceRecoveryCodes(userID int, codeHashes []string) error
+	// UseRecoveryCode marks an unused code as used and reports whether there was one
+	UseRecoveryCode(userID int, codeHash string, at time.Time) (bool, error)
+	DeleteRecoveryCodes(userID int) error
+}
+
+type sqliteTwoFactorStore struct {
+	db *sql.DB
+}
+
+func (s *sqliteTwoFactorStore) CreateChallenge(tokenHash string, userID int, now, expiresAt time.Time) error {
+	if _, err := s.db.Exec("DELETE FROM mfa_challenges WHERE expires_at <= ?", now); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(
+		"INSERT INTO mfa_challenges (token_hash, user_id, expires_at) VALUES (?, ?, ?)",
+		tokenHash, userID, expiresAt,
+	)
+	return err
+}
+
+func (s *sqliteTwoFactorStore) Challenge(tokenHash string) (*models.MFAChallenge, error) {
+	var c models.MFAChallenge
+	err := s.db.QueryRow(`
+		SELECT c.user_id, u.nickname, c.expires_at, c.attempts
+		FROM mfa_challenges c
+		JOIN users u ON c.user_id = u.id
+		WHERE c.token_hash = ?`,
+		tokenHash,
+	).Scan(&c.UserID, &c.Nickname, &c.ExpiresAt, &c.Attempts)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s *sqliteTwoFactorStore) AddChallengeAttempt(tokenHash string) error {
+	_, err := s.db.Exec("UPDATE mfa_challenges SET attempts = attempts + 1 WHERE token_hash = ?", tokenHash)
+	return err
+}
+
+func (s *sqliteTwoFactorStore) DeleteChallenge(tokenHash string) error {
+	_, err := s.db.Exec("DELETE FROM mfa_challenges WHERE token_hash = ?", tokenHash)
+	return err
+}
+
+func (s *sqliteTwoFactorStore) ReplaceRecoveryCodes(userID int, codeHashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	for _, codeHash := range codeHashes {
+		if _, err := tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, codeHash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteTwoFactorStore) UseRecoveryCode(userID int, codeHash string, at time.Time) (bool, error) {
+	result, err := s.db.Exec(
+		"UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		at, userID, codeHash,
+	)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+func (s *sqliteTwoFactorStore) DeleteRecoveryCodes(userID int) error {
+	_, err := s.db.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID)
+	return err
+}
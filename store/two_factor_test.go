@@ -0,0 +1,65 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestRecoveryCodesWorkOnce(t *testing.T) {
+	stores := newTestStores(t)
+	id := createTestUser(t, stores, "alice")
+	now := time.Now().UTC()
+
+	if err := stores.TwoFactor.ReplaceRecoveryCodes(id, []string{"old"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stores.TwoFactor.ReplaceRecoveryCodes(id, []string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		code string
+		want bool
+	}{
+		{"old", false},
+		{"a", true},
+		{"a", false},
+		{"b", true},
+	} {
+		used, err := stores.TwoFactor.UseRecoveryCode(id, tc.code, now)
+		if err != nil {
+			t.Fatalf("UseRecoveryCode(%q): %v", tc.code, err)
+		}
+		if used != tc.want {
+			t.Errorf("UseRecoveryCode(%q) = %t, want %t", tc.code, used, tc.want)
+		}
+	}
+}
+
+func TestChallengesExpireAndCountAttempts(t *testing.T) {
+	stores := newTestStores(t)
+	id := createTestUser(t, stores, "alice")
+	now := time.Now().UTC()
+
+	if err := stores.TwoFactor.CreateChallenge("stale", id, now.Add(-time.Hour), now.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := stores.TwoFactor.CreateChallenge("fresh", id, now, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stores.TwoFactor.Challenge("stale"); err != sql.ErrNoRows {
+		t.Errorf("expired challenge was kept: err = %v", err)
+	}
+
+	if err := stores.TwoFactor.AddChallengeAttempt("fresh"); err != nil {
+		t.Fatal(err)
+	}
+	challenge, err := stores.TwoFactor.Challenge("fresh")
+	if err != nil {
+		t.Fatalf("Challenge: %v", err)
+	}
+	if challenge.UserID != id || challenge.Nickname != "alice" || challenge.Attempts != 1 {
+		t.Errorf("challenge = %+v, want alice's with one attempt", challenge)
+	}
+}
@@ -0,0 +1,249 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"forum/models"
+)
+
+// AdminRole is the role SetRole never takes away from the last account holding it
+const AdminRole = "admin"
+
+// ErrLastAdmin is returned when a change would leave the forum without an admin
+var ErrLastAdmin = errors.New("the forum must keep at least one admin")
+
+// UserStore reads and updates accounts
+type UserStore interface {
+	// Create stores a new account and returns its ID; ID and Role are ignored
+	Create(user *models.User) (int, error)
+	ByID(id int) (*models.User, error)
+	ByNickname(nickname string) (*models.User, error)
+	ByEmail(email string) (*models.User, error)
+	// ByLogin finds the account whose nickname is identifier or whose email is
+	// identifier in lower case, as typed into the login form
+	ByLogin(identifier string) (*models.User, error)
+	IDByNickname(nickname string) (int, error)
+
+	// UpdateProfile replaces the personal details of an account
+	UpdateProfile(id int, firstName, lastName string, age *int, gender string) error
+	SetPassword(id int, passwordHash string) error
+	// ChangePassword sets a new password, voids pending password resets and logs
+	// out every session but the one with keepSessionHash. It reports how many
+	// sessions it ended.
+	ChangePassword(id int, passwordHash, keepSessionHash string) (int64, error)
+	// SetEmail changes the address, which then needs to be verified again
+	SetEmail(id int, email string) error
+	// VerifyEmail marks the address as verified if it is still the account's
+	// address, and reports whether it was
+	VerifyEmail(id int, email string) (bool, error)
+
+	// SetRole changes the role of an account, returning ErrLastAdmin instead of
+	// demoting the only admin
+	SetRole(id int, role string) error
+	CountByRole(role string) (int, error)
+
+	// TOTP returns the account's TOTP secret and the last time step accepted from it
+	TOTP(id int) (secret string, lastStep int64, err error)
+	// SetTOTPSecret stores a new secret; two-factor stays as it was
+	SetTOTPSecret(id int, secret string) error
+	// AdvanceTOTPStep records an accepted time step. It reports false if the
+	// step is not newer than the last one, so a code cannot be used twice.
+	AdvanceTOTPStep(id int, step int64) (bool, error)
+	EnableTwoFactor(id int) error
+	// DisableTwoFactor turns two-factor off and forgets the secret
+	DisableTwoFactor(id int) error
+}
+
+type sqliteUserStore struct {
+	db *sql.DB
+}
+
+const userColumns = "id, nickname, email, password, first_name, last_name, age, gender, role, email_verified, totp_enabled"
+
+func (s *sqliteUserStore) scanUser(row *sql.Row) (*models.User, error) {
+	var u models.User
+	var age sql.NullInt64
+	err := row.Scan(&u.ID, &u.Nickname, &u.Email, &u.PasswordHash, &u.FirstName, &u.LastName, &age, &u.Gender, &u.Role, &u.EmailVerified, &u.TwoFactorEnabled)
+	if err != nil {
+		return nil, err
+	}
+	if age.Valid {
+		value := int(age.Int64)
+		u.Age = &value
+	}
+	return &u, nil
+}
+
+// execer is a *sql.DB or a *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertUser adds the account with db, which may be a transaction
+func insertUser(db execer, user *models.User) (int, error) {
+	result, err := db.Exec(
+		"INSERT INTO users (nickname, email, password, first_name, last_name, age, gender, email_verified) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		user.Nickname,
+		user.Email,
+		user.PasswordHash,
+		user.FirstName,
+		user.LastName,
+		user.Age,
+		user.Gender,
+		user.EmailVerified,
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+func (s *sqliteUserStore) Create(user *models.User) (int, error) {
+	return insertUser(s.db, user)
+}
+
+func (s *sqliteUserStore) ByID(id int) (*models.User, error) {
+	return s.scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+}
+
+func (s *sqliteUserStore) ByNickname(nickname string) (*models.User, error) {
+	return s.scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE nickname = ?", nickname))
+}
+
+func (s *sqliteUserStore) ByEmail(email string) (*models.User, error) {
+	return s.scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE email = ?", email))
+}
+
+func (s *sqliteUserStore) ByLogin(identifier string) (*models.User, error) {
+	return s.scanUser(s.db.QueryRow(
+		"SELECT "+userColumns+" FROM users WHERE nickname = ? OR email = ?",
+		identifier, strings.ToLower(identifier),
+	))
+}
+
+func (s *sqliteUserStore) IDByNickname(nickname string) (int, error) {
+	var id int
+	err := s.db.QueryRow("SELECT id FROM users WHERE nickname = ?", nickname).Scan(&id)
+	return id, err
+}
+
+func (s *sqliteUserStore) UpdateProfile(id int, firstName, lastName string, age *int, gender string) error {
+	_, err := s.db.Exec(
+		"UPDATE users SET first_name = ?, last_name = ?, age = ?, gender = ? WHERE id = ?",
+		firstName, lastName, age, gender, id,
+	)
+	return err
+}
+
+func (s *sqliteUserStore) SetPassword(id int, passwordHash string) error {
+	_, err := s.db.Exec("UPDATE users SET password = ? WHERE id = ?", passwordHash, id)
+	return err
+}
+
+func (s *sqliteUserStore) ChangePassword(id int, passwordHash, keepSessionHash string) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET password = ? WHERE id = ?", passwordHash, id); err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec("UPDATE password_resets SET used_at = ? WHERE user_id = ? AND used_at IS NULL", time.Now().UTC(), id)
+	if err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec("DELETE FROM sessions WHERE user_id = ? AND token_hash != ?", id, keepSessionHash)
+	if err != nil {
+		return 0, err
+	}
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return revoked, tx.Commit()
+}
+
+func (s *sqliteUserStore) SetEmail(id int, email string) error {
+	_, err := s.db.Exec("UPDATE users SET email = ?, email_verified = FALSE WHERE id = ?", email, id)
+	return err
+}
+
+func (s *sqliteUserStore) VerifyEmail(id int, email string) (bool, error) {
+	result, err := s.db.Exec("UPDATE users SET email_verified = TRUE WHERE id = ? AND email = ?", id, email)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (s *sqliteUserStore) SetRole(id int, role string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if role != AdminRole {
+		var admins int
+		err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE role = ? AND id != ?", AdminRole, id).Scan(&admins)
+		if err != nil {
+			return err
+		}
+		var current string
+		if err := tx.QueryRow("SELECT role FROM users WHERE id = ?", id).Scan(&current); err != nil {
+			return err
+		}
+		if current == AdminRole && admins == 0 {
+			return ErrLastAdmin
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE users SET role = ? WHERE id = ?", role, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteUserStore) CountByRole(role string) (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM users WHERE role = ?", role).Scan(&count)
+	return count, err
+}
+
+func (s *sqliteUserStore) TOTP(id int) (string, int64, error) {
+	var secret string
+	var lastStep int64
+	err := s.db.QueryRow("SELECT totp_secret, totp_last_step FROM users WHERE id = ?", id).Scan(&secret, &lastStep)
+	return secret, lastStep, err
+}
+
+func (s *sqliteUserStore) SetTOTPSecret(id int, secret string) error {
+	_, err := s.db.Exec("UPDATE users SET totp_secret = ?, totp_last_step = 0 WHERE id = ?", secret, id)
+	return err
+}
+
+func (s *sqliteUserStore) AdvanceTOTPStep(id int, step int64) (bool, error) {
+	result, err := s.db.Exec("UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?", step, id, step)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+func (s *sqliteUserStore) EnableTwoFactor(id int) error {
+	_, err := s.db.Exec("UPDATE users SET totp_enabled = TRUE WHERE id = ?", id)
+	return err
+}
+
+func (s *sqliteUserStore) DisableTwoFactor(id int) error {
+	_, err := s.db.Exec("UPDATE users SET totp_enabled = FALSE, totp_secret = '', totp_last_step = 0 WHERE id = ?", id)
+	return err
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"testing"
+)
+
+func TestByLoginMatchesNicknameOrLowerCaseEmail(t *testing.T) {
+	stores := newTestStores(t)
+	id := createTestUser(t, stores, "alice")
+
+	for _, identifier := range []string{"alice", "alice@example.com", "Alice@Example.COM"} {
+		user, err := stores.Users.ByLogin(identifier)
+		if err != nil {
+			t.Fatalf("ByLogin(%q): %v", identifier, err)
+		}
+		if user.ID != id {
+			t.Errorf("ByLogin(%q) = user %d, want %d", identifier, user.ID, id)
+		}
+	}
+}
+
+func TestSetRoleKeepsLastAdmin(t *testing.T) {
+	stores := newTestStores(t)
+	alice := createTestUser(t, stores, "alice")
+	bob := createTestUser(t, stores, "bob")
+
+	if err := stores.Users.SetRole(alice, AdminRole); err != nil {
+		t.Fatalf("promoting alice: %v", err)
+	}
+	if err := stores.Users.SetRole(alice, "member"); err != ErrLastAdmin {
+		t.Fatalf("demoting the only admin: err = %v, want ErrLastAdmin", err)
+	}
+
+	if err := stores.Users.SetRole(bob, AdminRole); err != nil {
+		t.Fatalf("promoting bob: %v", err)
+	}
+	if err := stores.Users.SetRole(alice, "member"); err != nil {
+		t.Fatalf("demoting alice with bob still admin: %v", err)
+	}
+	if count, err := stores.Users.CountByRole(AdminRole); err != nil || count != 1 {
+		t.Fatalf("CountByRole(admin) = %d, %v; want 1", count, err)
+	}
+}
+
+func TestChangePasswordKeepsCurrentSession(t *testing.T) {
+	stores := newTestStores(t)
+	id := createTestUser(t, stores, "alice")
+	createTestSession(t, stores, id, "current")
+	createTestSession(t, stores, id, "other")
+
+	revoked, err := stores.Users.ChangePassword(id, "new hash", "current")
+	if err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+	if revoked != 1 {
+		t.Errorf("revoked %d sessions, want 1", revoked)
+	}
+
+	user, err := stores.Users.ByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.PasswordHash != "new hash" {
+		t.Errorf("password hash = %q, want %q", user.PasswordHash, "new hash")
+	}
+	if _, err := stores.Sessions.ByTokenHash("current"); err != nil {
+		t.Errorf("current session was ended: %v", err)
+	}
+	if _, err := stores.Sessions.ByTokenHash("other"); err == nil {
+		t.Error("other session survived the password change")
+	}
+}
+
+func TestAdvanceTOTPStepRejectsReplays(t *testing.T) {
+	stores := newTestStores(t)
+	id := createTestUser(t, stores, "alice")
+
+	for _, tc := range []struct {
+		step int64
+		want bool
+	}{
+		{10, true},
+		{10, false},
+		{9, false},
+		{11, true},
+	} {
+		advanced, err := stores.Users.AdvanceTOTPStep(id, tc.step)
+		if err != nil {
+			t.Fatalf("AdvanceTOTPStep(%d): %v", tc.step, err)
+		}
+		if advanced != tc.want {
+			t.Errorf("AdvanceTOTPStep(%d) = %t, want %t", tc.step, advanced, tc.want)
+		}
+	}
+}
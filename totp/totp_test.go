@@ -0,0 +1,105 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of RFC 6238 appendix B, "12345678901234567890", base32-encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// RFC 6238 appendix B lists 8-digit codes; authenticator apps show the last 6
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestCodeMatchesRFC6238(t *testing.T) {
+	for _, v := range rfcVectors {
+		code, err := Code(rfcSecret, Step(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != v.code {
+			t.Errorf("code at %d = %s, want %s", v.unix, code, v.code)
+		}
+	}
+}
+
+func TestCodeAcceptsHowSecretsAreTyped(t *testing.T) {
+	code, err := Code(" "+strings.ToLower(rfcSecret)+" ", Step(time.Unix(59, 0)))
+	if err != nil || code != "287082" {
+		t.Errorf("Code = %q, %v; want 287082", code, err)
+	}
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code accepted an invalid secret")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	at := time.Unix(1111111111, 0)
+	step := Step(at)
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", "050471", step, true},
+		{"with spaces", " 050 471 ", step, true},
+		{"previous step", mustCode(t, step-1), step - 1, true},
+		{"next step", mustCode(t, step+1), step + 1, true},
+		{"two steps old", mustCode(t, step-2), 0, false},
+		{"two steps ahead", mustCode(t, step+2), 0, false},
+		{"wrong code", "000000", 0, false},
+		{"too short", "05047", 0, false},
+		{"too long", "0504710", 0, false},
+	}
+	for _, tt := range tests {
+		gotStep, ok := Validate(rfcSecret, tt.code, at)
+		if ok != tt.wantOK || gotStep != tt.wantStep {
+			t.Errorf("%s: Validate(%q) = %d, %v; want %d, %v", tt.name, tt.code, gotStep, ok, tt.wantStep, tt.wantOK)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := GenerateSecret()
+	if a == b {
+		t.Error("two secrets are equal")
+	}
+	if _, err := Code(a, 1); err != nil {
+		t.Errorf("generated secret does not decode: %v", err)
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("My Forum", "alice", rfcSecret)
+	for _, want := range []string{"otpauth://totp/My%20Forum:alice?", "secret=" + rfcSecret, "issuer=My+Forum", "digits=6", "period=30", "algorithm=SHA1"} {
+		if !strings.Contains(uri, want) {
+			t.Errorf("URI %s does not contain %s", uri, want)
+		}
+	}
+}
+
+func mustCode(t *testing.T, step int64) string {
+	t.Helper()
+	code, err := Code(rfcSecret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
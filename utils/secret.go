@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LoadOrCreateSecret reads a hex-encoded signing key from path, generating and
+// saving a new random key on first run so signed links survive restarts
+func LoadOrCreateSecret(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		secret, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("reading secret %s: %w", path, err)
+		}
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("secret %s is too short: need at least 32 bytes", path)
+		}
+		return secret, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(secret)+"\n"), 0o600); err != nil {
+		return nil, fmt.Errorf("saving secret %s: %w", path, err)
+	}
+	return secret, nil
+}